@@ -0,0 +1,10 @@
+module ccserver
+
+go 1.13
+
+require (
+	github.com/hyperledger/fabric-chaincode-go v0.0.0-20200424173110-d7076418f212
+	github.com/hyperledger/fabric-protos-go v0.0.0-20200424173316-dd554ba3746e
+	github.com/stretchr/testify v1.5.1
+	google.golang.org/grpc v1.23.0
+)
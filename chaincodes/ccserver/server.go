@@ -0,0 +1,331 @@
+// Package ccserver runs the Go chaincodes either as a peer-launched chaincode or as an external
+// chaincode server (chaincode as a service), selected by the environment of the container.
+//
+// It is a standalone module, the chaincodes require it with a replace directive pointing at this
+// directory, e.g. replace ccserver => ../ccserver.
+package ccserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	defaultHealthAddress   = "0.0.0.0:8080"
+	defaultShutdownTimeout = 10 * time.Second
+	// same message size limits as the peer and the shim chaincode server
+	maxMessageSize = 100 * 1024 * 1024
+)
+
+// serverConfig holds the chaincode-as-a-service settings read from the environment:
+//
+//	CHAINCODE_SERVER_ADDRESS   listen address of the gRPC server, e.g. 0.0.0.0:9999; enables the server mode
+//	CHAINCODE_ID               package ID of the chaincode on the peer
+//	CHAINCODE_TLS_DISABLED     "true" to serve without TLS
+//	CHAINCODE_TLS_KEY          path of the server TLS key
+//	CHAINCODE_TLS_CERT         path of the server TLS certificate
+//	CHAINCODE_CLIENT_CA_CERT   path of the CA certificate used to verify the peer, optional
+//	CHAINCODE_HEALTH_ADDRESS   listen address of the /healthz endpoint, defaults to 0.0.0.0:8080
+//	CHAINCODE_SHUTDOWN_TIMEOUT how long the shutdown may take on SIGTERM, defaults to 10s
+type serverConfig struct {
+	Address         string
+	CCID            string
+	TLSProps        shim.TLSProperties
+	HealthAddress   string
+	ShutdownTimeout time.Duration
+}
+
+// Run starts cc as an external gRPC server when CHAINCODE_SERVER_ADDRESS is set,
+// otherwise it connects to the peer that launched the chaincode container
+func Run(cc shim.Chaincode) error {
+	if os.Getenv("CHAINCODE_SERVER_ADDRESS") == "" {
+		return shim.Start(cc)
+	}
+
+	config, err := serverConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	return serveChaincode(cc, config)
+}
+
+func serverConfigFromEnv() (serverConfig, error) {
+	config := serverConfig{
+		Address:         os.Getenv("CHAINCODE_SERVER_ADDRESS"),
+		CCID:            os.Getenv("CHAINCODE_ID"),
+		HealthAddress:   os.Getenv("CHAINCODE_HEALTH_ADDRESS"),
+		ShutdownTimeout: defaultShutdownTimeout,
+	}
+	if config.CCID == "" {
+		return config, fmt.Errorf("CHAINCODE_ID must be set when CHAINCODE_SERVER_ADDRESS is")
+	}
+	if config.HealthAddress == "" {
+		config.HealthAddress = defaultHealthAddress
+	}
+	if timeout := os.Getenv("CHAINCODE_SHUTDOWN_TIMEOUT"); timeout != "" {
+		duration, err := time.ParseDuration(timeout)
+		if err != nil {
+			return config, fmt.Errorf("invalid CHAINCODE_SHUTDOWN_TIMEOUT: %s", err.Error())
+		}
+		config.ShutdownTimeout = duration
+	}
+
+	if os.Getenv("CHAINCODE_TLS_DISABLED") == "true" {
+		config.TLSProps.Disabled = true
+		return config, nil
+	}
+
+	var err error
+	if config.TLSProps.Key, err = readEnvFile("CHAINCODE_TLS_KEY", true); err != nil {
+		return config, err
+	}
+	if config.TLSProps.Cert, err = readEnvFile("CHAINCODE_TLS_CERT", true); err != nil {
+		return config, err
+	}
+	if config.TLSProps.ClientCACerts, err = readEnvFile("CHAINCODE_CLIENT_CA_CERT", false); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// readEnvFile reads the file whose path is in the given environment variable
+func readEnvFile(name string, required bool) ([]byte, error) {
+	path := os.Getenv(name)
+	if path == "" {
+		if required {
+			return nil, fmt.Errorf("%s must be set unless CHAINCODE_TLS_DISABLED is true", name)
+		}
+		return nil, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", name, err.Error())
+	}
+	return content, nil
+}
+
+// serveChaincode runs the chaincode server and the health endpoint until SIGTERM or SIGINT.
+// It then stops accepting transactions, waits for the ones in flight and stops the gRPC server,
+// all within the shutdown timeout.
+func serveChaincode(cc shim.Chaincode, config serverConfig) error {
+	tracked := &trackedChaincode{cc: cc}
+	server, err := newGRPCServer(config, tracked)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", config.Address)
+	if err != nil {
+		return err
+	}
+
+	health := &http.Server{Addr: config.HealthAddress, Handler: healthHandler(tracked)}
+
+	errs := make(chan error, 2)
+	go func() {
+		fmt.Printf("Starting chaincode server on %s\n", config.Address)
+		errs <- server.Serve(listener)
+	}()
+	go func() {
+		fmt.Printf("Starting health endpoint on %s/healthz\n", config.HealthAddress)
+		if err := health.ListenAndServe(); err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	select {
+	case err := <-errs:
+		server.Stop()
+		return err
+	case sig := <-signals:
+		fmt.Printf("Received %s, shutting down the chaincode server\n", sig)
+	}
+
+	deadline := time.Now().Add(config.ShutdownTimeout)
+	if !tracked.drain(config.ShutdownTimeout) {
+		fmt.Println("Timed out waiting for the transactions in flight")
+	}
+	if !stopGRPCServer(server, time.Until(deadline)) {
+		fmt.Println("Timed out waiting for the peer to disconnect, closing the connections")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return health.Shutdown(ctx)
+}
+
+// newGRPCServer creates the gRPC server of the chaincode with the settings of shim.ChaincodeServer,
+// which does not give access to its server and therefore cannot be stopped
+func newGRPCServer(config serverConfig, cc shim.Chaincode) (*grpc.Server, error) {
+	if config.CCID == "" || config.Address == "" {
+		return nil, errors.New("the chaincode ID and the server address must be specified")
+	}
+
+	serverOpts := []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{Time: time.Minute, Timeout: 20 * time.Second}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: time.Minute, PermitWithoutStream: true}),
+		grpc.MaxSendMsgSize(maxMessageSize),
+		grpc.MaxRecvMsgSize(maxMessageSize),
+		grpc.ConnectionTimeout(5 * time.Second),
+	}
+	if !config.TLSProps.Disabled {
+		tlsConfig, err := serverTLSConfig(config.TLSProps)
+		if err != nil {
+			return nil, err
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	server := grpc.NewServer(serverOpts...)
+	// the shim ChaincodeServer only serves the Connect stream of the peer here
+	pb.RegisterChaincodeServer(server, &shim.ChaincodeServer{CCID: config.CCID, Address: config.Address, CC: cc})
+	return server, nil
+}
+
+// serverTLSConfig follows the TLS settings of the peer, the peer certificate is verified when
+// a client CA is given
+func serverTLSConfig(props shim.TLSProperties) (*tls.Config, error) {
+	if props.Key == nil || props.Cert == nil {
+		return nil, errors.New("the TLS key and certificate must be provided")
+	}
+	certificate, err := tls.X509KeyPair(props.Cert, props.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the TLS key pair: %s", err.Error())
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:             tls.VersionTLS12,
+		Certificates:           []tls.Certificate{certificate},
+		SessionTicketsDisabled: true,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		},
+	}
+	if props.ClientCACerts != nil {
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(props.ClientCACerts) {
+			return nil, errors.New("failed to load the client CA certificate")
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// stopGRPCServer stops the server gracefully, waiting for the peer to close its stream, and
+// reports whether it did so within timeout. The remaining connections are closed after timeout.
+func stopGRPCServer(server *grpc.Server, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		server.Stop()
+		<-done
+		return false
+	}
+}
+
+func healthHandler(tracked *trackedChaincode) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if tracked.isStopping() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"status":"SHUTTING_DOWN"}`)
+			return
+		}
+		fmt.Fprint(w, `{"status":"OK"}`)
+	})
+	return mux
+}
+
+// trackedChaincode keeps count of the transactions in flight so that they can complete on shutdown
+type trackedChaincode struct {
+	cc       shim.Chaincode
+	mutex    sync.Mutex
+	stopping bool
+	inFlight sync.WaitGroup
+}
+
+func (t *trackedChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	if !t.begin() {
+		return shim.Error("The chaincode server is shutting down")
+	}
+	defer t.inFlight.Done()
+	return t.cc.Init(stub)
+}
+
+func (t *trackedChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	if !t.begin() {
+		return shim.Error("The chaincode server is shutting down")
+	}
+	defer t.inFlight.Done()
+	return t.cc.Invoke(stub)
+}
+
+func (t *trackedChaincode) begin() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.stopping {
+		return false
+	}
+	t.inFlight.Add(1)
+	return true
+}
+
+func (t *trackedChaincode) isStopping() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.stopping
+}
+
+// drain rejects new transactions and reports whether the ones in flight completed within timeout
+func (t *trackedChaincode) drain(timeout time.Duration) bool {
+	t.mutex.Lock()
+	t.stopping = true
+	t.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,128 @@
+package ccserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// echoChaincode answers every transaction with its function name
+type echoChaincode struct{}
+
+func (echoChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (echoChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, _ := stub.GetFunctionAndParameters()
+	return shim.Success([]byte(function))
+}
+
+func setServerEnv(env map[string]string) func() {
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name := range env {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestServerConfigFromEnv(t *testing.T) {
+	defer setServerEnv(map[string]string{
+		"CHAINCODE_SERVER_ADDRESS":   "0.0.0.0:7052",
+		"CHAINCODE_ID":               "mygocc:abc",
+		"CHAINCODE_TLS_DISABLED":     "true",
+		"CHAINCODE_SHUTDOWN_TIMEOUT": "30s",
+	})()
+
+	config, err := serverConfigFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, "0.0.0.0:7052", config.Address)
+	assert.Equal(t, "mygocc:abc", config.CCID)
+	assert.True(t, config.TLSProps.Disabled)
+	assert.Equal(t, defaultHealthAddress, config.HealthAddress)
+	assert.Equal(t, 30*time.Second, config.ShutdownTimeout)
+
+	os.Unsetenv("CHAINCODE_TLS_DISABLED")
+	_, err = serverConfigFromEnv()
+	assert.EqualError(t, err, "CHAINCODE_TLS_KEY must be set unless CHAINCODE_TLS_DISABLED is true")
+
+	os.Unsetenv("CHAINCODE_ID")
+	_, err = serverConfigFromEnv()
+	assert.NotNil(t, err, "CHAINCODE_ID should be required")
+}
+
+func TestTrackedChaincodeDrain(t *testing.T) {
+	tracked := &trackedChaincode{cc: echoChaincode{}}
+	stub := shimtest.NewMockStub("mockTrackedStub", tracked)
+	health := httptest.NewServer(healthHandler(tracked))
+	defer health.Close()
+
+	response, err := http.Get(health.URL + "/healthz")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	result := stub.MockInvoke("1", [][]byte{[]byte("put")})
+	assert.EqualValues(t, shim.OK, result.Status, "put failed: "+result.Message)
+
+	// a transaction in flight holds up the shutdown until it completes
+	assert.True(t, tracked.begin())
+	assert.False(t, tracked.drain(10*time.Millisecond), "drain should wait for the transaction in flight")
+	tracked.inFlight.Done()
+	assert.True(t, tracked.drain(time.Second))
+
+	result = stub.MockInvoke("1", [][]byte{[]byte("get")})
+	assert.EqualValues(t, shim.ERROR, result.Status, "new transactions should be rejected while shutting down")
+
+	response, err = http.Get(health.URL + "/healthz")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+}
+
+func TestStopGRPCServer(t *testing.T) {
+	config := serverConfig{CCID: "mygocc:abc", Address: "127.0.0.1:0", TLSProps: shim.TLSProperties{Disabled: true}}
+	_, err := newGRPCServer(serverConfig{Address: config.Address}, echoChaincode{})
+	assert.NotNil(t, err, "the chaincode ID should be required")
+	_, err = newGRPCServer(serverConfig{CCID: config.CCID, Address: config.Address}, echoChaincode{})
+	assert.NotNil(t, err, "TLS should require a key pair")
+
+	serve := func() (*grpc.Server, string, chan error) {
+		server, err := newGRPCServer(config, echoChaincode{})
+		assert.Nil(t, err)
+		listener, err := net.Listen("tcp", config.Address)
+		assert.Nil(t, err)
+		stopped := make(chan error, 1)
+		go func() { stopped <- server.Serve(listener) }()
+		return server, listener.Addr().String(), stopped
+	}
+
+	// without peer connected the server stops right away
+	server, _, stopped := serve()
+	assert.True(t, stopGRPCServer(server, time.Second))
+	<-stopped
+
+	// a peer stream left open is closed once the timeout elapses
+	server, address, stopped := serve()
+	conn, err := grpc.Dial(address, grpc.WithInsecure(), grpc.WithBlock())
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = pb.NewChaincodeClient(conn).Connect(context.Background())
+	assert.Nil(t, err)
+
+	start := time.Now()
+	assert.False(t, stopGRPCServer(server, 50*time.Millisecond), "the open stream should hold up the graceful stop")
+	assert.True(t, time.Since(start) < time.Second, "the server should stop within the timeout")
+	<-stopped
+}
@@ -0,0 +1,54 @@
+package mangostub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+)
+
+// Collection is a private data collection definition, as found in collections_config.json
+type Collection struct {
+	Name              string `json:"name"`
+	Policy            string `json:"policy"`
+	RequiredPeerCount int    `json:"requiredPeerCount"`
+	MaxPeerCount      int    `json:"maxPeerCount"`
+	BlockToLive       uint64 `json:"blockToLive"`
+	MemberOnlyRead    bool   `json:"memberOnlyRead"`
+	MemberOnlyWrite   bool   `json:"memberOnlyWrite"`
+}
+
+// LoadCollections reads a collections configuration file
+func LoadCollections(path string) ([]Collection, error) {
+	configJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	collections := make([]Collection, 0)
+	if err := json.Unmarshal(configJSON, &collections); err != nil {
+		return nil, fmt.Errorf("invalid collections configuration %s: %s", path, err.Error())
+	}
+	return collections, nil
+}
+
+var policyPrincipal = regexp.MustCompile(`'([^'.]+)\.[a-z]+'`)
+
+// Members returns the MSP IDs of the collection policy. The policy is not evaluated, every
+// org it names is considered a member, which holds for the usual OR policies.
+func (c Collection) Members() []string {
+	members := make([]string, 0)
+	for _, match := range policyPrincipal.FindAllStringSubmatch(c.Policy, -1) {
+		members = append(members, match[1])
+	}
+	return members
+}
+
+// IsMember returns true if the policy of the collection names mspID
+func (c Collection) IsMember(mspID string) bool {
+	for _, member := range c.Members() {
+		if member == mspID {
+			return true
+		}
+	}
+	return false
+}
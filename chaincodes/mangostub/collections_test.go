@@ -0,0 +1,101 @@
+package mangostub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+type noopChaincode struct{}
+
+func (noopChaincode) Init(shim.ChaincodeStubInterface) pb.Response   { return shim.Success(nil) }
+func (noopChaincode) Invoke(shim.ChaincodeStubInterface) pb.Response { return shim.Success(nil) }
+
+func TestLoadCollections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collections")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "collections_config.json")
+	ioutil.WriteFile(path, []byte(`[{"name":"collectionA","policy":"OR('Org1MSP.member', 'Org2MSP.peer')","memberOnlyRead":true}]`), 0600)
+
+	collections, err := LoadCollections(path)
+	assert.Nil(t, err)
+	assert.Len(t, collections, 1)
+	assert.Equal(t, []string{"Org1MSP", "Org2MSP"}, collections[0].Members())
+	assert.True(t, collections[0].IsMember("Org2MSP"))
+	assert.False(t, collections[0].IsMember("Org3MSP"))
+
+	_, err = LoadCollections(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func TestCollectionAccess(t *testing.T) {
+	stub := NewMockStub("cc", noopChaincode{})
+	stub.SetCollections([]Collection{
+		{Name: "readers", Policy: "OR('Org1MSP.member')", MemberOnlyRead: true},
+		{Name: "writers", Policy: "OR('Org1MSP.member')", MemberOnlyWrite: true},
+	})
+
+	stub.MSPID = "Org1MSP"
+	stub.MockTransactionStart("1")
+	assert.Nil(t, stub.PutPrivateData("readers", "key1", []byte("value1")))
+	assert.Nil(t, stub.PutPrivateData("writers", "key1", []byte("value1")))
+	value, err := stub.GetPrivateData("readers", "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", string(value))
+	assert.NotNil(t, stub.PutPrivateData("unknown", "key1", []byte("value1")), "undefined collections should be rejected")
+	stub.MockTransactionEnd("1")
+
+	stub.MSPID = "Org2MSP"
+	stub.MockTransactionStart("2")
+	_, err = stub.GetPrivateData("readers", "key1")
+	assert.NotNil(t, err, "non members should not read a member only collection")
+	_, err = stub.GetPrivateDataByRange("readers", "", "")
+	assert.NotNil(t, err)
+	_, err = stub.GetPrivateDataQueryResult("readers", `{"selector":{}}`)
+	assert.NotNil(t, err)
+	hash, err := stub.GetPrivateDataHash("readers", "key1")
+	assert.Nil(t, err)
+	assert.NotNil(t, hash, "hashes are readable by non members")
+	assert.Nil(t, stub.PutPrivateData("readers", "key2", []byte("value2")))
+
+	assert.NotNil(t, stub.PutPrivateData("writers", "key2", []byte("value2")), "non members should not write a member only collection")
+	assert.NotNil(t, stub.DelPrivateData("writers", "key1"))
+	value, err = stub.GetPrivateData("writers", "key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", string(value))
+	stub.MockTransactionEnd("2")
+}
+
+func TestPrivateRanges(t *testing.T) {
+	stub := NewMockStub("cc", noopChaincode{})
+	stub.MockTransactionStart("1")
+	for _, key := range []string{"a", "b", "c"} {
+		stub.PutPrivateData("collectionA", key, []byte(key))
+		indexKey, _ := stub.CreateCompositeKey("index~key", []string{"x", key})
+		stub.PutPrivateData("collectionA", indexKey, []byte{0x00})
+	}
+	stub.DelPrivateData("collectionA", "c")
+	stub.MockTransactionEnd("1")
+
+	keys := func(it shim.StateQueryIteratorInterface, err error) []string {
+		assert.Nil(t, err)
+		result := make([]string, 0)
+		for it.HasNext() {
+			kv, _ := it.Next()
+			result = append(result, kv.Key)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"a", "b"}, keys(stub.GetPrivateDataByRange("collectionA", "", "")))
+	assert.Equal(t, []string{"b"}, keys(stub.GetPrivateDataByRange("collectionA", "b", "")))
+	assert.Len(t, keys(stub.GetPrivateDataByPartialCompositeKey("collectionA", "index~key", []string{"x"})), 3)
+	assert.Len(t, keys(stub.GetPrivateDataByPartialCompositeKey("collectionA", "index~key", []string{"y"})), 0)
+}
@@ -0,0 +1,460 @@
+package mangostub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Query is a CouchDB Mango query, as passed to GetQueryResult
+type Query struct {
+	Selector map[string]interface{} `json:"selector"`
+	Sort     []interface{}          `json:"sort"`
+	Fields   []string               `json:"fields"`
+	Limit    int                    `json:"limit"`
+	Skip     int                    `json:"skip"`
+}
+
+// Document is a state entry matched by a query
+type Document struct {
+	Key   string
+	Value []byte
+}
+
+// ParseQuery parses a Mango query string
+func ParseQuery(queryString string) (*Query, error) {
+	query := &Query{}
+	if err := json.Unmarshal([]byte(queryString), query); err != nil {
+		return nil, fmt.Errorf("invalid query: %s", err.Error())
+	}
+	if query.Selector == nil {
+		return nil, fmt.Errorf("invalid query: selector is required")
+	}
+	if err := validateSelector(query.Selector); err != nil {
+		return nil, err
+	}
+	for _, field := range query.Sort {
+		if _, _, err := sortField(field); err != nil {
+			return nil, err
+		}
+	}
+	if query.Limit < 0 || query.Skip < 0 {
+		return nil, fmt.Errorf("invalid query: limit and skip must not be negative")
+	}
+	return query, nil
+}
+
+// Execute returns the documents matching the query in key order, or in the query sort order.
+// Like CouchDB, values which are not JSON objects are never matched. The key can be selected
+// as the _id field.
+func (q *Query) Execute(docs []Document) ([]Document, error) {
+	type match struct {
+		doc    Document
+		fields map[string]interface{}
+	}
+
+	matches := make([]match, 0)
+	for _, doc := range docs {
+		fields, ok := decodeDocument(doc)
+		if !ok {
+			continue
+		}
+		matched, err := matchSelector(q.Selector, fields)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			matches = append(matches, match{doc, fields})
+		}
+	}
+
+	if len(q.Sort) == 0 {
+		sort.SliceStable(matches, func(i, j int) bool { return matches[i].doc.Key < matches[j].doc.Key })
+	} else {
+		sort.SliceStable(matches, func(i, j int) bool {
+			for _, field := range q.Sort {
+				path, desc, _ := sortField(field)
+				a, _ := lookup(matches[i].fields, path)
+				b, _ := lookup(matches[j].fields, path)
+				if c := compare(a, b); c != 0 {
+					return (c < 0) != desc
+				}
+			}
+			return matches[i].doc.Key < matches[j].doc.Key
+		})
+	}
+
+	if q.Skip >= len(matches) {
+		matches = matches[:0]
+	} else {
+		matches = matches[q.Skip:]
+	}
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+
+	results := make([]Document, 0, len(matches))
+	for _, m := range matches {
+		value := m.doc.Value
+		if len(q.Fields) > 0 {
+			projected, err := json.Marshal(project(m.fields, q.Fields))
+			if err != nil {
+				return nil, err
+			}
+			value = projected
+		}
+		results = append(results, Document{Key: m.doc.Key, Value: value})
+	}
+	return results, nil
+}
+
+func decodeDocument(doc Document) (map[string]interface{}, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(doc.Value))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil || fields == nil {
+		return nil, false
+	}
+	if _, ok := fields["_id"]; !ok {
+		fields["_id"] = doc.Key
+	}
+	return fields, true
+}
+
+func sortField(field interface{}) (string, bool, error) {
+	switch f := field.(type) {
+	case string:
+		return f, false, nil
+	case map[string]interface{}:
+		if len(f) == 1 {
+			for path, direction := range f {
+				switch direction {
+				case "asc":
+					return path, false, nil
+				case "desc":
+					return path, true, nil
+				}
+			}
+		}
+	}
+	return "", false, fmt.Errorf("invalid sort field %v, expecting a field name or {\"field\": \"asc\"|\"desc\"}", field)
+}
+
+// validateSelector rejects unknown operators up front, as CouchDB does
+func validateSelector(selector map[string]interface{}) error {
+	for key, value := range selector {
+		if strings.HasPrefix(key, "$") {
+			if !combinationOperators[key] && !conditionOperators[key] {
+				return fmt.Errorf("invalid operator: %s", key)
+			}
+			if _, ok := value.([]interface{}); !ok && (key == "$and" || key == "$or" || key == "$nor") {
+				return fmt.Errorf("invalid operator value: %s expects a list of selectors", key)
+			}
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if err := validateSelector(v); err != nil {
+				return err
+			}
+		case []interface{}:
+			if key == "$and" || key == "$or" || key == "$nor" {
+				for _, item := range v {
+					sub, ok := item.(map[string]interface{})
+					if !ok {
+						return fmt.Errorf("invalid operator value: %s expects a list of selectors", key)
+					}
+					if err := validateSelector(sub); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+var combinationOperators = map[string]bool{"$and": true, "$or": true, "$nor": true, "$not": true}
+
+var conditionOperators = map[string]bool{
+	"$eq": true, "$ne": true, "$gt": true, "$gte": true, "$lt": true, "$lte": true,
+	"$in": true, "$nin": true, "$exists": true, "$regex": true, "$size": true,
+}
+
+// matchSelector evaluates a selector against the fields of a document. Fields are matched
+// by name or by dotted path; a nested object without operators selects sub fields.
+func matchSelector(selector map[string]interface{}, doc map[string]interface{}) (bool, error) {
+	for key, value := range selector {
+		var matched bool
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			matched, err = matchCombination(key, value, doc)
+		case "$not":
+			sub, ok := value.(map[string]interface{})
+			if !ok {
+				return false, fmt.Errorf("invalid operator value: $not expects a selector")
+			}
+			matched, err = matchSelector(sub, doc)
+			matched = !matched
+		default:
+			fieldValue, exists := lookup(doc, key)
+			matched, err = matchField(value, fieldValue, exists, doc, key)
+		}
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchCombination(operator string, value interface{}, doc map[string]interface{}) (bool, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return false, fmt.Errorf("invalid operator value: %s expects a list of selectors", operator)
+	}
+	for _, item := range items {
+		sub, _ := item.(map[string]interface{})
+		matched, err := matchSelector(sub, doc)
+		if err != nil {
+			return false, err
+		}
+		if operator == "$or" && matched {
+			return true, nil
+		}
+		if operator == "$and" && !matched {
+			return false, nil
+		}
+		if operator == "$nor" && matched {
+			return false, nil
+		}
+	}
+	return operator != "$or", nil
+}
+
+// matchField evaluates the condition of one field: an implicit $eq for plain values,
+// operators, or a sub field selector for objects without operators
+func matchField(condition interface{}, value interface{}, exists bool, doc map[string]interface{}, path string) (bool, error) {
+	conditions, ok := condition.(map[string]interface{})
+	if !ok {
+		return exists && compare(value, condition) == 0, nil
+	}
+	if !hasOperators(conditions) {
+		// {"a": {"b": 1}} selects a.b
+		prefixed := make(map[string]interface{}, len(conditions))
+		for subPath, subCondition := range conditions {
+			prefixed[path+"."+subPath] = subCondition
+		}
+		return matchSelector(prefixed, doc)
+	}
+
+	for operator, argument := range conditions {
+		matched, err := matchOperator(operator, argument, value, exists)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func hasOperators(conditions map[string]interface{}) bool {
+	for key := range conditions {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOperator(operator string, argument interface{}, value interface{}, exists bool) (bool, error) {
+	if operator == "$exists" {
+		want, ok := argument.(bool)
+		if !ok {
+			return false, fmt.Errorf("invalid operator value: $exists expects a boolean")
+		}
+		return exists == want, nil
+	}
+	if !exists {
+		return false, nil
+	}
+
+	switch operator {
+	case "$eq":
+		return compare(value, argument) == 0, nil
+	case "$ne":
+		return compare(value, argument) != 0, nil
+	case "$gt":
+		return compare(value, argument) > 0, nil
+	case "$gte":
+		return compare(value, argument) >= 0, nil
+	case "$lt":
+		return compare(value, argument) < 0, nil
+	case "$lte":
+		return compare(value, argument) <= 0, nil
+	case "$in", "$nin":
+		list, ok := argument.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("invalid operator value: %s expects a list", operator)
+		}
+		found := containsAny(list, value)
+		return found == (operator == "$in"), nil
+	case "$regex":
+		pattern, ok := argument.(string)
+		if !ok {
+			return false, fmt.Errorf("invalid operator value: $regex expects a string")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, fmt.Errorf("invalid operator value: %s", err.Error())
+		}
+		s, ok := value.(string)
+		return ok && re.MatchString(s), nil
+	case "$size":
+		arr, ok := value.([]interface{})
+		return ok && compare(json.Number(fmt.Sprint(len(arr))), argument) == 0, nil
+	}
+	return false, fmt.Errorf("invalid operator: %s", operator)
+}
+
+// containsAny reports whether value, or one of its elements for an array, is in list
+func containsAny(list []interface{}, value interface{}) bool {
+	candidates := []interface{}{value}
+	if arr, ok := value.([]interface{}); ok {
+		candidates = arr
+	}
+	for _, candidate := range candidates {
+		for _, item := range list {
+			if compare(candidate, item) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookup returns the value at a dotted path
+func lookup(doc map[string]interface{}, path string) (interface{}, bool) {
+	if value, ok := doc[path]; ok {
+		return value, true
+	}
+	var current interface{} = doc
+	for _, name := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// project keeps the given dotted paths of a document
+func project(doc map[string]interface{}, fields []string) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, path := range fields {
+		value, ok := lookup(doc, path)
+		if !ok {
+			continue
+		}
+		names := strings.Split(path, ".")
+		target := result
+		for _, name := range names[:len(names)-1] {
+			next, ok := target[name].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				target[name] = next
+			}
+			target = next
+		}
+		target[names[len(names)-1]] = value
+	}
+	return result
+}
+
+// typeRank orders the JSON types like the CouchDB collation:
+// null, false, true, numbers, strings, arrays, objects
+func typeRank(value interface{}) int {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case bool:
+		if v {
+			return 2
+		}
+		return 1
+	case json.Number, float64:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	}
+	return 6
+}
+
+// compare orders two JSON values following the CouchDB collation, strings are
+// compared by code point rather than with the ICU collation
+func compare(a, b interface{}) int {
+	rankA, rankB := typeRank(a), typeRank(b)
+	if rankA != rankB {
+		return rankA - rankB
+	}
+
+	switch x := a.(type) {
+	case json.Number, float64:
+		fa, fb := toFloat(x), toFloat(b)
+		if fa < fb {
+			return -1
+		} else if fa > fb {
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, b.(string))
+	case []interface{}:
+		y := b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return len(x) - len(y)
+	case map[string]interface{}:
+		y := b.(map[string]interface{})
+		keysA, keysB := sortedKeys(x), sortedKeys(y)
+		for i := 0; i < len(keysA) && i < len(keysB); i++ {
+			if c := strings.Compare(keysA[i], keysB[i]); c != 0 {
+				return c
+			}
+			if c := compare(x[keysA[i]], y[keysB[i]]); c != 0 {
+				return c
+			}
+		}
+		return len(keysA) - len(keysB)
+	}
+	return 0
+}
+
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case float64:
+		return v
+	}
+	return 0
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,108 @@
+package mangostub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testDocs = []Document{
+	{Key: "marble1", Value: []byte(`{"color":"blue","size":35,"owner":{"name":"tom"},"tags":["shiny","new"]}`)},
+	{Key: "marble2", Value: []byte(`{"color":"red","size":50,"owner":{"name":"jerry"}}`)},
+	{Key: "marble3", Value: []byte(`{"color":"blue","size":70,"owner":{"name":"jerry"},"tags":["old"]}`)},
+	{Key: "marble4", Value: []byte(`{"color":"green","size":15}`)},
+	{Key: "raw", Value: []byte(`not json`)},
+	{Key: "list", Value: []byte(`["blue"]`)},
+}
+
+func execute(t *testing.T, queryString string) []string {
+	query, err := ParseQuery(queryString)
+	if !assert.Nil(t, err, "invalid query %s", queryString) {
+		return nil
+	}
+	results, err := query.Execute(testDocs)
+	assert.Nil(t, err)
+
+	keys := make([]string, 0)
+	for _, doc := range results {
+		keys = append(keys, doc.Key)
+	}
+	return keys
+}
+
+func TestSelectors(t *testing.T) {
+	tests := []struct {
+		selector string
+		keys     []string
+	}{
+		{`{"color":"blue"}`, []string{"marble1", "marble3"}},
+		{`{"color":{"$eq":"blue"},"size":{"$gt":40}}`, []string{"marble3"}},
+		{`{"size":{"$gte":35,"$lt":70}}`, []string{"marble1", "marble2"}},
+		{`{"size":{"$lte":15}}`, []string{"marble4"}},
+		{`{"color":{"$ne":"blue"}}`, []string{"marble2", "marble4"}},
+		{`{"color":{"$in":["red","green"]}}`, []string{"marble2", "marble4"}},
+		{`{"color":{"$nin":["red","green"]}}`, []string{"marble1", "marble3"}},
+		{`{"tags":{"$in":["old"]}}`, []string{"marble3"}},
+		{`{"color":{"$regex":"^b"}}`, []string{"marble1", "marble3"}},
+		{`{"owner.name":"jerry"}`, []string{"marble2", "marble3"}},
+		{`{"owner":{"name":"tom"}}`, []string{"marble1"}},
+		{`{"owner":{"$exists":false}}`, []string{"marble4"}},
+		{`{"tags":{"$size":2}}`, []string{"marble1"}},
+		{`{"$and":[{"color":"blue"},{"owner.name":"jerry"}]}`, []string{"marble3"}},
+		{`{"$or":[{"color":"red"},{"size":{"$lt":20}}]}`, []string{"marble2", "marble4"}},
+		{`{"$nor":[{"color":"blue"},{"color":"red"}]}`, []string{"marble4"}},
+		{`{"$not":{"color":"blue"}}`, []string{"marble2", "marble4"}},
+		{`{"_id":{"$gt":"marble2"}}`, []string{"marble3", "marble4"}},
+		{`{"size":{"$gt":"10"}}`, []string{}},
+		{`{"missing":{"$ne":1}}`, []string{}},
+	}
+	for _, test := range tests {
+		keys := execute(t, `{"selector":`+test.selector+`}`)
+		assert.Equal(t, test.keys, keys, "selector %s", test.selector)
+	}
+}
+
+func TestSortLimitSkip(t *testing.T) {
+	keys := execute(t, `{"selector":{"size":{"$gt":0}},"sort":[{"size":"desc"}]}`)
+	assert.Equal(t, []string{"marble3", "marble2", "marble1", "marble4"}, keys)
+
+	keys = execute(t, `{"selector":{"size":{"$gt":0}},"sort":["color",{"size":"desc"}]}`)
+	assert.Equal(t, []string{"marble3", "marble1", "marble4", "marble2"}, keys)
+
+	keys = execute(t, `{"selector":{"size":{"$gt":0}},"sort":[{"size":"asc"}],"limit":2,"skip":1}`)
+	assert.Equal(t, []string{"marble1", "marble2"}, keys)
+
+	keys = execute(t, `{"selector":{"size":{"$gt":0}},"skip":10}`)
+	assert.Empty(t, keys)
+}
+
+func TestFields(t *testing.T) {
+	query, err := ParseQuery(`{"selector":{"color":"red"},"fields":["size","owner.name","missing"]}`)
+	assert.Nil(t, err)
+	results, err := query.Execute(testDocs)
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.JSONEq(t, `{"size":50,"owner":{"name":"jerry"}}`, string(results[0].Value))
+
+	query, _ = ParseQuery(`{"selector":{"color":"red"}}`)
+	results, _ = query.Execute(testDocs)
+	assert.Equal(t, testDocs[1].Value, results[0].Value, "values are returned unchanged without fields")
+}
+
+func TestInvalidQueries(t *testing.T) {
+	for _, queryString := range []string{
+		`not json`,
+		`{"fields":["size"]}`,
+		`{"selector":{"size":{"$near":1}}}`,
+		`{"selector":{"$or":{"color":"red"}}}`,
+		`{"selector":{}, "sort":[{"size":"up"}]}`,
+		`{"selector":{}, "limit":-1}`,
+	} {
+		_, err := ParseQuery(queryString)
+		assert.NotNil(t, err, "query %s should be rejected", queryString)
+	}
+
+	query, _ := ParseQuery(`{"selector":{"color":{"$regex":"("}}}`)
+	_, err := query.Execute(testDocs)
+	assert.NotNil(t, err, "invalid regex should fail")
+}
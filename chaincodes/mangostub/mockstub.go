@@ -0,0 +1,275 @@
+// Package mangostub extends the shimtest MockStub with CouchDB rich queries so that chaincodes
+// relying on GetQueryResult and GetPrivateDataQueryResult can be unit tested.
+//
+// It is a standalone test-support module, the chaincodes require it with a replace directive
+// pointing at this directory, e.g. replace mangostub => ../mangostub.
+package mangostub
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// MockStub is a shimtest MockStub evaluating Mango queries against its public and private state.
+// It also implements the transient map and the private data functions missing from the MockStub.
+// Once collections are set, private data access follows their membership rules for the invoking
+// MSPID, like a peer enforces them for the client org.
+type MockStub struct {
+	*shimtest.MockStub
+	Transient   map[string][]byte
+	MSPID       string
+	collections map[string]Collection
+}
+
+// NewMockStub creates a MockStub for the chaincode. The MockStub passes itself, not this
+// wrapper, to the chaincode, hence the chaincode is wrapped to receive the wrapper instead.
+func NewMockStub(name string, cc shim.Chaincode) *MockStub {
+	stub := &MockStub{}
+	stub.MockStub = shimtest.NewMockStub(name, &chaincode{cc: cc, stub: stub})
+	return stub
+}
+
+type chaincode struct {
+	cc   shim.Chaincode
+	stub *MockStub
+}
+
+func (c *chaincode) Init(shim.ChaincodeStubInterface) pb.Response {
+	return c.cc.Init(c.stub)
+}
+
+func (c *chaincode) Invoke(shim.ChaincodeStubInterface) pb.Response {
+	return c.cc.Invoke(c.stub)
+}
+
+// SetCollections restricts the private data to the given collections and their members
+func (stub *MockStub) SetCollections(collections []Collection) {
+	stub.collections = make(map[string]Collection)
+	for _, collection := range collections {
+		stub.collections[collection.Name] = collection
+	}
+}
+
+// checkAccess returns an error if the invoking MSPID may not read, or write, the collection
+func (stub *MockStub) checkAccess(collection string, write bool) error {
+	if stub.collections == nil {
+		return nil
+	}
+	config, ok := stub.collections[collection]
+	if !ok {
+		return fmt.Errorf("collection %s is not defined", collection)
+	}
+	if write && config.MemberOnlyWrite && !config.IsMember(stub.MSPID) {
+		return fmt.Errorf("tx creator does not have write access permission on privatedata in chaincodeName:%s collectionName: %s", stub.Name, collection)
+	}
+	if !write && config.MemberOnlyRead && !config.IsMember(stub.MSPID) {
+		return fmt.Errorf("tx creator does not have read access permission on privatedata in chaincodeName:%s collectionName: %s", stub.Name, collection)
+	}
+	return nil
+}
+
+// GetTransient returns the Transient map
+func (stub *MockStub) GetTransient() (map[string][]byte, error) {
+	return stub.Transient, nil
+}
+
+// GetPrivateData returns a private value if the invoking MSPID can read the collection
+func (stub *MockStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	if err := stub.checkAccess(collection, false); err != nil {
+		return nil, err
+	}
+	return stub.MockStub.GetPrivateData(collection, key)
+}
+
+// PutPrivateData writes a private value if the invoking MSPID can write the collection
+func (stub *MockStub) PutPrivateData(collection string, key string, value []byte) error {
+	if err := stub.checkAccess(collection, true); err != nil {
+		return err
+	}
+	return stub.MockStub.PutPrivateData(collection, key, value)
+}
+
+// DelPrivateData deletes a private value if the invoking MSPID can write the collection
+func (stub *MockStub) DelPrivateData(collection string, key string) error {
+	if err := stub.checkAccess(collection, true); err != nil {
+		return err
+	}
+	delete(stub.PvtState[collection], key)
+	return nil
+}
+
+// GetPrivateDataHash returns the hash of a private value, readable by any org of the channel
+func (stub *MockStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	if stub.collections != nil {
+		if _, ok := stub.collections[collection]; !ok {
+			return nil, fmt.Errorf("collection %s is not defined", collection)
+		}
+	}
+	value, ok := stub.PvtState[collection][key]
+	if !ok {
+		return nil, nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+// GetPrivateDataByRange returns the private values of the range, composite keys excluded.
+// Empty start and end keys leave the range open.
+func (stub *MockStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if err := stub.checkAccess(collection, false); err != nil {
+		return nil, err
+	}
+	if startKey == "" {
+		startKey = "\x01"
+	}
+	return stub.privateResults(collection, func(key string) bool {
+		return key >= startKey && (endKey == "" || key < endKey)
+	}), nil
+}
+
+// GetPrivateDataByPartialCompositeKey returns the private values of the composite keys starting
+// with the given attributes
+func (stub *MockStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	if err := stub.checkAccess(collection, false); err != nil {
+		return nil, err
+	}
+	prefix, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return stub.privateResults(collection, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}), nil
+}
+
+func (stub *MockStub) privateResults(collection string, match func(key string) bool) *Iterator {
+	keys := make([]string, 0)
+	for key := range stub.PvtState[collection] {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	results := make([]*queryresult.KV, 0, len(keys))
+	for _, key := range keys {
+		results = append(results, &queryresult.KV{Namespace: collection, Key: key, Value: stub.PvtState[collection][key]})
+	}
+	return &Iterator{results: results}
+}
+
+// GetStateByPartialCompositeKeyWithPagination returns at most pageSize composite keys starting
+// with the given attributes, from bookmark on. The returned bookmark is the key the next page
+// starts at, empty once the keys are exhausted, like the peer does for LevelDB.
+func (stub *MockStub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if pageSize <= 0 {
+		return nil, nil, fmt.Errorf("invalid page size %d", pageSize)
+	}
+	resultsIterator, err := stub.MockStub.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &Iterator{results: make([]*queryresult.KV, 0)}
+	metadata := &pb.QueryResponseMetadata{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if kv.Key < bookmark {
+			continue
+		}
+		if len(page.results) == int(pageSize) {
+			metadata.Bookmark = kv.Key
+			break
+		}
+		page.results = append(page.results, kv)
+	}
+	metadata.FetchedRecordsCount = int32(len(page.results))
+	return page, metadata, nil
+}
+
+// GetQueryResult evaluates a Mango query against the public state, composite keys excluded
+func (stub *MockStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	docs := make([]Document, 0, len(stub.State))
+	for key, value := range stub.State {
+		if strings.HasPrefix(key, "\x00") {
+			continue
+		}
+		docs = append(docs, Document{Key: key, Value: value})
+	}
+	return executeQuery(stub.Name, query, docs)
+}
+
+// GetPrivateDataQueryResult evaluates a Mango query against a private data collection
+func (stub *MockStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	if err := stub.checkAccess(collection, false); err != nil {
+		return nil, err
+	}
+	docs := make([]Document, 0, len(stub.PvtState[collection]))
+	for key, value := range stub.PvtState[collection] {
+		if strings.HasPrefix(key, "\x00") {
+			continue
+		}
+		docs = append(docs, Document{Key: key, Value: value})
+	}
+	return executeQuery(collection, query, docs)
+}
+
+func executeQuery(namespace string, queryString string, docs []Document) (shim.StateQueryIteratorInterface, error) {
+	query, err := ParseQuery(queryString)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Key < docs[j].Key })
+
+	matches, err := query.Execute(docs)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*queryresult.KV, 0, len(matches))
+	for _, doc := range matches {
+		results = append(results, &queryresult.KV{Namespace: namespace, Key: doc.Key, Value: doc.Value})
+	}
+	return &Iterator{results: results}, nil
+}
+
+// Iterator iterates over query results
+type Iterator struct {
+	results []*queryresult.KV
+	closed  bool
+}
+
+// HasNext returns true while the iterator has results
+func (it *Iterator) HasNext() bool {
+	return !it.closed && len(it.results) > 0
+}
+
+// Next returns the next result
+func (it *Iterator) Next() (*queryresult.KV, error) {
+	if it.closed {
+		return nil, errors.New("iterator is closed")
+	}
+	if len(it.results) == 0 {
+		return nil, errors.New("no more results")
+	}
+	kv := it.results[0]
+	it.results = it.results[1:]
+	return kv, nil
+}
+
+// Close closes the iterator
+func (it *Iterator) Close() error {
+	it.closed = true
+	return nil
+}
@@ -0,0 +1,38 @@
+package mangostub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartialCompositeKeyPagination(t *testing.T) {
+	stub := NewMockStub("cc", noopChaincode{})
+	stub.MockTransactionStart("1")
+	indexKeys := make([]string, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		indexKey, _ := stub.CreateCompositeKey("index~key", []string{"x", key})
+		stub.PutState(indexKey, []byte{0x00})
+		indexKeys = append(indexKeys, indexKey)
+	}
+	stub.MockTransactionEnd("1")
+
+	page := func(bookmark string) ([]string, string) {
+		it, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination("index~key", []string{"x"}, 2, bookmark)
+		assert.Nil(t, err)
+		result := make([]string, 0)
+		for it.HasNext() {
+			kv, _ := it.Next()
+			result = append(result, kv.Key)
+		}
+		assert.EqualValues(t, len(result), metadata.FetchedRecordsCount)
+		return result, metadata.Bookmark
+	}
+
+	keys, bookmark := page("")
+	assert.Equal(t, indexKeys[:2], keys)
+	assert.Equal(t, indexKeys[2], bookmark)
+	keys, bookmark = page(bookmark)
+	assert.Equal(t, indexKeys[2:], keys)
+	assert.Empty(t, bookmark)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// object type of the composite key holding the JSON list of the admin MSP IDs
+const adminMSPsObjectType = "adminMSPs"
+
+// initAdminMSPs records the admin orgs given to Init as a JSON list, the channel members agree on
+// them with the chaincode definition. The admins are never derived from the Init caller. An empty
+// argument leaves the admins as they are; once recorded, they only change with setAdminMSPs.
+func initAdminMSPs(stub shim.ChaincodeStubInterface, adminMSPsJson string) error {
+	if adminMSPsJson == "" {
+		return nil
+	}
+	mspIDs, err := parseAdminMSPs(adminMSPsJson)
+	if err != nil {
+		return err
+	}
+	recorded, err := loadAdminMSPs(stub)
+	if err != nil {
+		return err
+	}
+	if recorded != nil {
+		if strings.Join(recorded, "\x00") != strings.Join(mspIDs, "\x00") {
+			return fmt.Errorf("The admin MSPs are already recorded as %v, use setAdminMSPs to change them", recorded)
+		}
+		return nil
+	}
+	return saveAdminMSPs(stub, mspIDs)
+}
+
+// parseAdminMSPs parses a non empty JSON list of MSP IDs
+func parseAdminMSPs(mspIDsJson string) ([]string, error) {
+	mspIDs := make([]string, 0)
+	if err := json.Unmarshal([]byte(mspIDsJson), &mspIDs); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling the MSP IDs. %s", err.Error())
+	}
+	if len(mspIDs) == 0 {
+		return nil, fmt.Errorf("At least one admin MSP ID is required")
+	}
+	for _, mspID := range mspIDs {
+		if mspID == "" {
+			return nil, fmt.Errorf("The admin MSP IDs must not be empty")
+		}
+	}
+	return mspIDs, nil
+}
+
+// setAdminMSPs replaces the admin orgs by the mspIDs JSON list, only admins may call it
+func (c *Chaincode) setAdminMSPs(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting mspIDs JSON list")
+	}
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mspIDs, err := parseAdminMSPs(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := saveAdminMSPs(stub, mspIDs); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func (c *Chaincode) getAdminMSPs(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	mspIDs, err := loadAdminMSPs(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if mspIDs == nil {
+		mspIDs = make([]string, 0)
+	}
+
+	mspIDsJSON, err := json.Marshal(mspIDs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(mspIDsJSON)
+}
+
+// checkAdmin returns an error unless the caller belongs to one of the admin orgs
+func checkAdmin(stub shim.ChaincodeStubInterface) error {
+	mspID, err := creatorMSPID(stub)
+	if err != nil {
+		return err
+	}
+	mspIDs, err := loadAdminMSPs(stub)
+	if err != nil {
+		return err
+	}
+	if mspIDs == nil {
+		return fmt.Errorf("No admin MSP is recorded, pass the admin MSP IDs to init")
+	}
+	for _, adminMSPID := range mspIDs {
+		if mspID == adminMSPID {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not an admin MSP of the chaincode", mspID)
+}
+
+// creatorMSPID returns the MSP ID of the transaction creator, whose identity the peer has
+// already validated against its MSP
+func creatorMSPID(stub shim.ChaincodeStubInterface) (string, error) {
+	creator, err := stub.GetCreator()
+	if err != nil {
+		return "", err
+	}
+	identity := &msp.SerializedIdentity{}
+	if err := proto.Unmarshal(creator, identity); err != nil {
+		return "", fmt.Errorf("Failed to unmarshal the creator identity. %s", err.Error())
+	}
+	if identity.Mspid == "" {
+		return "", fmt.Errorf("The creator identity has no MSP ID")
+	}
+	return identity.Mspid, nil
+}
+
+// loadAdminMSPs returns nil when no admin was recorded yet
+func loadAdminMSPs(stub shim.ChaincodeStubInterface) ([]string, error) {
+	mspIDsKey, err := stub.CreateCompositeKey(adminMSPsObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	mspIDsJSON, err := stub.GetState(mspIDsKey)
+	if err != nil || mspIDsJSON == nil {
+		return nil, err
+	}
+
+	mspIDs := make([]string, 0)
+	if err := json.Unmarshal(mspIDsJSON, &mspIDs); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling the admin MSP IDs. %s", err.Error())
+	}
+	return mspIDs, nil
+}
+
+func saveAdminMSPs(stub shim.ChaincodeStubInterface, mspIDs []string) error {
+	mspIDsKey, err := stub.CreateCompositeKey(adminMSPsObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	mspIDsJSON, err := json.Marshal(mspIDs)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Putting admin MSP IDs %v\n", mspIDs)
+	return stub.PutState(mspIDsKey, mspIDsJSON)
+}
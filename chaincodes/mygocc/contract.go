@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-contract-api-go/metadata"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Contract exposes the Chaincode functions through the contract API, with typed parameters
+// and results described by the generated metadata (org.hyperledger.fabric:GetMetadata).
+// The contract API capitalises the first letter of the invoked function, so callers keep
+// using the same names, e.g. put, scan or getHistoryForKey. The options are JSON strings,
+// empty for the defaults, and contractChaincode lets callers leave them out as with the
+// shim Chaincode.
+type Contract struct {
+	contractapi.Contract
+	cc Chaincode
+}
+
+func newContract() *Contract {
+	contract := new(Contract)
+	contract.Info = metadata.InfoMetadata{
+		Title:       "mygocc",
+		Description: "Generic key value store",
+		Version:     "1.0.0",
+	}
+	return contract
+}
+
+// contractChaincode serves the Contract with the argument lists of the shim Chaincode. The
+// contract API requires every parameter, so the omitted trailing options of the Contract names
+// are passed as empty strings, and the variable arguments of PutAll and DeleteAll as their JSON
+// list. The shim names called with such argument lists are served by the shim Chaincode, which
+// keeps their responses, e.g. the plain list of key-values of scan without options.
+type contractChaincode struct {
+	cc   *contractapi.ContractChaincode
+	shim Chaincode
+}
+
+// contractParams gives the number of required and total parameters of the Contract functions
+// whose trailing parameters may be omitted
+var contractParams = map[string][2]int{
+	"Init":                      {0, 1},
+	"Rename":                    {2, 3},
+	"Copy":                      {2, 3},
+	"ClonePrefix":               {4, 5},
+	"Scan":                      {2, 3},
+	"ScanPrefix":                {1, 2},
+	"ScanByPartialCompositeKey": {2, 3},
+}
+
+// contractListParams are the Contract functions taking their variable arguments as a single list
+var contractListParams = map[string]bool{"PutAll": true, "DeleteAll": true}
+
+func newContractChaincode() (shim.Chaincode, error) {
+	cc, err := contractapi.NewChaincode(newContract())
+	if err != nil {
+		return nil, err
+	}
+	return &contractChaincode{cc: cc}, nil
+}
+
+func (c *contractChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	function, params := stub.GetFunctionAndParameters()
+	if args, ok := contractArgs(function, params); ok {
+		if isShimName(function) {
+			return c.shim.Init(stub)
+		}
+		return c.cc.Init(&argsStub{stub, append([]string{function}, args...)})
+	}
+	return c.cc.Init(stub)
+}
+
+func (c *contractChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, params := stub.GetFunctionAndParameters()
+	stub = guardReservedKeys(function, stub)
+	if args, ok := contractArgs(function, params); ok {
+		if isShimName(function) {
+			return c.shim.Invoke(stub)
+		}
+		return c.cc.Invoke(&argsStub{stub, append([]string{function}, args...)})
+	}
+	return c.cc.Invoke(stub)
+}
+
+// contractArgs returns the parameters of the Contract function for the shim argument list
+// and whether they differ from it
+func contractArgs(function string, params []string) ([]string, bool) {
+	name := function[strings.LastIndex(function, ":")+1:]
+	if name == "" {
+		return nil, false
+	}
+	name = strings.ToUpper(name[:1]) + name[1:]
+
+	if contractListParams[name] {
+		// a single JSON list is already the parameter of the Contract function
+		if len(params) == 1 && json.Unmarshal([]byte(params[0]), &[]string{}) == nil {
+			return nil, false
+		}
+		paramsJson, err := json.Marshal(params)
+		if err != nil {
+			return nil, false
+		}
+		return []string{string(paramsJson)}, true
+	}
+
+	counts, ok := contractParams[name]
+	if !ok || len(params) < counts[0] || len(params) >= counts[1] {
+		return nil, false
+	}
+	args := make([]string, counts[1])
+	copy(args, params)
+	return args, true
+}
+
+// isShimName tells whether the function is called by its shim Chaincode name, e.g. scan for Scan
+func isShimName(function string) bool {
+	name := function[strings.LastIndex(function, ":")+1:]
+	return name != "" && unicode.IsLower([]rune(name)[0])
+}
+
+// argsStub replaces the arguments of the transaction
+type argsStub struct {
+	shim.ChaincodeStubInterface
+	args []string
+}
+
+func (stub *argsStub) GetArgs() [][]byte {
+	args := make([][]byte, 0, len(stub.args))
+	for _, arg := range stub.args {
+		args = append(args, []byte(arg))
+	}
+	return args
+}
+
+func (stub *argsStub) GetStringArgs() []string {
+	return stub.args
+}
+
+func (stub *argsStub) GetFunctionAndParameters() (string, []string) {
+	return stub.args[0], stub.args[1:]
+}
+
+// GetEvaluateTransactions lists the read only functions
+func (c *Contract) GetEvaluateTransactions() []string {
+	return []string{
+		"Get", "Scan", "ScanPrefix", "ScanByPartialCompositeKey", "ScanByPartialCompositeKeyForAttributes",
+		"Query", "GetHistoryForKey", "GetIndexConfig", "GetAdminMSPs", "FindDanglingIndexEntries", "GetDecrypted", "VerifyNotarization",
+		"GetSchemaVersion", "GetPrivate", "ScanPrivate", "QueryPrivate", "GetPrivateHash",
+		"VerifyPrivate", "GetKeyEndorsementPolicy",
+	}
+}
+
+// Init takes the JSON list of the admin MSP IDs, empty to leave the recorded ones
+func (c *Contract) Init(ctx contractapi.TransactionContextInterface, adminMSPs string) error {
+	_, err := c.call(ctx, c.cc.Init)
+	return err
+}
+
+func (c *Contract) Put(ctx contractapi.TransactionContextInterface, key string, value string) error {
+	_, err := c.invoke(ctx, c.cc.put, key, value)
+	return err
+}
+
+func (c *Contract) PutAll(ctx contractapi.TransactionContextInterface, keyValues []string) error {
+	_, err := c.invoke(ctx, c.cc.putAll, keyValues...)
+	return err
+}
+
+func (c *Contract) BulkPut(ctx contractapi.TransactionContextInterface, kvList []KV) error {
+	kvListJson, err := json.Marshal(kvList)
+	if err != nil {
+		return err
+	}
+	_, err = c.invoke(ctx, c.cc.bulkPut, string(kvListJson))
+	return err
+}
+
+func (c *Contract) BulkCreateCompositeKey(ctx contractapi.TransactionContextInterface, compositeKeyList []CompositeKey) error {
+	compositeKeyListJson, err := json.Marshal(compositeKeyList)
+	if err != nil {
+		return err
+	}
+	_, err = c.invoke(ctx, c.cc.bulkCreateCompositeKey, string(compositeKeyListJson))
+	return err
+}
+
+func (c *Contract) BulkDeleteCompositeKey(ctx contractapi.TransactionContextInterface, compositeKeyList []CompositeKey) error {
+	compositeKeyListJson, err := json.Marshal(compositeKeyList)
+	if err != nil {
+		return err
+	}
+	_, err = c.invoke(ctx, c.cc.bulkDeleteCompositeKey, string(compositeKeyListJson))
+	return err
+}
+
+func (c *Contract) Get(ctx contractapi.TransactionContextInterface, key string) (string, error) {
+	payload, err := c.invoke(ctx, c.cc.get, key)
+	return string(payload), err
+}
+
+func (c *Contract) Scan(ctx contractapi.TransactionContextInterface, startKey string, endKey string, options string) (ScanResult, error) {
+	result := ScanResult{}
+	err := c.invokeJSON(ctx, &result, c.cc.scan, startKey, endKey, options)
+	return result, err
+}
+
+func (c *Contract) ScanPrefix(ctx contractapi.TransactionContextInterface, prefix string, options string) (ScanResult, error) {
+	result := ScanResult{}
+	err := c.invokeJSON(ctx, &result, c.cc.scanPrefix, prefix, options)
+	return result, err
+}
+
+func (c *Contract) ScanByPartialCompositeKey(ctx contractapi.TransactionContextInterface, objectType string, attributes []string, options string) (CompositeScanResult, error) {
+	result := CompositeScanResult{}
+	attributesJson, err := json.Marshal(attributes)
+	if err != nil {
+		return result, err
+	}
+	err = c.invokeJSON(ctx, &result, c.cc.scanByPartialCompositeKey, objectType, string(attributesJson), options)
+	return result, err
+}
+
+func (c *Contract) ScanByPartialCompositeKeyForAttributes(ctx contractapi.TransactionContextInterface, objectType string, attributes []string) ([][]string, error) {
+	arr := make([][]string, 0)
+	attributesJson, err := json.Marshal(attributes)
+	if err != nil {
+		return arr, err
+	}
+	err = c.invokeJSON(ctx, &arr, c.cc.scanByPartialCompositeKeyForAttributes, objectType, string(attributesJson))
+	return arr, err
+}
+
+func (c *Contract) Query(ctx contractapi.TransactionContextInterface, queryString string) ([]KV, error) {
+	arr := make([]KV, 0)
+	err := c.invokeJSON(ctx, &arr, c.cc.query, queryString)
+	return arr, err
+}
+
+func (c *Contract) Delete(ctx contractapi.TransactionContextInterface, key string) error {
+	_, err := c.invoke(ctx, c.cc.delete, key)
+	return err
+}
+
+func (c *Contract) DeleteAll(ctx contractapi.TransactionContextInterface, keys []string) error {
+	_, err := c.invoke(ctx, c.cc.deleteAll, keys...)
+	return err
+}
+
+func (c *Contract) GetHistoryForKey(ctx contractapi.TransactionContextInterface, key string) ([]KeyModification, error) {
+	arr := make([]KeyModification, 0)
+	err := c.invokeJSON(ctx, &arr, c.cc.getHistoryForKey, key)
+	return arr, err
+}
+
+func (c *Contract) Rename(ctx contractapi.TransactionContextInterface, oldKey string, newKey string, options string) error {
+	_, err := c.invoke(ctx, c.cc.rename, oldKey, newKey, options)
+	return err
+}
+
+func (c *Contract) Copy(ctx contractapi.TransactionContextInterface, src string, dst string, options string) error {
+	_, err := c.invoke(ctx, c.cc.copy, src, dst, options)
+	return err
+}
+
+func (c *Contract) ClonePrefix(ctx contractapi.TransactionContextInterface, srcPrefix string, dstPrefix string, limit int, resumeKey string, options string) (ClonePrefixResult, error) {
+	result := ClonePrefixResult{}
+	err := c.invokeJSON(ctx, &result, c.cc.clonePrefix, srcPrefix, dstPrefix, strconv.Itoa(limit), resumeKey, options)
+	return result, err
+}
+
+func (c *Contract) SetIndexConfig(ctx contractapi.TransactionContextInterface, objectType string, config IndexConfig) error {
+	configJson, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	_, err = c.invoke(ctx, c.cc.setIndexConfig, objectType, string(configJson))
+	return err
+}
+
+func (c *Contract) GetIndexConfig(ctx contractapi.TransactionContextInterface, objectType string) (IndexConfig, error) {
+	config := IndexConfig{}
+	err := c.invokeJSON(ctx, &config, c.cc.getIndexConfig, objectType)
+	return config, err
+}
+
+// SetAdminMSPs replaces the orgs allowed to set the index configs, only their members may call it
+func (c *Contract) SetAdminMSPs(ctx contractapi.TransactionContextInterface, mspIDs []string) error {
+	mspIDsJson, err := json.Marshal(mspIDs)
+	if err != nil {
+		return err
+	}
+	_, err = c.invoke(ctx, c.cc.setAdminMSPs, string(mspIDsJson))
+	return err
+}
+
+func (c *Contract) GetAdminMSPs(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	arr := make([]string, 0)
+	err := c.invokeJSON(ctx, &arr, c.cc.getAdminMSPs)
+	return arr, err
+}
+
+// FindDanglingIndexEntries takes the ResumeKey of the previous call, empty to start
+func (c *Contract) FindDanglingIndexEntries(ctx contractapi.TransactionContextInterface, objectType string, limit int, resumeKey string) (DanglingIndexResult, error) {
+	result := DanglingIndexResult{}
+	err := c.invokeJSON(ctx, &result, c.cc.findDanglingIndexEntries, objectType, strconv.Itoa(limit), resumeKey)
+	return result, err
+}
+
+// RepairIndex takes the Dangling entries of a FindDanglingIndexEntries page
+func (c *Contract) RepairIndex(ctx contractapi.TransactionContextInterface, objectType string, dangling []CompositeKey) (RepairIndexResult, error) {
+	result := RepairIndexResult{}
+	danglingJson, err := json.Marshal(dangling)
+	if err != nil {
+		return result, err
+	}
+	err = c.invokeJSON(ctx, &result, c.cc.repairIndex, objectType, string(danglingJson))
+	return result, err
+}
+
+// Reindex writes the state key references of the index entries written before they existed,
+// call it again with the returned ResumeKey until it is empty
+func (c *Contract) Reindex(ctx contractapi.TransactionContextInterface, objectType string, limit int, resumeKey string) (ReindexResult, error) {
+	result := ReindexResult{}
+	err := c.invokeJSON(ctx, &result, c.cc.reindex, objectType, strconv.Itoa(limit), resumeKey)
+	return result, err
+}
+
+// PutEncrypted takes the encryption key and the value from the transient map
+func (c *Contract) PutEncrypted(ctx contractapi.TransactionContextInterface, key string) error {
+	_, err := c.invoke(ctx, c.cc.putEncrypted, key)
+	return err
+}
+
+func (c *Contract) GetDecrypted(ctx contractapi.TransactionContextInterface, key string) (string, error) {
+	payload, err := c.invoke(ctx, c.cc.getDecrypted, key)
+	return string(payload), err
+}
+
+func (c *Contract) Notarize(ctx contractapi.TransactionContextInterface, key string, payload string, signature string, pubKeyPEM string) (Notarization, error) {
+	notarization := Notarization{}
+	err := c.invokeJSON(ctx, &notarization, c.cc.notarize, key, payload, signature, pubKeyPEM)
+	return notarization, err
+}
+
+func (c *Contract) VerifyNotarization(ctx contractapi.TransactionContextInterface, key string, payload string) (Notarization, error) {
+	notarization := Notarization{}
+	err := c.invokeJSON(ctx, &notarization, c.cc.verifyNotarization, key, payload)
+	return notarization, err
+}
+
+func (c *Contract) GetSchemaVersion(ctx contractapi.TransactionContextInterface) (SchemaVersion, error) {
+	state := SchemaVersion{}
+	err := c.invokeJSON(ctx, &state, c.cc.getSchemaVersion)
+	return state, err
+}
+
+func (c *Contract) Migrate(ctx contractapi.TransactionContextInterface, limit int) (MigrationResult, error) {
+	result := MigrationResult{}
+	err := c.invokeJSON(ctx, &result, c.cc.migrate, strconv.Itoa(limit))
+	return result, err
+}
+
+// PutPrivate takes the value from the transient map
+func (c *Contract) PutPrivate(ctx contractapi.TransactionContextInterface, collection string, key string) error {
+	_, err := c.invoke(ctx, c.cc.putPrivate, collection, key)
+	return err
+}
+
+func (c *Contract) GetPrivate(ctx contractapi.TransactionContextInterface, collection string, key string) (string, error) {
+	payload, err := c.invoke(ctx, c.cc.getPrivate, collection, key)
+	return string(payload), err
+}
+
+func (c *Contract) ScanPrivate(ctx contractapi.TransactionContextInterface, collection string, startKey string, endKey string) ([]KV, error) {
+	arr := make([]KV, 0)
+	err := c.invokeJSON(ctx, &arr, c.cc.scanPrivate, collection, startKey, endKey)
+	return arr, err
+}
+
+func (c *Contract) QueryPrivate(ctx contractapi.TransactionContextInterface, collection string, queryString string) ([]KV, error) {
+	arr := make([]KV, 0)
+	err := c.invokeJSON(ctx, &arr, c.cc.queryPrivate, collection, queryString)
+	return arr, err
+}
+
+func (c *Contract) DeletePrivate(ctx contractapi.TransactionContextInterface, collection string, key string) error {
+	_, err := c.invoke(ctx, c.cc.deletePrivate, collection, key)
+	return err
+}
+
+func (c *Contract) GetPrivateHash(ctx contractapi.TransactionContextInterface, collection string, key string) (string, error) {
+	payload, err := c.invoke(ctx, c.cc.getPrivateHash, collection, key)
+	return string(payload), err
+}
+
+func (c *Contract) VerifyPrivate(ctx contractapi.TransactionContextInterface, collection string, key string, value string) error {
+	_, err := c.invoke(ctx, c.cc.verifyPrivate, collection, key, value)
+	return err
+}
+
+// SetKeyEndorsers takes the role MEMBER or PEER, an empty role means MEMBER
+func (c *Contract) SetKeyEndorsers(ctx contractapi.TransactionContextInterface, key string, mspIDs []string, role string) (KeyEndorsers, error) {
+	return c.updateKeyEndorsers(ctx, c.cc.setKeyEndorsers, key, mspIDs, role)
+}
+
+func (c *Contract) AddKeyEndorsers(ctx contractapi.TransactionContextInterface, key string, mspIDs []string, role string) (KeyEndorsers, error) {
+	return c.updateKeyEndorsers(ctx, c.cc.addKeyEndorsers, key, mspIDs, role)
+}
+
+func (c *Contract) RemoveKeyEndorsers(ctx contractapi.TransactionContextInterface, key string, mspIDs []string) (KeyEndorsers, error) {
+	endorsers := KeyEndorsers{}
+	mspIDsJson, err := json.Marshal(mspIDs)
+	if err != nil {
+		return endorsers, err
+	}
+	err = c.invokeJSON(ctx, &endorsers, c.cc.removeKeyEndorsers, key, string(mspIDsJson))
+	return endorsers, err
+}
+
+func (c *Contract) GetKeyEndorsementPolicy(ctx contractapi.TransactionContextInterface, key string) (KeyEndorsers, error) {
+	endorsers := KeyEndorsers{}
+	err := c.invokeJSON(ctx, &endorsers, c.cc.getKeyEndorsementPolicy, key)
+	return endorsers, err
+}
+
+func (c *Contract) updateKeyEndorsers(ctx contractapi.TransactionContextInterface, fn func(shim.ChaincodeStubInterface, []string) pb.Response, key string, mspIDs []string, role string) (KeyEndorsers, error) {
+	endorsers := KeyEndorsers{}
+	mspIDsJson, err := json.Marshal(mspIDs)
+	if err != nil {
+		return endorsers, err
+	}
+	err = c.invokeJSON(ctx, &endorsers, fn, key, string(mspIDsJson), role)
+	return endorsers, err
+}
+
+// invoke runs a Chaincode function with the given arguments and turns its response into an error
+func (c *Contract) invoke(ctx contractapi.TransactionContextInterface, fn func(shim.ChaincodeStubInterface, []string) pb.Response, args ...string) ([]byte, error) {
+	return c.call(ctx, func(stub shim.ChaincodeStubInterface) pb.Response {
+		return fn(stub, args)
+	})
+}
+
+// invokeJSON runs a Chaincode function and decodes its JSON payload into result
+func (c *Contract) invokeJSON(ctx contractapi.TransactionContextInterface, result interface{}, fn func(shim.ChaincodeStubInterface, []string) pb.Response, args ...string) error {
+	payload, err := c.invoke(ctx, fn, args...)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, result)
+}
+
+func (c *Contract) call(ctx contractapi.TransactionContextInterface, fn func(shim.ChaincodeStubInterface) pb.Response) ([]byte, error) {
+	response := fn(ctx.GetStub())
+	if response.Status >= shim.ERRORTHRESHOLD {
+		return nil, errors.New(response.Message)
+	}
+	return response.Payload, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func newContractStub(t *testing.T) *shimtest.MockStub {
+	contractChaincode, err := newContractChaincode()
+	assert.Nil(t, err, "contract API chaincode creation failed")
+	stub := shimtest.NewMockStub("mockContractStub", contractChaincode)
+	stub.Creator = creator("Org1MSP")
+	return stub
+}
+
+func TestContractSameFunctionNames(t *testing.T) {
+	stub := newContractStub(t)
+
+	result := stub.MockInit("1", [][]byte{[]byte("init")})
+	assert.EqualValues(t, shim.OK, result.Status, "init failed: "+result.Message)
+
+	result = stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("key1"), []byte("value1")})
+	assert.EqualValues(t, shim.OK, result.Status, "put failed: "+result.Message)
+
+	result = stub.MockInvoke("1", [][]byte{[]byte("bulkPut"), []byte(`[{"Key":"key2","Value":"value2"}]`)})
+	assert.EqualValues(t, shim.OK, result.Status, "bulkPut failed: "+result.Message)
+
+	result = stub.MockInvoke("1", [][]byte{[]byte("get"), []byte("key1")})
+	assert.EqualValues(t, shim.OK, result.Status, "get failed: "+result.Message)
+	assert.EqualValues(t, "value1", string(result.Payload))
+
+	result = stub.MockInvoke("1", [][]byte{[]byte("scan"), []byte("key1"), []byte("key3"), []byte(`{"Limit":10}`)})
+	assert.EqualValues(t, shim.OK, result.Status, "scan failed: "+result.Message)
+	var scanResult ScanResult
+	json.Unmarshal(result.Payload, &scanResult)
+	assert.Equal(t, []KV{{Key: "key1", Value: "value1"}, {Key: "key2", Value: "value2"}}, scanResult.Results)
+
+	result = stub.MockInvoke("1", [][]byte{[]byte("scanPrefix"), []byte("key"), []byte(`{"Limit":1}`)})
+	assert.EqualValues(t, shim.OK, result.Status, "scanPrefix failed: "+result.Message)
+	json.Unmarshal(result.Payload, &scanResult)
+	assert.Equal(t, []KV{{Key: "key1", Value: "value1"}}, scanResult.Results)
+	assert.True(t, scanResult.HasMore)
+
+	result = stub.MockInvoke("1", [][]byte{[]byte("rename"), []byte("key1"), []byte("key2"), []byte(`{"Overwrite":false}`)})
+	assert.EqualValues(t, shim.ERROR, result.Status, "errors should be passed through")
+	assert.Contains(t, result.Message, "key already exists")
+
+	result = stub.MockInvoke("1", [][]byte{[]byte("delete"), []byte("key1")})
+	assert.EqualValues(t, shim.OK, result.Status, "delete failed: "+result.Message)
+	val, _ := stub.GetState("key1")
+	assert.Nil(t, val, "Value has not been deleted")
+}
+
+func TestContractLegacyArguments(t *testing.T) {
+	stub := newContractStub(t)
+	stub.MockInit("1", [][]byte{[]byte("init")})
+
+	invoke := func(args ...string) []byte {
+		byteArgs := make([][]byte, 0, len(args))
+		for _, arg := range args {
+			byteArgs = append(byteArgs, []byte(arg))
+		}
+		result := stub.MockInvoke("1", byteArgs)
+		assert.EqualValues(t, shim.OK, result.Status, "%v failed: %s", args, result.Message)
+		return result.Payload
+	}
+	value := func(key string) string {
+		val, _ := stub.GetState(key)
+		return string(val)
+	}
+
+	invoke("putAll", "p1", "v1", "p2", "v2", "p3", "v3")
+	assert.Equal(t, "v2", value("p2"))
+	invoke("putAll", `["q1","w1"]`)
+	assert.Equal(t, "w1", value("q1"))
+
+	invoke("rename", "p1", "r1")
+	assert.Equal(t, "v1", value("r1"))
+	invoke("copy", "p2", "c2", "")
+	assert.Equal(t, "v2", value("c2"))
+	invoke("Copy", "p3", "c2", `{"overwrite":true}`)
+	assert.Equal(t, "v3", value("c2"))
+	invoke("clonePrefix", "p", "n", "10", "")
+	assert.Equal(t, "v3", value("n3"))
+
+	var scanResult ScanResult
+	json.Unmarshal(invoke("scanPrefix", "p"), &scanResult)
+	assert.Equal(t, []KV{{Key: "p2", Value: "v2"}, {Key: "p3", Value: "v3"}}, scanResult.Results)
+	// without options scan and scanByPartialCompositeKey return the plain list of key-values
+	assert.Equal(t, "[{\"Key\":\"c2\",\"Value\":\"v3\"}]\n", string(invoke("scan", "c", "d")))
+	json.Unmarshal(invoke("Scan", "c", "d"), &scanResult)
+	assert.Equal(t, []KV{{Key: "c2", Value: "v3"}}, scanResult.Results)
+
+	invoke("bulkCreateCompositeKey", `[{"ObjectType":"color~key","Attributes":["blue","p2"]}]`)
+	assert.Equal(t, "[{\"Key\":\"p2\",\"Value\":\"v2\"}]\n", string(invoke("scanByPartialCompositeKey", "color~key", `["blue"]`)))
+	var compositeResult CompositeScanResult
+	json.Unmarshal(invoke("scanByPartialCompositeKey", "color~key", `["blue"]`, ""), &compositeResult)
+	assert.Equal(t, []CompositeKV{{Key: "p2", Value: "v2"}}, compositeResult.Results)
+
+	invoke("deleteAll", "p2", "p3")
+	assert.Empty(t, value("p2"))
+	assert.Empty(t, value("p3"))
+	invoke("deleteAll", "q1")
+	assert.Empty(t, value("q1"))
+
+	result := stub.MockInvoke("1", [][]byte{[]byte("rename"), []byte("r1")})
+	assert.EqualValues(t, shim.ERROR, result.Status, "required parameters should not be filled in")
+}
+
+func TestContractMetadata(t *testing.T) {
+	stub := newContractStub(t)
+
+	result := stub.MockInvoke("1", [][]byte{[]byte("org.hyperledger.fabric:GetMetadata")})
+	assert.EqualValues(t, shim.OK, result.Status, "GetMetadata failed: "+result.Message)
+
+	var ccMetadata metadata.ContractChaincodeMetadata
+	err := json.Unmarshal(result.Payload, &ccMetadata)
+	assert.Nil(t, err, "Unable to unmarshal the metadata")
+
+	transactions := make(map[string]metadata.TransactionMetadata)
+	for _, transaction := range ccMetadata.Contracts["Contract"].Transactions {
+		transactions[transaction.Name] = transaction
+	}
+	assert.Contains(t, transactions, "Put")
+	assert.Contains(t, transactions, "GetHistoryForKey")
+	assert.Contains(t, transactions["Get"].Tag, "evaluate")
+	assert.Len(t, transactions["ClonePrefix"].Parameters, 5)
+	assert.Contains(t, ccMetadata.Components.Schemas, "KV")
+}
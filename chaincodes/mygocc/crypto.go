@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	encryptionKeyField   = "encryptionKey" // transient map field holding the 32 bytes AES-256 key
+	transientValueField  = "value"         // transient map field holding the value to put
+	encryptedValuePrefix = "enc:v1:"
+)
+
+// valueCipher encrypts values with AES-256-GCM. Encrypted values are stored as
+//
+//	enc:v1:<key ID>:<base64 nonce>:<base64 ciphertext>
+//
+// where the key ID is derived from the key, so that a value can be matched with its key
+// without trying to decrypt it. The header is authenticated along with the ciphertext.
+type valueCipher struct {
+	aead     cipher.AEAD
+	keyID    string
+	nonceKey []byte
+}
+
+func newValueCipher(key []byte) (*valueCipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("The encryption key must be 32 bytes long, got %d bytes", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := sha256.Sum256(key)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("nonce"))
+
+	return &valueCipher{
+		aead:     aead,
+		keyID:    hex.EncodeToString(fingerprint[:8]),
+		nonceKey: mac.Sum(nil),
+	}, nil
+}
+
+// transientCipher returns the cipher for the key passed in the transient map, nil if there is none
+func transientCipher(stub shim.ChaincodeStubInterface) (*valueCipher, error) {
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Error getting the transient map. %s", err.Error())
+	}
+	key, ok := transientMap[encryptionKeyField]
+	if !ok {
+		return nil, nil
+	}
+	return newValueCipher(key)
+}
+
+// nonce derives the GCM nonce from the transaction ID, the key and the plaintext. Every endorser
+// must produce the same write set, so the nonce cannot be random; a nonce would only repeat for
+// the same plaintext written to the same key by the same transaction.
+func (vc *valueCipher) nonce(txID, key string, plaintext []byte) []byte {
+	mac := hmac.New(sha256.New, vc.nonceKey)
+	mac.Write([]byte(txID))
+	mac.Write([]byte{0x00})
+	mac.Write([]byte(key))
+	mac.Write([]byte{0x00})
+	mac.Write(plaintext)
+	return mac.Sum(nil)[:vc.aead.NonceSize()]
+}
+
+func (vc *valueCipher) encrypt(txID, key string, plaintext []byte) []byte {
+	nonce := vc.nonce(txID, key, plaintext)
+	header := encryptedValuePrefix + vc.keyID + ":" + base64.StdEncoding.EncodeToString(nonce)
+	ciphertext := vc.aead.Seal(nil, nonce, plaintext, []byte(header))
+	return []byte(header + ":" + base64.StdEncoding.EncodeToString(ciphertext))
+}
+
+// decrypt returns the plaintext of a value encrypted with this key; ok is false when
+// the value is not encrypted or was encrypted with another key
+func (vc *valueCipher) decrypt(value []byte) (plaintext []byte, ok bool, err error) {
+	keyID, ok := encryptedValueKeyID(value)
+	if !ok || keyID != vc.keyID {
+		return nil, false, nil
+	}
+
+	parts := strings.Split(string(value[len(encryptedValuePrefix):]), ":")
+	if len(parts) != 3 {
+		return nil, true, fmt.Errorf("Malformed encrypted value")
+	}
+	nonce, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(nonce) != vc.aead.NonceSize() {
+		return nil, true, fmt.Errorf("Malformed encrypted value nonce")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, true, fmt.Errorf("Malformed encrypted value ciphertext")
+	}
+
+	header := encryptedValuePrefix + parts[0] + ":" + parts[1]
+	plaintext, err = vc.aead.Open(nil, nonce, ciphertext, []byte(header))
+	if err != nil {
+		return nil, true, fmt.Errorf("Unable to decrypt the value. %s", err.Error())
+	}
+	return plaintext, true, nil
+}
+
+// encryptedValueKeyID returns the key ID in the header of an encrypted value
+func encryptedValueKeyID(value []byte) (string, bool) {
+	if !bytes.HasPrefix(value, []byte(encryptedValuePrefix)) {
+		return "", false
+	}
+	rest := string(value[len(encryptedValuePrefix):])
+	end := strings.Index(rest, ":")
+	if end < 0 {
+		return "", false
+	}
+	return rest[:end], true
+}
+
+// putEncrypted encrypts the value of the transient map with the key in the transient map before
+// putting it. Like putPrivate it does not take the value as argument, which would be recorded in
+// clear in the transaction.
+func (c *Chaincode) putEncrypted(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting key, the value must be passed in the transient map")
+	}
+	key := args[0]
+
+	vc, err := transientCipher(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if vc == nil {
+		return shim.Error(encryptionKeyField + " must be passed in the transient map")
+	}
+
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return shim.Error("Error getting the transient map. " + err.Error())
+	}
+	value, ok := transientMap[transientValueField]
+	if !ok {
+		return shim.Error(transientValueField + " must be passed in the transient map")
+	}
+
+	fmt.Printf("Putting encrypted key='%s'\n", key)
+	err = stub.PutState(key, vc.encrypt(stub.GetTxID(), key, value))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// getDecrypted returns the value of a key decrypted with the key in the transient map
+func (c *Chaincode) getDecrypted(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting key")
+	}
+	key := args[0]
+
+	vc, err := transientCipher(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if vc == nil {
+		return shim.Error(encryptionKeyField + " must be passed in the transient map")
+	}
+
+	fmt.Printf("Getting encrypted key='%s'\n", key)
+	value, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if value == nil {
+		return shim.Success(nil)
+	}
+
+	plaintext, ok, err := vc.decrypt(value)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !ok {
+		if keyID, encrypted := encryptedValueKeyID(value); encrypted {
+			return shim.Error("The value is encrypted with another key, key ID " + keyID)
+		}
+		return shim.Error("The value is not encrypted")
+	}
+
+	return shim.Success(plaintext)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// transientStub adds the transient map the MockStub does not implement
+type transientStub struct {
+	*shimtest.MockStub
+	transient map[string][]byte
+}
+
+func (stub *transientStub) GetTransient() (map[string][]byte, error) {
+	return stub.transient, nil
+}
+
+// transientChaincode passes its transient map to the chaincode on each call
+type transientChaincode struct {
+	cc        shim.Chaincode
+	transient map[string][]byte
+}
+
+func (t *transientChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return t.cc.Init(&transientStub{stub.(*shimtest.MockStub), t.transient})
+}
+
+func (t *transientChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return t.cc.Invoke(&transientStub{stub.(*shimtest.MockStub), t.transient})
+}
+
+// useTransientStub replaces the suite stub with one whose transient map can be set
+func (suite *ChaincodeTS) useTransientStub() *transientChaincode {
+	cc := &transientChaincode{cc: new(Chaincode)}
+	suite.stub = shimtest.NewMockStub("mockTransientStub", cc)
+	return cc
+}
+
+var (
+	testEncryptionKey  = bytes.Repeat([]byte{0x01}, 32)
+	otherEncryptionKey = bytes.Repeat([]byte{0x02}, 32)
+)
+
+func (suite *ChaincodeTS) TestPutEncrypted() {
+	cc := suite.useTransientStub()
+	cc.transient = map[string][]byte{encryptionKeyField: testEncryptionKey, transientValueField: []byte("secret")}
+
+	result := suite.stub.MockInvoke("tx1", [][]byte{[]byte("putEncrypted"), []byte("key1")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "putEncrypted failed: "+result.Message)
+
+	stored, _ := suite.stub.GetState("key1")
+	assert.True(suite.T(), strings.HasPrefix(string(stored), encryptedValuePrefix), "value should carry the header")
+	assert.NotContains(suite.T(), string(stored), "secret")
+
+	// endorsers simulating the same transaction produce the same ciphertext
+	result = suite.stub.MockInvoke("tx1", [][]byte{[]byte("putEncrypted"), []byte("key2")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status)
+	suite.stub.MockInvoke("tx1", [][]byte{[]byte("putEncrypted"), []byte("key1")})
+	sameTx, _ := suite.stub.GetState("key1")
+	assert.Equal(suite.T(), stored, sameTx)
+	suite.stub.MockInvoke("tx2", [][]byte{[]byte("putEncrypted"), []byte("key1")})
+	otherTx, _ := suite.stub.GetState("key1")
+	assert.NotEqual(suite.T(), stored, otherTx)
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("getDecrypted"), []byte("key1")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "getDecrypted failed: "+result.Message)
+	assert.Equal(suite.T(), "secret", string(result.Payload))
+
+	cc.transient = map[string][]byte{encryptionKeyField: otherEncryptionKey}
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("getDecrypted"), []byte("key1")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "decryption with another key should fail")
+	assert.Contains(suite.T(), result.Message, "another key")
+
+	cc.transient = map[string][]byte{encryptionKeyField: testEncryptionKey}
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("putEncrypted"), []byte("key1"), []byte("secret")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "a value passed as argument should be rejected")
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("putEncrypted"), []byte("key1")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "putEncrypted without a value should fail")
+
+	cc.transient = map[string][]byte{transientValueField: []byte("secret")}
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("putEncrypted"), []byte("key1")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "putEncrypted without a key should fail")
+}
+
+func (suite *ChaincodeTS) TestDecryptTamperedValue() {
+	cc := suite.useTransientStub()
+	cc.transient = map[string][]byte{encryptionKeyField: testEncryptionKey, transientValueField: []byte("secret")}
+	suite.stub.MockInvoke("tx1", [][]byte{[]byte("putEncrypted"), []byte("key1")})
+
+	stored, _ := suite.stub.GetState("key1")
+	parts := strings.Split(string(stored), ":")
+	ciphertext, _ := base64.StdEncoding.DecodeString(parts[4])
+	ciphertext[0] ^= 0xff
+	parts[4] = base64.StdEncoding.EncodeToString(ciphertext)
+	tampered := []byte(strings.Join(parts, ":"))
+	suite.stub.MockTransactionStart("tx2")
+	suite.stub.PutState("key1", tampered)
+	suite.stub.MockTransactionEnd("tx2")
+
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("getDecrypted"), []byte("key1")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "a tampered value should not decrypt")
+	assert.Contains(suite.T(), result.Message, "Unable to decrypt")
+}
+
+func (suite *ChaincodeTS) TestScanDecrypts() {
+	cc := suite.useTransientStub()
+	cc.transient = map[string][]byte{encryptionKeyField: testEncryptionKey, transientValueField: []byte(`{"color":"red"}`)}
+	suite.stub.MockInvoke("tx1", [][]byte{[]byte("putEncrypted"), []byte("key1")})
+	suite.stub.MockInvoke("tx2", [][]byte{[]byte("put"), []byte("key2"), []byte(`{"color":"blue"}`)})
+	cc.transient = map[string][]byte{encryptionKeyField: otherEncryptionKey, transientValueField: []byte(`{"color":"red"}`)}
+	suite.stub.MockInvoke("tx3", [][]byte{[]byte("putEncrypted"), []byte("key3")})
+
+	cc.transient = map[string][]byte{encryptionKeyField: testEncryptionKey}
+	scanResult := suite.scanResult("scanPrefix", "key")
+	assert.Equal(suite.T(), `{"color":"red"}`, scanResult.Results[0].Value)
+	assert.Equal(suite.T(), `{"color":"blue"}`, scanResult.Results[1].Value)
+	assert.True(suite.T(), strings.HasPrefix(scanResult.Results[2].Value, encryptedValuePrefix),
+		"values encrypted with another key are left as they are")
+
+	// filters apply to the decrypted values
+	scanResult = suite.scanResult("scan", "key1", "key4", `{"filter":"$.color == 'red'"}`)
+	assert.Equal(suite.T(), []string{"key1"}, resultKeys(scanResult))
+
+	cc.transient = nil
+	scanResult = suite.scanResult("scanPrefix", "key")
+	assert.True(suite.T(), strings.HasPrefix(scanResult.Results[0].Value, encryptedValuePrefix))
+}
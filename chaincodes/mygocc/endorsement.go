@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// KeyEndorsers lists the orgs that must all endorse the updates of a key. Without orgs
+// the chaincode endorsement policy applies.
+type KeyEndorsers struct {
+	Orgs []string `json:"orgs"`
+}
+
+// setKeyEndorsers replaces the endorsement policy of a key by one requiring every org of
+// the mspIDs JSON list. The optional role is MEMBER (default) or PEER.
+func (c *Chaincode) setKeyEndorsers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 2 || len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting key, mspIDs JSON list and an optional role")
+	}
+	return updateKeyEndorsers(stub, args[0], args[1], args[2:], func(ep statebased.KeyEndorsementPolicy, role statebased.RoleType, mspIDs []string) error {
+		ep.DelOrgs(ep.ListOrgs()...)
+		return ep.AddOrgs(role, mspIDs...)
+	})
+}
+
+// addKeyEndorsers adds orgs to the endorsement policy of a key
+func (c *Chaincode) addKeyEndorsers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 2 || len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting key, mspIDs JSON list and an optional role")
+	}
+	return updateKeyEndorsers(stub, args[0], args[1], args[2:], func(ep statebased.KeyEndorsementPolicy, role statebased.RoleType, mspIDs []string) error {
+		return ep.AddOrgs(role, mspIDs...)
+	})
+}
+
+// removeKeyEndorsers removes orgs from the endorsement policy of a key, removing the last
+// one restores the chaincode endorsement policy
+func (c *Chaincode) removeKeyEndorsers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting key and mspIDs JSON list")
+	}
+	return updateKeyEndorsers(stub, args[0], args[1], nil, func(ep statebased.KeyEndorsementPolicy, role statebased.RoleType, mspIDs []string) error {
+		ep.DelOrgs(mspIDs...)
+		return nil
+	})
+}
+
+func (c *Chaincode) getKeyEndorsementPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting key")
+	}
+	key := args[0]
+
+	policy, err := stub.GetStateValidationParameter(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ep, err := statebased.NewStateEP(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return encodeKeyEndorsers(ep)
+}
+
+// updateKeyEndorsers applies update to the endorsement policy of an existing key
+func updateKeyEndorsers(stub shim.ChaincodeStubInterface, key string, mspIDsJson string, roleArgs []string,
+	update func(statebased.KeyEndorsementPolicy, statebased.RoleType, []string) error) pb.Response {
+	mspIDs := make([]string, 0)
+	err := json.Unmarshal([]byte(mspIDsJson), &mspIDs)
+	if err != nil {
+		return shim.Error("Error unmarshalling the mspIDs list. " + err.Error())
+	}
+
+	role := statebased.RoleTypeMember
+	if len(roleArgs) > 0 && roleArgs[0] != "" {
+		role = statebased.RoleType(strings.ToUpper(roleArgs[0]))
+		if role != statebased.RoleTypeMember && role != statebased.RoleTypePeer {
+			return shim.Error("role must be MEMBER or PEER")
+		}
+	}
+
+	value, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if value == nil {
+		return shim.Error("key does not exist: " + key)
+	}
+
+	policy, err := stub.GetStateValidationParameter(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ep, err := statebased.NewStateEP(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := update(ep, role, mspIDs); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// a policy without orgs would be satisfied by any endorsement, remove it instead
+	policy = nil
+	if len(ep.ListOrgs()) > 0 {
+		policy, err = ep.Policy()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	fmt.Printf("Setting the endorsers of key='%s' orgs=%v\n", key, ep.ListOrgs())
+	err = stub.SetStateValidationParameter(key, policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return encodeKeyEndorsers(ep)
+}
+
+func encodeKeyEndorsers(ep statebased.KeyEndorsementPolicy) pb.Response {
+	endorsers := KeyEndorsers{Orgs: ep.ListOrgs()}
+	sort.Strings(endorsers.Orgs)
+
+	endorsersJson, err := json.Marshal(endorsers)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(endorsersJson)
+}
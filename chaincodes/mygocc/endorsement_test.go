@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *ChaincodeTS) keyEndorsers(args ...string) KeyEndorsers {
+	invokeArgs := make([][]byte, 0)
+	for _, arg := range args {
+		invokeArgs = append(invokeArgs, []byte(arg))
+	}
+	result := suite.stub.MockInvoke("1", invokeArgs)
+	assert.EqualValues(suite.T(), shim.OK, result.Status, args[0]+" failed: "+result.Message)
+
+	var endorsers KeyEndorsers
+	err := json.Unmarshal(result.Payload, &endorsers)
+	assert.Nil(suite.T(), err, "Unable to unmarshal the key endorsers")
+	return endorsers
+}
+
+func (suite *ChaincodeTS) TestKeyEndorsers() {
+	suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("key1"), []byte("value1")})
+
+	endorsers := suite.keyEndorsers("getKeyEndorsementPolicy", "key1")
+	assert.Empty(suite.T(), endorsers.Orgs)
+
+	endorsers = suite.keyEndorsers("setKeyEndorsers", "key1", `["Org2MSP","Org1MSP"]`)
+	assert.Equal(suite.T(), []string{"Org1MSP", "Org2MSP"}, endorsers.Orgs)
+	policy, _ := suite.stub.GetStateValidationParameter("key1")
+	assert.NotNil(suite.T(), policy)
+
+	endorsers = suite.keyEndorsers("addKeyEndorsers", "key1", `["Org3MSP"]`, "PEER")
+	assert.Equal(suite.T(), []string{"Org1MSP", "Org2MSP", "Org3MSP"}, endorsers.Orgs)
+
+	endorsers = suite.keyEndorsers("setKeyEndorsers", "key1", `["Org3MSP"]`)
+	assert.Equal(suite.T(), []string{"Org3MSP"}, endorsers.Orgs)
+
+	endorsers = suite.keyEndorsers("getKeyEndorsementPolicy", "key1")
+	assert.Equal(suite.T(), []string{"Org3MSP"}, endorsers.Orgs)
+	// the same encoding as the private key endorsers of pdc
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("getKeyEndorsementPolicy"), []byte("key1")})
+	assert.JSONEq(suite.T(), `{"orgs":["Org3MSP"]}`, string(result.Payload))
+
+	// removing the last org restores the chaincode endorsement policy
+	endorsers = suite.keyEndorsers("removeKeyEndorsers", "key1", `["Org3MSP"]`)
+	assert.Empty(suite.T(), endorsers.Orgs)
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("getKeyEndorsementPolicy"), []byte("key1")})
+	assert.JSONEq(suite.T(), `{"orgs":[]}`, string(result.Payload))
+	policy, _ = suite.stub.GetStateValidationParameter("key1")
+	assert.Empty(suite.T(), policy)
+}
+
+func (suite *ChaincodeTS) TestKeyEndorsersErrors() {
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("setKeyEndorsers"), []byte("missing"), []byte(`["Org1MSP"]`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "endorsers of a missing key should not be set")
+
+	suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("key1"), []byte("value1")})
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("setKeyEndorsers"), []byte("key1"), []byte(`["Org1MSP"]`), []byte("ADMIN")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "unknown roles should be rejected")
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("setKeyEndorsers"), []byte("key1"), []byte(`Org1MSP`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "mspIDs must be a JSON list")
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RecordFilter selects and trims JSON values server side, for state databases without rich queries.
+//
+// Filter is a list of comparisons joined by &&, each one made of a JSONPath, an operator
+// (==, !=, <, <=, >, >=) and a JSON literal, e.g. `$.owner == "tom" && $.size >= 10`.
+// Fields lists the JSONPaths to keep in the returned values, e.g. ["$.owner", "size"].
+// Values which are not JSON never match when a filter or fields are given.
+type RecordFilter struct {
+	Filter string   `metadata:",optional"`
+	Fields []string `metadata:",optional"`
+}
+
+type pathSegment struct {
+	Name  string
+	Index int // used when Name is empty
+}
+
+type filterClause struct {
+	Path  []pathSegment
+	Op    string
+	Value interface{}
+}
+
+type compiledFilter struct {
+	clauses []filterClause
+	fields  [][]pathSegment
+}
+
+var filterOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// compile parses the filter and fields; it returns nil when there is nothing to apply
+func (f RecordFilter) compile() (*compiledFilter, error) {
+	if strings.TrimSpace(f.Filter) == "" && len(f.Fields) == 0 {
+		return nil, nil
+	}
+
+	compiled := &compiledFilter{}
+	if strings.TrimSpace(f.Filter) != "" {
+		for _, clauseString := range splitOutsideQuotes(f.Filter, "&&") {
+			clause, err := parseFilterClause(clauseString)
+			if err != nil {
+				return nil, err
+			}
+			compiled.clauses = append(compiled.clauses, clause)
+		}
+	}
+
+	for _, field := range f.Fields {
+		field = strings.TrimSpace(field)
+		if !strings.HasPrefix(field, "$") {
+			field = "$." + field
+		}
+		path, rest, err := parseJSONPath(field)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("unexpected '%s' in field %s", rest, field)
+		}
+		for _, segment := range path {
+			if segment.Name == "" {
+				return nil, fmt.Errorf("array indexes are not supported in fields: %s", field)
+			}
+		}
+		if len(path) == 0 {
+			return nil, fmt.Errorf("empty field path: %s", field)
+		}
+		compiled.fields = append(compiled.fields, path)
+	}
+
+	return compiled, nil
+}
+
+// apply reports whether value matches the filter and returns it trimmed to the selected fields
+func (c *compiledFilter) apply(value []byte) ([]byte, bool) {
+	if c == nil {
+		return value, true
+	}
+
+	document, err := decodeJSON(value)
+	if err != nil {
+		return nil, false
+	}
+
+	for _, clause := range c.clauses {
+		if !clause.matches(document) {
+			return nil, false
+		}
+	}
+
+	if len(c.fields) == 0 {
+		return value, true
+	}
+
+	projection := make(map[string]interface{})
+	for _, path := range c.fields {
+		fieldValue, ok := lookupJSONPath(document, path)
+		if !ok {
+			continue
+		}
+		parent := projection
+		for _, segment := range path[:len(path)-1] {
+			child, ok := parent[segment.Name].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				parent[segment.Name] = child
+			}
+			parent = child
+		}
+		parent[path[len(path)-1].Name] = fieldValue
+	}
+
+	trimmed, err := json.Marshal(projection)
+	if err != nil {
+		return nil, false
+	}
+	return trimmed, true
+}
+
+func (clause filterClause) matches(document interface{}) bool {
+	actual, ok := lookupJSONPath(document, clause.Path)
+	if !ok {
+		return false
+	}
+
+	comparison, comparable := compareJSONValues(actual, clause.Value)
+	if clause.Op != "==" && clause.Op != "!=" {
+		// only numbers and strings are ordered
+		switch actual.(type) {
+		case json.Number, string:
+		default:
+			return false
+		}
+	}
+	switch clause.Op {
+	case "==":
+		return comparable && comparison == 0
+	case "!=":
+		return !comparable || comparison != 0
+	case "<":
+		return comparable && comparison < 0
+	case "<=":
+		return comparable && comparison <= 0
+	case ">":
+		return comparable && comparison > 0
+	case ">=":
+		return comparable && comparison >= 0
+	}
+	return false
+}
+
+// compareJSONValues orders numbers numerically and strings lexicographically;
+// booleans and nulls can only be equal. Values of different types are not comparable.
+func compareJSONValues(a, b interface{}) (int, bool) {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return 0, false
+		}
+		af, errA := av.Float64()
+		bf, errB := bv.Float64()
+		if errA != nil || errB != nil {
+			return 0, false
+		}
+		if af < bf {
+			return -1, true
+		} else if af > bf {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av, bv), true
+	case bool:
+		bv, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		if av != bv {
+			return 1, true
+		}
+		return 0, true
+	case nil:
+		if b != nil {
+			return 0, false
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func parseFilterClause(clauseString string) (filterClause, error) {
+	clause := filterClause{}
+	clauseString = strings.TrimSpace(clauseString)
+
+	path, rest, err := parseJSONPath(clauseString)
+	if err != nil {
+		return clause, err
+	}
+	clause.Path = path
+
+	rest = strings.TrimSpace(rest)
+	for _, op := range filterOperators {
+		if strings.HasPrefix(rest, op) {
+			clause.Op = op
+			rest = strings.TrimSpace(rest[len(op):])
+			break
+		}
+	}
+	if clause.Op == "" {
+		return clause, fmt.Errorf("missing comparison operator in filter: %s", clauseString)
+	}
+
+	// single quoted strings are common in JSONPath filters
+	if len(rest) >= 2 && rest[0] == '\'' && rest[len(rest)-1] == '\'' {
+		clause.Value = rest[1 : len(rest)-1]
+		return clause, nil
+	}
+	clause.Value, err = decodeJSON([]byte(rest))
+	if err != nil {
+		return clause, fmt.Errorf("invalid literal '%s' in filter: %s", rest, err.Error())
+	}
+	if _, ok := clause.Value.(map[string]interface{}); ok {
+		return clause, fmt.Errorf("objects cannot be compared in filter: %s", clauseString)
+	}
+	if _, ok := clause.Value.([]interface{}); ok {
+		return clause, fmt.Errorf("arrays cannot be compared in filter: %s", clauseString)
+	}
+
+	return clause, nil
+}
+
+// parseJSONPath reads a $.a.b[0]['c'] path from the beginning of s and returns the rest of s
+func parseJSONPath(s string) ([]pathSegment, string, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, s, fmt.Errorf("JSONPath must start with $: %s", s)
+	}
+
+	path := make([]pathSegment, 0)
+	i := 1
+	for i < len(s) {
+		switch s[i] {
+		case '.':
+			end := i + 1
+			for end < len(s) && !strings.ContainsRune(".[ =!<>\t", rune(s[end])) {
+				end++
+			}
+			if end == i+1 {
+				return nil, s, fmt.Errorf("empty member name in JSONPath: %s", s)
+			}
+			path = append(path, pathSegment{Name: s[i+1 : end]})
+			i = end
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, s, fmt.Errorf("unterminated [ in JSONPath: %s", s)
+			}
+			inner := strings.TrimSpace(s[i+1 : i+end])
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				path = append(path, pathSegment{Name: inner[1 : len(inner)-1]})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, s, fmt.Errorf("invalid index [%s] in JSONPath: %s", inner, s)
+				}
+				path = append(path, pathSegment{Index: index})
+			}
+			i += end + 1
+		default:
+			return path, s[i:], nil
+		}
+	}
+
+	return path, "", nil
+}
+
+func lookupJSONPath(document interface{}, path []pathSegment) (interface{}, bool) {
+	current := document
+	for _, segment := range path {
+		if segment.Name != "" {
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			current, ok = object[segment.Name]
+			if !ok {
+				return nil, false
+			}
+		} else {
+			array, ok := current.([]interface{})
+			if !ok || segment.Index >= len(array) {
+				return nil, false
+			}
+			current = array[segment.Index]
+		}
+	}
+	return current, true
+}
+
+// splitOutsideQuotes splits s around sep, ignoring separators inside quoted strings
+func splitOutsideQuotes(s, sep string) []string {
+	parts := make([]string, 0)
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0 && s[i] == '\\':
+			i++
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		case quote == 0 && (s[i] == '"' || s[i] == '\''):
+			quote = s[i]
+		case quote == 0 && strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[start:i])
+			i += len(sep) - 1
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// decodeJSON keeps numbers as json.Number so projected values are written back unchanged
+func decodeJSON(value []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return document, nil
+}
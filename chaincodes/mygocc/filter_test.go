@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *ChaincodeTS) putMarbles() {
+	marbles := []string{
+		`{"name":"marble1","color":"blue","size":35,"owner":{"name":"tom","active":true}}`,
+		`{"name":"marble2","color":"red","size":50,"owner":{"name":"tom","active":false}}`,
+		`{"name":"marble3","color":"blue","size":70,"owner":{"name":"jerry","active":true}}`,
+		`not a json document`,
+	}
+	for i, marble := range marbles {
+		result := suite.stub.MockInvoke("1", [][]byte{
+			[]byte("put"),
+			[]byte(fmt.Sprintf("marble%d", i+1)),
+			[]byte(marble)})
+		assert.EqualValues(suite.T(), shim.OK, result.Status, "Put failed")
+	}
+}
+
+func (suite *ChaincodeTS) TestScanWithFilter() {
+	suite.putMarbles()
+
+	scanResult := suite.scanResult("scanPrefix", "marble", `{"filter":"$.color == 'blue' && $.size > 40"}`)
+	assert.Equal(suite.T(), []string{"marble3"}, resultKeys(scanResult))
+
+	scanResult = suite.scanResult("scanPrefix", "marble", `{"filter":"$.owner.name == \"tom\""}`)
+	assert.Equal(suite.T(), []string{"marble1", "marble2"}, resultKeys(scanResult))
+
+	scanResult = suite.scanResult("scanPrefix", "marble", `{"filter":"$['owner'].active != true"}`)
+	assert.Equal(suite.T(), []string{"marble2"}, resultKeys(scanResult))
+
+	// the limit counts matching records only
+	scanResult = suite.scanResult("scan", "marble1", "marble9", `{"filter":"$.size >= 50","limit":1}`)
+	assert.Equal(suite.T(), []string{"marble2"}, resultKeys(scanResult))
+	assert.True(suite.T(), scanResult.HasMore)
+
+	result := suite.stub.MockInvoke("1", [][]byte{
+		[]byte("scanPrefix"),
+		[]byte("marble"),
+		[]byte(`{"filter":"$.size ~ 3"}`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "invalid filter should be rejected")
+}
+
+func (suite *ChaincodeTS) TestScanWithProjection() {
+	suite.putMarbles()
+
+	scanResult := suite.scanResult("scanPrefix", "marble", `{"filter":"$.color == 'blue'","fields":["name","$.owner.name"]}`)
+	assert.Equal(suite.T(), []KV{
+		{Key: "marble1", Value: `{"name":"marble1","owner":{"name":"tom"}}`},
+		{Key: "marble3", Value: `{"name":"marble3","owner":{"name":"jerry"}}`},
+	}, scanResult.Results)
+}
+
+func (suite *ChaincodeTS) TestScanByPartialCompositeKeyWithFilter() {
+	suite.putMarbles()
+	suite.putIndexEntry("color~key", []string{"blue", "marble1"})
+	suite.putIndexEntry("color~key", []string{"blue", "marble3"})
+
+	valuesListJson, _ := json.Marshal([]string{"blue"})
+	result := suite.stub.MockInvoke("1", [][]byte{
+		[]byte("scanByPartialCompositeKey"),
+		[]byte("color~key"),
+		valuesListJson,
+		[]byte(`{"filter":"$.size < 50","fields":["size"]}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "scanByPartialCompositeKey failed")
+	assert.EqualValues(suite.T(), "{\"Results\":[{\"Key\":\"marble1\",\"Value\":\"{\\\"size\\\":35}\"}],\"Dangling\":[]}\n", string(result.Payload))
+}
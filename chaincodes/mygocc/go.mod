@@ -1,24 +1,20 @@
 module mygocc
 
-go 1.12
+go 1.13
 
 require (
-	github.com/Knetic/govaluate v3.0.0+incompatible // indirect
-	github.com/Shopify/sarama v1.28.0 // indirect
-	github.com/fsouza/go-dockerclient v1.7.2 // indirect
-	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/grpc-ecosystem/go-grpc-middleware v1.3.0 // indirect
-	github.com/hashicorp/go-version v1.3.0 // indirect
-	github.com/hyperledger/fabric v1.4.9
-	github.com/hyperledger/fabric-amcl v0.0.0-20210319225857-000ace5745f9 // indirect
-	github.com/miekg/pkcs11 v1.0.3 // indirect
-	github.com/onsi/ginkgo v1.16.1 // indirect
-	github.com/onsi/gomega v1.11.0 // indirect
-	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7 // indirect
-	github.com/spf13/viper v1.7.1 // indirect
+	ccserver v0.0.0
+	github.com/golang/protobuf v1.3.2
+	github.com/hyperledger/fabric-chaincode-go v0.0.0-20200424173110-d7076418f212
+	github.com/hyperledger/fabric-contract-api-go v1.1.0
+	github.com/hyperledger/fabric-protos-go v0.0.0-20200424173316-dd554ba3746e
 	github.com/stretchr/testify v1.7.0
-	github.com/sykesm/zap-logfmt v0.0.4 // indirect
-	go.uber.org/zap v1.16.0 // indirect
-	golang.org/x/net v0.7.0 // indirect
-	google.golang.org/grpc v1.37.0 // indirect
+	mangostub v0.0.0
+	schema v0.0.0
+)
+
+replace (
+	ccserver => ../ccserver
+	mangostub => ../mangostub
+	schema => ../schema
 )
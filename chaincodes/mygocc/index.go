@@ -0,0 +1,626 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	// object type of the composite keys holding the IndexConfig of every index
+	indexConfigObjectType = "indexConfig"
+	// object type of the references from the state keys to the index entries pointing at them,
+	// indexRef~objectType~stateKey~attributes, so that the entries of a state key are found
+	// without scanning the index
+	indexRefObjectType = "indexRef"
+)
+
+// IndexConfig describes how the composite keys of an object type reference state keys.
+// KeyPosition is the position of the state key among the composite key attributes,
+// counting from 0; negative positions count from the end. Indexes without a config
+// use the last attribute.
+type IndexConfig struct {
+	KeyPosition int
+}
+
+var defaultIndexConfig = IndexConfig{KeyPosition: -1}
+
+// CompositeScanOptions is the optional JSON argument of scanByPartialCompositeKey
+type CompositeScanOptions struct {
+	RecordFilter
+	WithAttributes bool // return the composite key attributes along with each value
+}
+
+type CompositeKV struct {
+	Key        string
+	Value      string
+	Attributes []string `json:"Attributes,omitempty" metadata:"Attributes,optional"`
+}
+
+type CompositeScanResult struct {
+	Results  []CompositeKV
+	Dangling []CompositeKey // index entries whose state key does not exist
+}
+
+// setIndexConfig sets the config of an index. The key position is checked against the attributes
+// of the entries as they are read, composite keys do not declare their attributes. The entries
+// written before the change keep their state key references under the previous config, reindex
+// moves them to the new key position; until it is done, the moved, copied and deleted state keys
+// may leave their entries behind, which repairIndex removes.
+func (c *Chaincode) setIndexConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting objectType and the index config JSON")
+	}
+	objectType := args[0]
+
+	if err := checkAdmin(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	config := IndexConfig{}
+	if err := json.Unmarshal([]byte(args[1]), &config); err != nil {
+		return shim.Error("Error unmarshalling the index config. " + err.Error())
+	}
+	configKey, err := stub.CreateCompositeKey(indexConfigObjectType, []string{objectType})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("Putting index config for objectType='%s'\n", objectType)
+	if err := stub.PutState(configKey, configJSON); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+func (c *Chaincode) getIndexConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting objectType")
+	}
+
+	config, err := loadIndexConfig(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(configJSON)
+}
+
+func loadIndexConfig(stub shim.ChaincodeStubInterface, objectType string) (IndexConfig, error) {
+	configKey, err := stub.CreateCompositeKey(indexConfigObjectType, []string{objectType})
+	if err != nil {
+		return defaultIndexConfig, err
+	}
+	configJSON, err := stub.GetState(configKey)
+	if err != nil {
+		return defaultIndexConfig, err
+	}
+	if configJSON == nil {
+		return defaultIndexConfig, nil
+	}
+
+	config := IndexConfig{}
+	if err := json.Unmarshal(configJSON, &config); err != nil {
+		return defaultIndexConfig, fmt.Errorf("Error unmarshalling the index config of %s. %s", objectType, err.Error())
+	}
+	return config, nil
+}
+
+// keyPosition resolves KeyPosition against the attributes of a composite key
+func (config IndexConfig) keyPosition(attributes []string) (int, error) {
+	position := config.KeyPosition
+	if position < 0 {
+		position += len(attributes)
+	}
+	if position < 0 || position >= len(attributes) {
+		return 0, fmt.Errorf("state key position %d out of range for attributes %v", config.KeyPosition, attributes)
+	}
+	return position, nil
+}
+
+// scanByPartialCompositeKey returns the state keys and values referenced by the matching
+// composite keys. Without options the response is the plain list of KV; with options it
+// is a CompositeScanResult which also lists the dangling index entries.
+func (c *Chaincode) scanByPartialCompositeKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting objectType, a list of attributes and an optional options JSON")
+	}
+	objectType := args[0]
+	valuesListJson := args[1]
+
+	values := make([]string, 0)
+	err := json.Unmarshal([]byte(valuesListJson), &values)
+	if err != nil {
+		fmt.Println("Unable to unmarshal the list of keys")
+		return shim.Error("Unable to unmarshal the list of keys")
+	}
+
+	opts := CompositeScanOptions{}
+	if len(args) > 2 && args[2] != "" {
+		if err := json.Unmarshal([]byte(args[2]), &opts); err != nil {
+			return shim.Error("Error unmarshalling the options. " + err.Error())
+		}
+	}
+	filter, err := opts.compile()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	config, err := loadIndexConfig(stub, objectType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("start GetStateByPartialCompositeKey ", objectType, values)
+	entries := make([]indexEntry, 0)
+	err = eachIndexEntry(stub, objectType, values, config, func(entry indexEntry) (bool, error) {
+		entries = append(entries, entry)
+		return true, nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// every distinct state key is read once, however many entries point at it
+	states := make(map[string][]byte)
+	for _, entry := range entries {
+		if _, ok := states[entry.StateKey]; ok {
+			continue
+		}
+		value, err := stub.GetState(entry.StateKey)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Failed to get state for %s: %s", entry.StateKey, err.Error()))
+		}
+		states[entry.StateKey] = value
+	}
+
+	result := CompositeScanResult{Results: make([]CompositeKV, 0), Dangling: make([]CompositeKey, 0)}
+	for _, entry := range entries {
+		value := states[entry.StateKey]
+		if value == nil {
+			fmt.Printf("Dangling index entry %s %v\n", objectType, entry.Attributes)
+			result.Dangling = append(result.Dangling, CompositeKey{ObjectType: objectType, Attributes: entry.Attributes})
+			continue
+		}
+
+		value, ok := filter.apply(value)
+		if !ok {
+			continue
+		}
+
+		kv := CompositeKV{Key: entry.StateKey, Value: string(value)}
+		if opts.WithAttributes {
+			kv.Attributes = entry.Attributes
+		}
+		result.Results = append(result.Results, kv)
+	}
+
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	if len(args) > 2 {
+		err = encoder.Encode(result)
+	} else {
+		arr := make([]KV, 0)
+		for _, kv := range result.Results {
+			arr = append(arr, KV{Key: kv.Key, Value: kv.Value})
+		}
+		err = encoder.Encode(arr)
+	}
+	if err != nil {
+		fmt.Println("Error encoding the data")
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(buffer.Bytes())
+}
+
+// an index entry with its resolved state key, kept with its value so it can be recreated as is
+type indexEntry struct {
+	ObjectType  string
+	Attributes  []string
+	KeyPosition int // position of the state key in Attributes
+	StateKey    string
+	Value       []byte
+}
+
+// eachIndexEntry iterates over the composite keys matching the partial key, resolving their
+// state keys, until fn returns false. Only the entries up to that point are read from the ledger.
+// Entries that cannot be split or have no attribute at the configured position are reported as errors.
+func eachIndexEntry(stub shim.ChaincodeStubInterface, objectType string, attributes []string, config IndexConfig, fn func(entry indexEntry) (bool, error)) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		fmt.Println("Error with GetStateByPartialCompositeKey")
+		return err
+	}
+	defer resultsIterator.Close()
+
+	return iterateIndexEntries(stub, resultsIterator, objectType, config, fn)
+}
+
+// iterateIndexEntries resolves the index entries of an iterator over objectType until fn returns false
+func iterateIndexEntries(stub shim.ChaincodeStubInterface, resultsIterator shim.StateQueryIteratorInterface, objectType string, config IndexConfig, fn func(entry indexEntry) (bool, error)) error {
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return fmt.Errorf("Error splitting composite key %q: %s", responseRange.Key, err.Error())
+		}
+		position, err := config.keyPosition(compositeKeyParts)
+		if err != nil {
+			return fmt.Errorf("Invalid index entry %q: %s", responseRange.Key, err.Error())
+		}
+		more, err := fn(indexEntry{
+			ObjectType:  objectType,
+			Attributes:  compositeKeyParts,
+			KeyPosition: position,
+			StateKey:    compositeKeyParts[position],
+			Value:       responseRange.Value,
+		})
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// putIndexEntry writes an index entry along with its reference from the state key at position
+func putIndexEntry(stub shim.ChaincodeStubInterface, objectType string, attributes []string, position int, value []byte) error {
+	indexKey, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return err
+	}
+	refKey, err := indexRefKey(stub, objectType, attributes, position)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Putting composite key='%s'\n", indexKey)
+	if err := stub.PutState(indexKey, value); err != nil {
+		return err
+	}
+	return stub.PutState(refKey, []byte{0x00})
+}
+
+// delIndexEntry deletes an index entry along with its reference from the state key at position
+func delIndexEntry(stub shim.ChaincodeStubInterface, objectType string, attributes []string, position int) error {
+	indexKey, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return err
+	}
+	refKey, err := indexRefKey(stub, objectType, attributes, position)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleting composite key='%s'\n", indexKey)
+	if err := stub.DelState(indexKey); err != nil {
+		return err
+	}
+	return stub.DelState(refKey)
+}
+
+func indexRefKey(stub shim.ChaincodeStubInterface, objectType string, attributes []string, position int) (string, error) {
+	attributesJson, err := json.Marshal(attributes)
+	if err != nil {
+		return "", err
+	}
+	return stub.CreateCompositeKey(indexRefObjectType, []string{objectType, attributes[position], string(attributesJson)})
+}
+
+// stateKeyIndexEntries returns the entries of objectType pointing at stateKey, found with a single
+// lookup of their references. References left behind by a deleted entry are ignored.
+func stateKeyIndexEntries(stub shim.ChaincodeStubInterface, objectType string, stateKey string, config IndexConfig) ([]indexEntry, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexRefObjectType, []string{objectType, stateKey})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	entries := make([]indexEntry, 0)
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, refParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil || len(refParts) != 3 {
+			return nil, fmt.Errorf("Invalid index reference %q", responseRange.Key)
+		}
+		attributes := make([]string, 0)
+		if err := json.Unmarshal([]byte(refParts[2]), &attributes); err != nil {
+			return nil, fmt.Errorf("Invalid index reference %q: %s", responseRange.Key, err.Error())
+		}
+		position, err := config.keyPosition(attributes)
+		if err != nil || attributes[position] != stateKey {
+			// written under a previous config, reindex deletes it
+			continue
+		}
+		indexKey, err := stub.CreateCompositeKey(objectType, attributes)
+		if err != nil {
+			return nil, err
+		}
+		value, err := stub.GetState(indexKey)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		entries = append(entries, indexEntry{
+			ObjectType:  objectType,
+			Attributes:  attributes,
+			KeyPosition: position,
+			StateKey:    stateKey,
+			Value:       value,
+		})
+	}
+
+	return entries, nil
+}
+
+type ReindexResult struct {
+	Indexed   int    // index entries whose reference was written by this call
+	ResumeKey string // pass as resumeKey to the next reindex call; empty when the index is exhausted
+}
+
+// reindex writes the references of at most limit entries of objectType, starting at resumeKey,
+// for the index entries written before the references existed or before the index config changed,
+// and deletes their references from the other attributes. Writes rule out the paginated queries,
+// so the scan starts over at the beginning of the index and passes over the entries before
+// resumeKey without reading them further.
+func (c *Chaincode) reindex(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting objectType, limit and resumeKey")
+	}
+	objectType := args[0]
+	resumeKey := args[2]
+	limit, err := strconv.Atoi(args[1])
+	if err != nil || limit <= 0 {
+		return shim.Error("limit must be a positive integer")
+	}
+	config, err := loadIndexConfig(stub, objectType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	result := ReindexResult{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if responseRange.Key < resumeKey {
+			continue
+		}
+		if result.Indexed == limit {
+			result.ResumeKey = responseRange.Key
+			break
+		}
+		_, attributes, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Error splitting composite key %q: %s", responseRange.Key, err.Error()))
+		}
+		position, err := config.keyPosition(attributes)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Invalid index entry %q: %s", responseRange.Key, err.Error()))
+		}
+		for i := range attributes {
+			if i != position && attributes[i] == attributes[position] {
+				continue
+			}
+			refKey, err := indexRefKey(stub, objectType, attributes, i)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if i == position {
+				err = stub.PutState(refKey, []byte{0x00})
+			} else {
+				err = stub.DelState(refKey)
+			}
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		result.Indexed++
+	}
+
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	err = encoder.Encode(result)
+	if err != nil {
+		fmt.Println("Error encoding the data")
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(buffer.Bytes())
+}
+
+type DanglingIndexResult struct {
+	Dangling  []CompositeKey
+	ResumeKey string // pass as resumeKey to the next call; empty when the index is exhausted
+}
+
+type RepairIndexResult struct {
+	Deleted []CompositeKey
+}
+
+// findDanglingIndexEntries checks at most limit composite keys of objectType, starting at the
+// optional resumeKey, and returns the ones whose state key does not exist. It pages through the
+// index, hence it can only be evaluated, not submitted.
+func (c *Chaincode) findDanglingIndexEntries(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	objectType, limit, resumeKey, err := parseDanglingArgs(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	config, err := loadIndexConfig(stub, objectType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	resultsIterator, _, err := stub.GetStateByPartialCompositeKeyWithPagination(objectType, []string{}, int32(limit+1), resumeKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	dangling, next, err := danglingIndexEntries(stub, resultsIterator, objectType, config, limit, resumeKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	result := DanglingIndexResult{Dangling: make([]CompositeKey, 0), ResumeKey: next}
+	for _, entry := range dangling {
+		result.Dangling = append(result.Dangling, CompositeKey{ObjectType: objectType, Attributes: entry.Attributes})
+	}
+
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	err = encoder.Encode(result)
+	if err != nil {
+		fmt.Println("Error encoding the data")
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(buffer.Bytes())
+}
+
+// repairIndex deletes the index entries of objectType given as the JSON list of the Dangling
+// entries returned by findDanglingIndexEntries. Writes rule out the paginated queries, so callers
+// page through the index with findDanglingIndexEntries and submit each page here, which keeps
+// every call bounded by the page. Entries already deleted, or whose state key has been written
+// since, are left alone.
+func (c *Chaincode) repairIndex(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting objectType and the dangling entries")
+	}
+	objectType := args[0]
+	var entries []CompositeKey
+	if err := json.Unmarshal([]byte(args[1]), &entries); err != nil {
+		return shim.Error("Error parsing the dangling entries: " + err.Error())
+	}
+
+	config, err := loadIndexConfig(stub, objectType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	result := RepairIndexResult{Deleted: make([]CompositeKey, 0)}
+	for _, entry := range entries {
+		if entry.ObjectType != objectType {
+			return shim.Error(fmt.Sprintf("The entry %v is not an entry of %s", entry.Attributes, objectType))
+		}
+		indexKey, err := stub.CreateCompositeKey(objectType, entry.Attributes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		indexValue, err := stub.GetState(indexKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if indexValue == nil {
+			continue
+		}
+		position, err := config.keyPosition(entry.Attributes)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Invalid index entry %q: %s", indexKey, err.Error()))
+		}
+		value, err := stub.GetState(entry.Attributes[position])
+		if err != nil {
+			return shim.Error(fmt.Sprintf("Failed to get state for %s: %s", entry.Attributes[position], err.Error()))
+		}
+		if value != nil {
+			continue
+		}
+		if err := delIndexEntry(stub, objectType, entry.Attributes, position); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Deleted = append(result.Deleted, CompositeKey{ObjectType: objectType, Attributes: entry.Attributes})
+	}
+
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	err = encoder.Encode(result)
+	if err != nil {
+		fmt.Println("Error encoding the data")
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(buffer.Bytes())
+}
+
+func parseDanglingArgs(args []string) (string, int, string, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return "", 0, "", fmt.Errorf("Incorrect number of arguments. Expecting objectType, limit and an optional resumeKey")
+	}
+	limit, err := strconv.Atoi(args[1])
+	if err != nil || limit <= 0 {
+		return "", 0, "", fmt.Errorf("limit must be a positive integer")
+	}
+	resumeKey := ""
+	if len(args) == 3 {
+		resumeKey = args[2]
+	}
+	return args[0], limit, resumeKey, nil
+}
+
+// danglingIndexEntries checks up to limit entries of the iterator from resumeKey on and returns the
+// ones whose state key does not exist, along with the key of the entry following them, empty when
+// there is none
+func danglingIndexEntries(stub shim.ChaincodeStubInterface, resultsIterator shim.StateQueryIteratorInterface, objectType string, config IndexConfig, limit int, resumeKey string) ([]indexEntry, string, error) {
+	exists := make(map[string]bool)
+	dangling := make([]indexEntry, 0)
+	checked := 0
+	next := ""
+	err := iterateIndexEntries(stub, resultsIterator, objectType, config, func(entry indexEntry) (bool, error) {
+		indexKey, err := stub.CreateCompositeKey(objectType, entry.Attributes)
+		if err != nil || indexKey < resumeKey {
+			return err == nil, err
+		}
+		// the entry past limit is where the next call resumes
+		if checked == limit {
+			next = indexKey
+			return false, nil
+		}
+		checked++
+
+		found, ok := exists[entry.StateKey]
+		if !ok {
+			value, err := stub.GetState(entry.StateKey)
+			if err != nil {
+				return false, fmt.Errorf("Failed to get state for %s: %s", entry.StateKey, err.Error())
+			}
+			found = value != nil
+			exists[entry.StateKey] = found
+		}
+		if !found {
+			dangling = append(dangling, entry)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return dangling, next, nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/stretchr/testify/assert"
+
+	"mangostub"
+)
+
+func (suite *ChaincodeTS) compositeScanResult(objectType string, attributes []string, options string) CompositeScanResult {
+	valuesListJson, _ := json.Marshal(attributes)
+	result := suite.stub.MockInvoke("1", [][]byte{
+		[]byte("scanByPartialCompositeKey"),
+		[]byte(objectType),
+		valuesListJson,
+		[]byte(options)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "scanByPartialCompositeKey failed")
+
+	var scanResult CompositeScanResult
+	err := json.Unmarshal(result.Payload, &scanResult)
+	assert.Nil(suite.T(), err, "Unable to unmarshal the scan result")
+	return scanResult
+}
+
+func (suite *ChaincodeTS) TestIndexConfig() {
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("getIndexConfig"), []byte("key~color")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "getIndexConfig failed")
+	assert.EqualValues(suite.T(), `{"KeyPosition":-1}`, string(result.Payload))
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("setIndexConfig"), []byte("key~color"), []byte(`{"keyPosition":0}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "setIndexConfig failed")
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("getIndexConfig"), []byte("key~color")})
+	assert.EqualValues(suite.T(), `{"KeyPosition":0}`, string(result.Payload))
+}
+
+func (suite *ChaincodeTS) TestSetIndexConfigChecks() {
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("getAdminMSPs")})
+	assert.EqualValues(suite.T(), `["Org1MSP"]`, string(result.Payload), "Init should record the admin MSPs it is given")
+
+	suite.stub.Creator = creator("Org2MSP")
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("setIndexConfig"), []byte("color~key"), []byte(`{"keyPosition":0}`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "only admins should set the index config")
+	assert.Contains(suite.T(), result.Message, "Org2MSP is not an admin MSP")
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("setAdminMSPs"), []byte(`["Org2MSP"]`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "only admins should set the admins")
+	result = suite.stub.MockInit("1", [][]byte{[]byte("init"), []byte(`["Org2MSP"]`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "Init should not replace the recorded admins")
+
+	suite.stub.Creator = creator("Org1MSP")
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("setAdminMSPs"), []byte(`["Org1MSP","Org2MSP"]`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "setAdminMSPs failed: "+result.Message)
+	suite.stub.Creator = creator("Org2MSP")
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("setIndexConfig"), []byte("color~key"), []byte(`{"keyPosition":-1}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "setIndexConfig failed: "+result.Message)
+}
+
+func (suite *ChaincodeTS) TestInitWithoutAdminMSPs() {
+	stub := mangostub.NewMockStub("mockStub", new(Chaincode)).MockStub
+	stub.Creator = creator("Org1MSP")
+	result := stub.MockInit("1", [][]byte{[]byte("init")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "Init failed: "+result.Message)
+
+	result = stub.MockInvoke("1", [][]byte{[]byte("getAdminMSPs")})
+	assert.EqualValues(suite.T(), `[]`, string(result.Payload), "the Init caller should not become admin")
+	result = stub.MockInvoke("1", [][]byte{[]byte("setIndexConfig"), []byte("color~key"), []byte(`{"keyPosition":0}`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "nobody is admin")
+
+	// an upgraded ledger gets its admins from a later Init
+	for _, adminMSPs := range []string{`[]`, `[""]`, `Org1MSP`} {
+		result = stub.MockInit("1", [][]byte{[]byte("init"), []byte(adminMSPs)})
+		assert.EqualValues(suite.T(), shim.ERROR, result.Status, "admin MSPs %s should be refused", adminMSPs)
+	}
+	result = stub.MockInit("1", [][]byte{[]byte("init"), []byte(`["Org2MSP"]`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "Init failed: "+result.Message)
+	result = stub.MockInvoke("1", [][]byte{[]byte("setIndexConfig"), []byte("color~key"), []byte(`{"keyPosition":0}`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "the Init caller should not become admin")
+	stub.Creator = creator("Org2MSP")
+	result = stub.MockInvoke("1", [][]byte{[]byte("setIndexConfig"), []byte("color~key"), []byte(`{"keyPosition":0}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "setIndexConfig failed: "+result.Message)
+	result = stub.MockInit("1", [][]byte{[]byte("init"), []byte(`["Org2MSP"]`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "Init should accept the recorded admins again")
+}
+
+func (suite *ChaincodeTS) TestSetIndexConfigOfPopulatedIndex() {
+	suite.stub.MockInvoke("1", [][]byte{[]byte("putAll"), []byte("k1"), []byte("v1"), []byte("k2"), []byte("v2")})
+	// entries written with the default config, which takes the color for the state key
+	suite.putIndexEntry("key~color", []string{"k1", "blue"})
+	suite.putIndexEntry("key~color", []string{"k2", "blue"})
+
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("setIndexConfig"), []byte("key~color"), []byte(`{"keyPosition":0}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "the config of an index with entries should change: "+result.Message)
+	scanResult := suite.compositeScanResult("key~color", []string{}, "{}")
+	assert.Equal(suite.T(), []CompositeKV{{Key: "k1", Value: "v1"}, {Key: "k2", Value: "v2"}}, scanResult.Results)
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("reindex"), []byte("key~color"), []byte("10"), []byte("")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "reindex failed: "+result.Message)
+	staleRefKey, _ := indexRefKey(suite.stub, "key~color", []string{"k1", "blue"}, 1)
+	suite.checkValueNotExist(staleRefKey)
+
+	// the reindexed entries follow their state key
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("rename"), []byte("k1"), []byte("k3"), []byte(`{"indexes":["key~color"]}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "rename failed: "+result.Message)
+	scanResult = suite.compositeScanResult("key~color", []string{}, `{"withAttributes":true}`)
+	assert.Equal(suite.T(), []CompositeKV{
+		{Key: "k2", Value: "v2", Attributes: []string{"k2", "blue"}},
+		{Key: "k3", Value: "v1", Attributes: []string{"k3", "blue"}},
+	}, scanResult.Results)
+
+	// the position is checked against the attributes of the entries when they are read
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("setIndexConfig"), []byte("key~color"), []byte(`{"keyPosition":2}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "setIndexConfig failed: "+result.Message)
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("scanByPartialCompositeKey"), []byte("key~color"), []byte(`[]`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "the position is out of range of the entries")
+	assert.Contains(suite.T(), result.Message, "out of range")
+}
+
+func (suite *ChaincodeTS) TestScanByPartialCompositeKeyWithKeyPosition() {
+	suite.stub.MockInvoke("1", [][]byte{[]byte("putAll"), []byte("k1"), []byte("v1"), []byte("k2"), []byte("v2")})
+	suite.stub.MockInvoke("1", [][]byte{[]byte("setIndexConfig"), []byte("type~key~version"), []byte(`{"keyPosition":1}`)})
+	suite.putIndexEntry("type~key~version", []string{"doc", "k1", "1"})
+	suite.putIndexEntry("type~key~version", []string{"doc", "k2", "3"})
+
+	scanResult := suite.compositeScanResult("type~key~version", []string{"doc"}, `{"withAttributes":true}`)
+	assert.Equal(suite.T(), []CompositeKV{
+		{Key: "k1", Value: "v1", Attributes: []string{"doc", "k1", "1"}},
+		{Key: "k2", Value: "v2", Attributes: []string{"doc", "k2", "3"}},
+	}, scanResult.Results)
+
+	// the position also drives the index entries carried along by rename
+	result := suite.stub.MockInvoke("1", [][]byte{
+		[]byte("rename"),
+		[]byte("k2"),
+		[]byte("k3"),
+		[]byte(`{"indexes":["type~key~version"]}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "rename failed")
+	renamedIndexKey, _ := suite.stub.CreateCompositeKey("type~key~version", []string{"doc", "k3", "3"})
+	suite.checkValueExists(renamedIndexKey, string([]byte{0x00}))
+
+	// an entry too short for the configured position is reported, not skipped
+	suite.putIndexEntry("type~key~version", []string{"doc"})
+	result = suite.stub.MockInvoke("1", [][]byte{
+		[]byte("scanByPartialCompositeKey"),
+		[]byte("type~key~version"),
+		[]byte(`["doc"]`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "invalid index entry should fail the scan")
+}
+
+func (suite *ChaincodeTS) TestScanByPartialCompositeKeyDangling() {
+	suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("k1"), []byte("v1")})
+	suite.putIndexEntry("color~key", []string{"blue", "k1"})
+	suite.putIndexEntry("color~key", []string{"blue", "gone"})
+	suite.putIndexEntry("size~key", []string{"big", "k1"})
+
+	scanResult := suite.compositeScanResult("color~key", []string{"blue"}, "{}")
+	assert.Equal(suite.T(), []CompositeKV{{Key: "k1", Value: "v1"}}, scanResult.Results)
+	assert.Equal(suite.T(), []CompositeKey{{ObjectType: "color~key", Attributes: []string{"blue", "gone"}}}, scanResult.Dangling)
+
+	// without options the dangling entries are left out of the plain list
+	result := suite.stub.MockInvoke("1", [][]byte{
+		[]byte("scanByPartialCompositeKey"),
+		[]byte("color~key"),
+		[]byte(`["blue"]`)})
+	assert.EqualValues(suite.T(), "[{\"Key\":\"k1\",\"Value\":\"v1\"}]\n", string(result.Payload))
+}
+
+func (suite *ChaincodeTS) TestBulkDeleteCompositeKey() {
+	indexKey1 := suite.putIndexEntry("color~key", []string{"blue", "k1"})
+	indexKey2 := suite.putIndexEntry("color~key", []string{"red", "k2"})
+
+	compositeKeyListJson, _ := json.Marshal([]CompositeKey{
+		{ObjectType: "color~key", Attributes: []string{"blue", "k1"}},
+		{ObjectType: "color~key", Attributes: []string{"red", "k2"}},
+	})
+	result := suite.stub.MockInvoke("1", [][]byte{
+		[]byte("bulkDeleteCompositeKey"),
+		compositeKeyListJson})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "bulkDeleteCompositeKey failed")
+
+	suite.checkValueNotExist(indexKey1)
+	suite.checkValueNotExist(indexKey2)
+}
+
+func (suite *ChaincodeTS) TestFindDanglingIndexEntries() {
+	suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("k1"), []byte("v1")})
+	suite.putIndexEntry("color~key", []string{"blue", "k1"})
+	suite.putIndexEntry("color~key", []string{"blue", "gone1"})
+	suite.putIndexEntry("color~key", []string{"red", "gone2"})
+
+	findDangling := func(limit string, resumeKey string) DanglingIndexResult {
+		result := suite.stub.MockInvoke("1", [][]byte{[]byte("findDanglingIndexEntries"), []byte("color~key"), []byte(limit), []byte(resumeKey)})
+		assert.EqualValues(suite.T(), shim.OK, result.Status, "findDanglingIndexEntries failed: "+result.Message)
+		var danglingResult DanglingIndexResult
+		json.Unmarshal(result.Payload, &danglingResult)
+		return danglingResult
+	}
+
+	danglingResult := findDangling("10", "")
+	assert.Equal(suite.T(), []CompositeKey{
+		{ObjectType: "color~key", Attributes: []string{"blue", "gone1"}},
+		{ObjectType: "color~key", Attributes: []string{"red", "gone2"}},
+	}, danglingResult.Dangling)
+	assert.Empty(suite.T(), danglingResult.ResumeKey)
+
+	// the limit bounds the entries checked, not the dangling ones found
+	danglingResult = findDangling("1", "")
+	assert.Equal(suite.T(), []CompositeKey{{ObjectType: "color~key", Attributes: []string{"blue", "gone1"}}}, danglingResult.Dangling)
+	validIndexKey, _ := suite.stub.CreateCompositeKey("color~key", []string{"blue", "k1"})
+	assert.Equal(suite.T(), validIndexKey, danglingResult.ResumeKey)
+	danglingResult = findDangling("1", danglingResult.ResumeKey)
+	assert.Empty(suite.T(), danglingResult.Dangling)
+	danglingResult = findDangling("1", danglingResult.ResumeKey)
+	assert.Equal(suite.T(), []CompositeKey{{ObjectType: "color~key", Attributes: []string{"red", "gone2"}}}, danglingResult.Dangling)
+	assert.Empty(suite.T(), danglingResult.ResumeKey)
+}
+
+func (suite *ChaincodeTS) TestRepairIndex() {
+	suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("k1"), []byte("v1")})
+	validIndexKey := suite.putIndexEntry("color~key", []string{"blue", "k1"})
+	for _, key := range []string{"gone1", "gone2", "gone3"} {
+		suite.putIndexEntry("color~key", []string{"blue", key})
+	}
+
+	// the index is paged through by the evaluated query, each page is repaired by a submission
+	resumeKey := ""
+	batches := 0
+	deleted := 0
+	for {
+		result := suite.stub.MockInvoke("1", [][]byte{[]byte("findDanglingIndexEntries"), []byte("color~key"), []byte("2"), []byte(resumeKey)})
+		assert.EqualValues(suite.T(), shim.OK, result.Status, "findDanglingIndexEntries failed")
+		var danglingResult DanglingIndexResult
+		json.Unmarshal(result.Payload, &danglingResult)
+		danglingJson, _ := json.Marshal(danglingResult.Dangling)
+
+		result = suite.stub.MockInvoke("1", [][]byte{[]byte("repairIndex"), []byte("color~key"), danglingJson})
+		assert.EqualValues(suite.T(), shim.OK, result.Status, "repairIndex failed: "+result.Message)
+		batches++
+
+		var repairResult RepairIndexResult
+		json.Unmarshal(result.Payload, &repairResult)
+		assert.Equal(suite.T(), danglingResult.Dangling, repairResult.Deleted)
+		deleted += len(repairResult.Deleted)
+		resumeKey = danglingResult.ResumeKey
+		if resumeKey == "" {
+			break
+		}
+	}
+	assert.Equal(suite.T(), 2, batches, "unexpected number of batches")
+	assert.Equal(suite.T(), 3, deleted)
+
+	// the entries repaired, or whose state key was written since, are left alone
+	suite.putIndexEntry("color~key", []string{"blue", "back"})
+	suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("back"), []byte("v")})
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("repairIndex"), []byte("color~key"),
+		[]byte(`[{"ObjectType":"color~key","Attributes":["blue","gone1"]},{"ObjectType":"color~key","Attributes":["blue","back"]}]`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "repairIndex failed: "+result.Message)
+	assert.EqualValues(suite.T(), "{\"Deleted\":[]}\n", string(result.Payload))
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("repairIndex"), []byte("color~key"), []byte(`[{"ObjectType":"size~key","Attributes":["1","k1"]}]`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "entries of another index should be refused")
+
+	suite.checkValueExists(validIndexKey, string([]byte{0x00}))
+	scanResult := suite.compositeScanResult("color~key", []string{}, "{}")
+	assert.Empty(suite.T(), scanResult.Dangling)
+	assert.Len(suite.T(), scanResult.Results, 2)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// KeyMoveOptions is the optional JSON argument of rename, copy and clonePrefix
+type KeyMoveOptions struct {
+	Overwrite bool     // replace the destination key if it already exists
+	Indexes   []string `metadata:",optional"` // object types of the composite keys pointing at the moved state keys
+}
+
+type ClonePrefixResult struct {
+	Copied    int
+	ResumeKey string // pass as resumeKey to the next clonePrefix call; empty when the prefix is exhausted
+}
+
+func (c *Chaincode) rename(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting oldKey, newKey and an optional options JSON")
+	}
+	oldKey := args[0]
+	newKey := args[1]
+
+	opts, err := parseKeyMoveOptions(args[2:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("Renaming key='%s' to key='%s'\n", oldKey, newKey)
+	if err := moveKey(stub, oldKey, newKey, opts, true); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+func (c *Chaincode) copy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting src, dst and an optional options JSON")
+	}
+	src := args[0]
+	dst := args[1]
+
+	opts, err := parseKeyMoveOptions(args[2:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("Copying key='%s' to key='%s'\n", src, dst)
+	if err := moveKey(stub, src, dst, opts, false); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// clonePrefix copies at most limit keys starting with srcPrefix to the same keys under dstPrefix.
+// Scanning starts at resumeKey, or at the beginning of the prefix when it is empty,
+// and the returned ResumeKey tells the caller where the next batch starts.
+func (c *Chaincode) clonePrefix(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 4 {
+		return shim.Error("Incorrect number of arguments. Expecting srcPrefix, dstPrefix, limit, resumeKey and an optional options JSON")
+	}
+	srcPrefix := args[0]
+	dstPrefix := args[1]
+	resumeKey := args[3]
+
+	limit, err := strconv.Atoi(args[2])
+	if err != nil || limit <= 0 {
+		return shim.Error("limit must be a positive integer")
+	}
+	if strings.HasPrefix(srcPrefix, dstPrefix) || strings.HasPrefix(dstPrefix, srcPrefix) {
+		return shim.Error("srcPrefix and dstPrefix must not overlap")
+	}
+	if resumeKey != "" && !strings.HasPrefix(resumeKey, srcPrefix) {
+		return shim.Error("resumeKey must start with srcPrefix")
+	}
+
+	opts, err := parseKeyMoveOptions(args[4:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	startKey := resumeKey
+	if startKey == "" {
+		startKey = srcPrefix
+	}
+
+	fmt.Printf("clonePrefix srcPrefix='%s' dstPrefix='%s' startKey='%s' limit=%d\n", srcPrefix, dstPrefix, startKey, limit)
+	resultsIterator, err := stub.GetStateByRange(startKey, prefixEndKey(srcPrefix))
+	if err != nil {
+		fmt.Println("Error with GetStateByRange")
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	result := ClonePrefixResult{}
+	srcKeys := make([]string, 0)
+	values := make(map[string][]byte)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(srcKeys) == limit {
+			result.ResumeKey = queryResponse.Key
+			break
+		}
+		srcKeys = append(srcKeys, queryResponse.Key)
+		values[queryResponse.Key] = queryResponse.Value
+	}
+
+	entries, err := findIndexEntries(stub, opts.Indexes, srcKeys)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	for _, src := range srcKeys {
+		dst := dstPrefix + strings.TrimPrefix(src, srcPrefix)
+		if err := writeKey(stub, dst, values[src], entries[src], opts); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Copied++
+	}
+
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	err = encoder.Encode(result)
+	if err != nil {
+		fmt.Println("Error encoding the data")
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(buffer.Bytes())
+}
+
+func parseKeyMoveOptions(args []string) (KeyMoveOptions, error) {
+	opts := KeyMoveOptions{}
+	if len(args) == 0 || args[0] == "" {
+		return opts, nil
+	}
+	if err := json.Unmarshal([]byte(args[0]), &opts); err != nil {
+		return opts, fmt.Errorf("Error unmarshalling the options. %s", err.Error())
+	}
+	return opts, nil
+}
+
+// moveKey copies src to dst along with its index entries, deleting src afterwards when asked to
+func moveKey(stub shim.ChaincodeStubInterface, src, dst string, opts KeyMoveOptions, deleteSrc bool) error {
+	if src == dst {
+		return fmt.Errorf("source and destination keys are the same: %s", src)
+	}
+
+	value, err := stub.GetState(src)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return fmt.Errorf("key does not exist: %s", src)
+	}
+
+	entries, err := findIndexEntries(stub, opts.Indexes, []string{src})
+	if err != nil {
+		return err
+	}
+
+	if err := writeKey(stub, dst, value, entries[src], opts); err != nil {
+		return err
+	}
+
+	if !deleteSrc {
+		return nil
+	}
+
+	if err := stub.DelState(src); err != nil {
+		return err
+	}
+	for _, entry := range entries[src] {
+		if err := delIndexEntry(stub, entry.ObjectType, entry.Attributes, entry.KeyPosition); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeKey puts value under dst and recreates the given index entries pointing at dst. When
+// overwriting, the entries of the replaced dst in the indexes of opts are deleted first.
+func writeKey(stub shim.ChaincodeStubInterface, dst string, value []byte, entries []indexEntry, opts KeyMoveOptions) error {
+	existing, err := stub.GetState(dst)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if !opts.Overwrite {
+			return fmt.Errorf("key already exists: %s", dst)
+		}
+		replaced, err := findIndexEntries(stub, opts.Indexes, []string{dst})
+		if err != nil {
+			return err
+		}
+		for _, entry := range replaced[dst] {
+			if err := delIndexEntry(stub, entry.ObjectType, entry.Attributes, entry.KeyPosition); err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Printf("Putting key='%s'\n", dst)
+	if err := stub.PutState(dst, value); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		attributes := make([]string, len(entry.Attributes))
+		copy(attributes, entry.Attributes)
+		attributes[entry.KeyPosition] = dst
+
+		if err := putIndexEntry(stub, entry.ObjectType, attributes, entry.KeyPosition, entry.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findIndexEntries looks up the composite keys of the given object types referencing one of keys,
+// at the state key position of their IndexConfig, with one lookup per index and key. Entries
+// written before the index references existed are found once reindex has run over their index.
+func findIndexEntries(stub shim.ChaincodeStubInterface, objectTypes []string, keys []string) (map[string][]indexEntry, error) {
+	entries := make(map[string][]indexEntry)
+	if len(objectTypes) == 0 || len(keys) == 0 {
+		return entries, nil
+	}
+
+	for _, objectType := range objectTypes {
+		config, err := loadIndexConfig(stub, objectType)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			keyEntries, err := stateKeyIndexEntries(stub, objectType, key, config)
+			if err != nil {
+				return nil, err
+			}
+			entries[key] = append(entries[key], keyEntries...)
+		}
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *ChaincodeTS) putIndexEntry(objectType string, attributes []string) string {
+	compositeKeyListJson, _ := json.Marshal([]CompositeKey{{ObjectType: objectType, Attributes: attributes}})
+	result := suite.stub.MockInvoke("1", [][]byte{
+		[]byte("bulkCreateCompositeKey"),
+		compositeKeyListJson})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "bulkCreateCompositeKey failed")
+
+	indexKey, _ := suite.stub.CreateCompositeKey(objectType, attributes)
+	return indexKey
+}
+
+func (suite *ChaincodeTS) TestRename() {
+	suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("user:1"), []byte("alice")})
+	oldIndexKey := suite.putIndexEntry("name~key", []string{"alice", "user:1"})
+
+	options := `{"indexes":["name~key"]}`
+	result := suite.stub.MockInvoke("1", [][]byte{
+		[]byte("rename"),
+		[]byte("user:1"),
+		[]byte("customer:1"),
+		[]byte(options)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "rename failed")
+
+	suite.checkValueNotExist("user:1")
+	suite.checkValueExists("customer:1", "alice")
+
+	newIndexKey, _ := suite.stub.CreateCompositeKey("name~key", []string{"alice", "customer:1"})
+	suite.checkValueNotExist(oldIndexKey)
+	suite.checkValueExists(newIndexKey, string([]byte{0x00}))
+}
+
+func (suite *ChaincodeTS) TestRenameRefusesOverwrite() {
+	suite.stub.MockInvoke("1", [][]byte{[]byte("putAll"), []byte("a"), []byte("1"), []byte("b"), []byte("2")})
+
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("rename"), []byte("a"), []byte("b")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "rename should refuse to overwrite")
+	suite.checkValuesExist([]string{"a", "1", "b", "2"})
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("rename"), []byte("a"), []byte("b"), []byte(`{"overwrite":true}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "rename with overwrite failed")
+	suite.checkValueNotExist("a")
+	suite.checkValueExists("b", "1")
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("rename"), []byte("missing"), []byte("c")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "rename of a missing key should fail")
+}
+
+func (suite *ChaincodeTS) TestCopy() {
+	suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("src"), []byte("value")})
+	srcIndexKey := suite.putIndexEntry("type~key", []string{"doc", "src"})
+
+	result := suite.stub.MockInvoke("1", [][]byte{
+		[]byte("copy"),
+		[]byte("src"),
+		[]byte("dst"),
+		[]byte(`{"indexes":["type~key"]}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "copy failed")
+
+	suite.checkValuesExist([]string{"src", "value", "dst", "value"})
+	dstIndexKey, _ := suite.stub.CreateCompositeKey("type~key", []string{"doc", "dst"})
+	suite.checkValueExists(srcIndexKey, string([]byte{0x00}))
+	suite.checkValueExists(dstIndexKey, string([]byte{0x00}))
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("copy"), []byte("src"), []byte("dst")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "copy should refuse to overwrite")
+}
+
+func (suite *ChaincodeTS) TestCopyOverwriteReplacesIndexEntries() {
+	suite.stub.MockInvoke("1", [][]byte{[]byte("putAll"), []byte("src"), []byte("new"), []byte("dst"), []byte("old")})
+	suite.putIndexEntry("type~key", []string{"doc", "src"})
+	oldDstIndexKey := suite.putIndexEntry("type~key", []string{"draft", "dst"})
+
+	result := suite.stub.MockInvoke("1", [][]byte{
+		[]byte("copy"),
+		[]byte("src"),
+		[]byte("dst"),
+		[]byte(`{"indexes":["type~key"],"overwrite":true}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "copy with overwrite failed")
+
+	suite.checkValueExists("dst", "new")
+	dstIndexKey, _ := suite.stub.CreateCompositeKey("type~key", []string{"doc", "dst"})
+	suite.checkValueExists(dstIndexKey, string([]byte{0x00}))
+	suite.checkValueNotExist(oldDstIndexKey)
+
+	scanResult := suite.compositeScanResult("type~key", []string{"draft"}, "{}")
+	assert.Empty(suite.T(), scanResult.Results, "the replaced destination should leave its index")
+	assert.Empty(suite.T(), scanResult.Dangling)
+}
+
+func (suite *ChaincodeTS) TestReindex() {
+	suite.stub.MockInvoke("1", [][]byte{[]byte("putAll"), []byte("k1"), []byte("v1"), []byte("k2"), []byte("v2")})
+	// entries written before the index references existed
+	suite.stub.MockTransactionStart("legacy")
+	for _, key := range []string{"k1", "k2"} {
+		indexKey, _ := suite.stub.CreateCompositeKey("color~key", []string{"blue", key})
+		suite.stub.PutState(indexKey, []byte{0x00})
+	}
+	suite.stub.MockTransactionEnd("legacy")
+
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("rename"), []byte("k1"), []byte("k3"), []byte(`{"indexes":["color~key"]}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "rename failed")
+	legacyIndexKey, _ := suite.stub.CreateCompositeKey("color~key", []string{"blue", "k1"})
+	suite.checkValueExists(legacyIndexKey, string([]byte{0x00}))
+
+	resumeKey := ""
+	indexed := 0
+	for batches := 1; ; batches++ {
+		result = suite.stub.MockInvoke("1", [][]byte{[]byte("reindex"), []byte("color~key"), []byte("1"), []byte(resumeKey)})
+		assert.EqualValues(suite.T(), shim.OK, result.Status, "reindex failed")
+		var reindexResult ReindexResult
+		json.Unmarshal(result.Payload, &reindexResult)
+		indexed += reindexResult.Indexed
+		resumeKey = reindexResult.ResumeKey
+		if resumeKey == "" {
+			assert.Equal(suite.T(), 2, batches, "reindex should be bounded by limit")
+			break
+		}
+	}
+	assert.Equal(suite.T(), 2, indexed)
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("rename"), []byte("k2"), []byte("k4"), []byte(`{"indexes":["color~key"]}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "rename failed")
+	oldIndexKey, _ := suite.stub.CreateCompositeKey("color~key", []string{"blue", "k2"})
+	newIndexKey, _ := suite.stub.CreateCompositeKey("color~key", []string{"blue", "k4"})
+	suite.checkValueNotExist(oldIndexKey)
+	suite.checkValueExists(newIndexKey, string([]byte{0x00}))
+}
+
+func (suite *ChaincodeTS) TestClonePrefix() {
+	for i := 1; i <= 5; i++ {
+		suite.stub.MockInvoke("1", [][]byte{
+			[]byte("put"),
+			[]byte(fmt.Sprintf("user:%d", i)),
+			[]byte(fmt.Sprintf("value%d", i))})
+	}
+	suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("users"), []byte("not in prefix")})
+
+	resumeKey := ""
+	batches := 0
+	for {
+		result := suite.stub.MockInvoke("1", [][]byte{
+			[]byte("clonePrefix"),
+			[]byte("user:"),
+			[]byte("customer:"),
+			[]byte("2"),
+			[]byte(resumeKey)})
+		assert.EqualValues(suite.T(), shim.OK, result.Status, "clonePrefix failed")
+		batches++
+
+		var cloneResult ClonePrefixResult
+		json.Unmarshal(result.Payload, &cloneResult)
+		resumeKey = cloneResult.ResumeKey
+		if resumeKey == "" {
+			assert.Equal(suite.T(), 1, cloneResult.Copied, "last batch should hold the remaining key")
+			break
+		}
+		assert.Equal(suite.T(), 2, cloneResult.Copied, "batch should be bounded by limit")
+	}
+	assert.Equal(suite.T(), 3, batches, "unexpected number of batches")
+
+	for i := 1; i <= 5; i++ {
+		suite.checkValueExists(fmt.Sprintf("user:%d", i), fmt.Sprintf("value%d", i))
+		suite.checkValueExists(fmt.Sprintf("customer:%d", i), fmt.Sprintf("value%d", i))
+	}
+	suite.checkValueNotExist("customers")
+
+	result := suite.stub.MockInvoke("1", [][]byte{
+		[]byte("clonePrefix"),
+		[]byte("user:"),
+		[]byte("user:old:"),
+		[]byte("2"),
+		[]byte("")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "overlapping prefixes should be rejected")
+}
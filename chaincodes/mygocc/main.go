@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"os"
 	"time"
 
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+
+	"ccserver"
 )
 
 type Chaincode struct {
@@ -33,12 +36,28 @@ type CompositeKey struct {
 
 func (c *Chaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("Chaincode Init")
+	if err := checkSchemaVersion(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := initSchemaVersion(stub); err != nil {
+		return shim.Error(err.Error())
+	}
+	// init takes the JSON list of the admin MSP IDs, e.g. ["Org1MSP"]
+	_, args := stub.GetFunctionAndParameters()
+	adminMSPsJson := ""
+	if len(args) > 0 {
+		adminMSPsJson = args[0]
+	}
+	if err := initAdminMSPs(stub, adminMSPsJson); err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
 func (c *Chaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	fmt.Printf("Chaincode Invoke; function='%s'\n", function)
+	stub = guardReservedKeys(function, stub)
 
 	if function == "put" {
 		return c.put(stub, args)
@@ -48,10 +67,14 @@ func (c *Chaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return c.putAll(stub, args)
 	} else if function == "bulkCreateCompositeKey" {
 		return c.bulkCreateCompositeKey(stub, args)
+	} else if function == "bulkDeleteCompositeKey" {
+		return c.bulkDeleteCompositeKey(stub, args)
 	} else if function == "get" {
 		return c.get(stub, args)
 	} else if function == "scan" {
 		return c.scan(stub, args)
+	} else if function == "scanPrefix" {
+		return c.scanPrefix(stub, args)
 	} else if function == "scanByPartialCompositeKey" {
 		return c.scanByPartialCompositeKey(stub, args)
 	} else if function == "scanByPartialCompositeKeyForAttributes" { // return list of attributes instead of KV
@@ -64,6 +87,60 @@ func (c *Chaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return c.deleteAll(stub, args)
 	} else if function == "getHistoryForKey" {
 		return c.getHistoryForKey(stub, args)
+	} else if function == "rename" {
+		return c.rename(stub, args)
+	} else if function == "copy" {
+		return c.copy(stub, args)
+	} else if function == "clonePrefix" {
+		return c.clonePrefix(stub, args)
+	} else if function == "setIndexConfig" {
+		return c.setIndexConfig(stub, args)
+	} else if function == "getIndexConfig" {
+		return c.getIndexConfig(stub, args)
+	} else if function == "setAdminMSPs" {
+		return c.setAdminMSPs(stub, args)
+	} else if function == "getAdminMSPs" {
+		return c.getAdminMSPs(stub, args)
+	} else if function == "findDanglingIndexEntries" {
+		return c.findDanglingIndexEntries(stub, args)
+	} else if function == "repairIndex" {
+		return c.repairIndex(stub, args)
+	} else if function == "reindex" {
+		return c.reindex(stub, args)
+	} else if function == "putEncrypted" {
+		return c.putEncrypted(stub, args)
+	} else if function == "getDecrypted" {
+		return c.getDecrypted(stub, args)
+	} else if function == "notarize" {
+		return c.notarize(stub, args)
+	} else if function == "verifyNotarization" {
+		return c.verifyNotarization(stub, args)
+	} else if function == "getSchemaVersion" {
+		return c.getSchemaVersion(stub, args)
+	} else if function == "migrate" {
+		return c.migrate(stub, args)
+	} else if function == "putPrivate" {
+		return c.putPrivate(stub, args)
+	} else if function == "getPrivate" {
+		return c.getPrivate(stub, args)
+	} else if function == "scanPrivate" {
+		return c.scanPrivate(stub, args)
+	} else if function == "queryPrivate" {
+		return c.queryPrivate(stub, args)
+	} else if function == "deletePrivate" {
+		return c.deletePrivate(stub, args)
+	} else if function == "getPrivateHash" {
+		return c.getPrivateHash(stub, args)
+	} else if function == "verifyPrivate" {
+		return c.verifyPrivate(stub, args)
+	} else if function == "setKeyEndorsers" {
+		return c.setKeyEndorsers(stub, args)
+	} else if function == "addKeyEndorsers" {
+		return c.addKeyEndorsers(stub, args)
+	} else if function == "removeKeyEndorsers" {
+		return c.removeKeyEndorsers(stub, args)
+	} else if function == "getKeyEndorsementPolicy" {
+		return c.getKeyEndorsementPolicy(stub, args)
 	}
 
 	return shim.Error("Invalid invoke function name.")
@@ -145,9 +222,20 @@ func (c *Chaincode) bulkCreateCompositeKey(stub shim.ChaincodeStubInterface, arg
 			isError = true
 			continue
 		}
-		fmt.Printf("Putting composite key='%s'\n", indexKey)
-		// save the index entry on blockchain
-		err = stub.PutState(indexKey, []byte{0x00})
+		position, err := indexKeyPosition(stub, cKey)
+		if err != nil {
+			fmt.Println("Error loading the index config of objectType ", cKey.ObjectType)
+			isError = true
+			continue
+		}
+		if position < 0 {
+			// no state key at the position of the index config, the entry has no reference
+			fmt.Printf("Putting composite key='%s'\n", indexKey)
+			err = stub.PutState(indexKey, []byte{0x00})
+		} else {
+			// save the index entry on blockchain along with its reference from the state key
+			err = putIndexEntry(stub, cKey.ObjectType, cKey.Attributes, position, []byte{0x00})
+		}
 
 		if err != nil {
 			fmt.Printf("Error Putting composite key='%s'\n", indexKey)
@@ -161,104 +249,104 @@ func (c *Chaincode) bulkCreateCompositeKey(stub shim.ChaincodeStubInterface, arg
 	return shim.Success(nil)
 }
 
-func (c *Chaincode) get(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	key := args[0]
-
-	fmt.Printf("Getting key='%s'\n", key)
-
-	payload, err := stub.GetState(key)
+// indexKeyPosition returns the state key position of cKey in its index, -1 when it has none
+func indexKeyPosition(stub shim.ChaincodeStubInterface, cKey CompositeKey) (int, error) {
+	config, err := loadIndexConfig(stub, cKey.ObjectType)
 	if err != nil {
-		return shim.Error(err.Error())
+		return -1, err
 	}
-
-	return shim.Success(payload)
+	position, err := config.keyPosition(cKey.Attributes)
+	if err != nil {
+		return -1, nil
+	}
+	return position, nil
 }
 
-func (c *Chaincode) scan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	startKey := args[0]
-	endKey := args[1]
+func (c *Chaincode) bulkDeleteCompositeKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	compositeKeyListJsonString := args[0] // a json string of a list
 
-	fmt.Printf("scan starKey='%s' endKey='%s'\n", startKey, endKey)
-	resultsIterator, err := stub.GetStateByRange(startKey, endKey)
+	compositeKeyList := make([]CompositeKey, 0)
+
+	err := json.Unmarshal([]byte(compositeKeyListJsonString), &compositeKeyList)
 	if err != nil {
-		fmt.Println("Error with GetStateByRange")
+		fmt.Println("Error unmarshalling the compositekey list")
 		return shim.Error(err.Error())
 	}
-	defer resultsIterator.Close()
+	isError := false
+	for _, cKey := range compositeKeyList {
 
-	arr := make([]KV, 0)
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+		indexKey, err := stub.CreateCompositeKey(cKey.ObjectType,
+			cKey.Attributes)
 		if err != nil {
-			return shim.Error(err.Error())
+			fmt.Println("Error Creating composite for objectType ", cKey.ObjectType)
+			isError = true
+			continue
 		}
-		arr = append(arr, KV{
-			Key:   queryResponse.Key,
-			Value: string(queryResponse.Value),
-		})
+		position, err := indexKeyPosition(stub, cKey)
+		if err != nil {
+			fmt.Println("Error loading the index config of objectType ", cKey.ObjectType)
+			isError = true
+			continue
+		}
+		if position < 0 {
+			fmt.Printf("Deleting composite key='%s'\n", indexKey)
+			err = stub.DelState(indexKey)
+		} else {
+			err = delIndexEntry(stub, cKey.ObjectType, cKey.Attributes, position)
+		}
+
+		if err != nil {
+			fmt.Printf("Error Deleting composite key='%s'\n", indexKey)
+			isError = true
+			continue
+		}
+	}
+	if isError {
+		return shim.Error("There was one or more errors occurred when deleting composite keys")
 	}
+	return shim.Success(nil)
+}
 
-	buffer := new(bytes.Buffer)
-	encoder := json.NewEncoder(buffer)
-	err = encoder.Encode(arr)
+func (c *Chaincode) get(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	key := args[0]
+
+	fmt.Printf("Getting key='%s'\n", key)
+
+	payload, err := stub.GetState(key)
 	if err != nil {
-		fmt.Println("Error encoding the data")
 		return shim.Error(err.Error())
 	}
 
-	return shim.Success(buffer.Bytes())
-
+	return shim.Success(payload)
 }
 
-func (c *Chaincode) scanByPartialCompositeKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	objectType := args[0]
-	valuesListJson := args[1]
-
-	values := make([]string, 0)
-	err := json.Unmarshal([]byte(valuesListJson), &values)
-	if err != nil {
-		fmt.Println("Unable to unmarshal the list of keys")
-		return shim.Error("Unable to unmarshal the list of keys")
+// scan returns the keys in [startKey, endKey). Without options the response is the plain
+// list of KV, with options it is a ScanResult carrying the key to continue from.
+func (c *Chaincode) scan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting startKey, endKey and an optional options JSON")
 	}
-	fmt.Println("start GetStateByPartialCompositeKey ", objectType, values)
+	startKey := args[0]
+	endKey := args[1]
 
-	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, values)
+	opts, err := parseScanOptions(args[2:])
 	if err != nil {
-		fmt.Println("Error with GetStateByPartialCompositeKey")
 		return shim.Error(err.Error())
 	}
 
-	defer resultsIterator.Close()
-
-	arr := make([]KV, 0)
-	for resultsIterator.HasNext() {
-		responseRange, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
-		if err != nil {
-			fmt.Println("Error with SplitCompositeKey ", err.Error())
-			continue
-		}
-
-		if len(compositeKeyParts) == 0 {
-			fmt.Println("empty composite key parts")
-			continue
-		}
-		fmt.Println("compositeKeyParts= ", compositeKeyParts)
-		actualKey := compositeKeyParts[len(compositeKeyParts)-1] // assuming the last one is the state key
-		valueJsonBytes, err := stub.GetState(actualKey)
-
-		arr = append(arr, KV{
-			Key:   actualKey,
-			Value: string(valueJsonBytes),
-		})
+	fmt.Printf("scan starKey='%s' endKey='%s'\n", startKey, endKey)
+	result, err := scanRange(stub, startKey, endKey, opts)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
 	buffer := new(bytes.Buffer)
 	encoder := json.NewEncoder(buffer)
-	err = encoder.Encode(arr)
+	if len(args) > 2 {
+		err = encoder.Encode(result)
+	} else {
+		err = encoder.Encode(result.Results)
+	}
 	if err != nil {
 		fmt.Println("Error encoding the data")
 		return shim.Error(err.Error())
@@ -427,7 +515,17 @@ func (c *Chaincode) deleteAll(stub shim.ChaincodeStubInterface, args []string) p
 }
 
 func main() {
-	err := shim.Start(new(Chaincode))
+	var cc shim.Chaincode = new(Chaincode)
+	// serve the same functions through the contract API, with typed parameters and metadata
+	if os.Getenv("MYGOCC_CONTRACT_API") == "true" {
+		contractChaincode, err := newContractChaincode()
+		if err != nil {
+			panic(err.Error())
+		}
+		cc = contractChaincode
+	}
+
+	err := ccserver.Run(cc)
 	if err != nil {
 		fmt.Printf("Error starting chaincode: %s", err)
 	}
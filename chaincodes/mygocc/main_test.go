@@ -5,14 +5,19 @@ import (
 	"fmt"
 	"testing"
 
-	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/msp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+
+	"mangostub"
 )
 
 type ChaincodeTS struct {
 	suite.Suite
-	stub *shim.MockStub
+	stub *shimtest.MockStub
 }
 
 func (suite *ChaincodeTS) checkValueExists(key string, value string) {
@@ -39,14 +44,21 @@ func (suite *ChaincodeTS) checkValuesNotExist(kvList []string) {
 	}
 }
 
+// creator returns a serialized identity of the given org, as the peer passes it to the chaincode
+func creator(mspID string) []byte {
+	identity, _ := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: []byte("cert")})
+	return identity
+}
+
 // setup will be run for all tests in the suite
 func (suite *ChaincodeTS) SetupTest() {
-	suite.stub = shim.NewMockStub("mockStub", new(Chaincode))
+	suite.stub = mangostub.NewMockStub("mockStub", new(Chaincode)).MockStub
 	assert.NotNil(suite.T(), suite.stub, "MockStub creation failed")
+	suite.stub.Creator = creator("Org1MSP")
 	// call the constructor
 	result := suite.stub.MockInit("1", [][]byte{
 		[]byte("init"),
-		[]byte{}})
+		[]byte(`["Org1MSP"]`)})
 	assert.EqualValues(suite.T(), result.Status, shim.OK, "Init is not successful")
 }
 
@@ -226,11 +238,10 @@ func (suite *ChaincodeTS) TestScan() {
 func (suite *ChaincodeTS) TestQuery() {
 	// put some key
 	// and value will be json structure
-	valueJSON := `{"docID":"%d", "title":"content-%d}`
+	valueJSON := `{"docID":"%d", "title":"content-%d"}`
 	for i := 1; i < 10; i++ {
 		key := fmt.Sprintf("key%02d", i)
 		value := fmt.Sprintf(valueJSON, i, i)
-		fmt.Printf("key='%s' value='%s'\n", key, value)
 		result := suite.stub.MockInvoke("1", [][]byte{
 			[]byte("put"),
 			[]byte(key),
@@ -240,13 +251,23 @@ func (suite *ChaincodeTS) TestQuery() {
 
 	// call query
 	queryString := "{\"selector\":{\"docID\":\"3\"}}"
-	suite.stub.MockInvoke("1", [][]byte{
+	result := suite.stub.MockInvoke("1", [][]byte{
 		[]byte("query"),
 		[]byte(queryString)})
-	// NOTE: those mocks for unit tests do no implement these queries;
-	// Need to test with actual fabric setup
-	//
-	//assert.EqualValues(suite.T(), shim.OK, result.Status, "Query failed")
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "Query failed: "+result.Message)
+
+	var kvList []KV
+	json.Unmarshal(result.Payload, &kvList)
+	assert.Equal(suite.T(), []KV{{Key: "key03", Value: fmt.Sprintf(valueJSON, 3, 3)}}, kvList)
+
+	queryString = `{"selector":{"$or":[{"docID":{"$in":["2","4"]}},{"title":{"$regex":"-8$"}}]},"sort":[{"docID":"desc"}],"fields":["docID"],"limit":2}`
+	result = suite.stub.MockInvoke("1", [][]byte{
+		[]byte("query"),
+		[]byte(queryString)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "Query failed: "+result.Message)
+
+	json.Unmarshal(result.Payload, &kvList)
+	assert.Equal(suite.T(), []KV{{Key: "key08", Value: `{"docID":"8"}`}, {Key: "key04", Value: `{"docID":"4"}`}}, kvList)
 }
 
 func (suite *ChaincodeTS) TestDelete() {
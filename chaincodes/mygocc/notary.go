@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// notarizationObjectType is the reserved object type the notarizations are stored under, keyed by
+// the notarized key; the other functions cannot write it
+const notarizationObjectType = "notarization"
+
+// reservedObjectTypes are the object types whose keys only the listed functions may write, the
+// writes of the other functions are refused by reservedKeysStub
+var reservedObjectTypes = map[string][]string{
+	notarizationObjectType: {"notarize", "migrate"},
+}
+
+// Notarization is the record stored by notarize: the SHA-256 of the payload, signed by the holder
+// of the public key. The payload itself is not stored.
+type Notarization struct {
+	PayloadHash string // hex SHA-256 of the payload
+	Algorithm   string // ECDSA or Ed25519
+	Signature   string // base64 signature over the payload hash
+	PublicKey   string // PEM public key of the signer
+	Signer      string // hex SHA-256 of the DER encoded public key
+	TxId        string
+	Timestamp   time.Time // timestamp of the notarizing transaction
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// notarize verifies the signature of a payload and stores its notarization record for key, under
+// the reserved notarization object type rather than key itself, so the record is independent of
+// the value of key. The signature is over the SHA-256 hash of the payload, ASN.1 DER encoded for
+// ECDSA, and is passed in base64. The public key is a PEM PUBLIC KEY or a PEM CERTIFICATE.
+// A notarization is never overwritten nor deleted.
+func (c *Chaincode) notarize(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting key, payload, signature and pubKeyPEM")
+	}
+	key := args[0]
+	payload := args[1]
+	pubKeyPEM := args[3]
+
+	signature, err := base64.StdEncoding.DecodeString(args[2])
+	if err != nil {
+		return shim.Error("Error decoding the signature. " + err.Error())
+	}
+
+	publicKey, err := parsePublicKeyPEM(pubKeyPEM)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	hash := sha256.Sum256([]byte(payload))
+	algorithm, err := verifySignature(publicKey, hash[:], signature)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	notarizationKey, err := stub.CreateCompositeKey(notarizationObjectType, []string{key})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := stub.GetState(notarizationKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("key is already notarized: " + key)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fingerprint := sha256.Sum256(der)
+
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	notarization := Notarization{
+		PayloadHash: hex.EncodeToString(hash[:]),
+		Algorithm:   algorithm,
+		Signature:   args[2],
+		PublicKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		Signer:      hex.EncodeToString(fingerprint[:]),
+		TxId:        stub.GetTxID(),
+		Timestamp:   time.Unix(timestamp.GetSeconds(), int64(timestamp.GetNanos())).UTC(),
+	}
+	notarizationJson, err := json.Marshal(notarization)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("Notarizing key='%s' signer='%s'\n", key, notarization.Signer)
+	err = stub.PutState(notarizationKey, notarizationJson)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(notarizationJson)
+}
+
+// verifyNotarization returns the notarization record of key if payload is the notarized document
+func (c *Chaincode) verifyNotarization(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting key and payload")
+	}
+	key := args[0]
+	payload := args[1]
+
+	notarizationKey, err := stub.CreateCompositeKey(notarizationObjectType, []string{key})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	notarizationJson, err := stub.GetState(notarizationKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if notarizationJson == nil {
+		return shim.Error("No notarization found for key: " + key)
+	}
+
+	notarization := Notarization{}
+	err = json.Unmarshal(notarizationJson, &notarization)
+	if err != nil {
+		return shim.Error("Malformed notarization of key: " + key)
+	}
+
+	hash := sha256.Sum256([]byte(payload))
+	if hex.EncodeToString(hash[:]) != notarization.PayloadHash {
+		return shim.Error("The payload does not match the notarization of key: " + key)
+	}
+
+	return shim.Success(notarizationJson)
+}
+
+// notarizationsMigration moves the notarizations written under their key before the reserved
+// object type existed. A value is taken for a notarization only if it decodes as one with no
+// other field, with a verifying signature; the other values are left alone.
+func notarizationsMigration(stub shim.ChaincodeStubInterface, key string, value []byte) error {
+	decoder := json.NewDecoder(strings.NewReader(string(value)))
+	decoder.DisallowUnknownFields()
+	notarization := Notarization{}
+	if err := decoder.Decode(&notarization); err != nil || notarization.TxId == "" {
+		return nil
+	}
+	hash, err := hex.DecodeString(notarization.PayloadHash)
+	if err != nil {
+		return nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(notarization.Signature)
+	if err != nil {
+		return nil
+	}
+	publicKey, err := parsePublicKeyPEM(notarization.PublicKey)
+	if err != nil {
+		return nil
+	}
+	if _, err := verifySignature(publicKey, hash, signature); err != nil {
+		return nil
+	}
+
+	notarizationKey, err := stub.CreateCompositeKey(notarizationObjectType, []string{key})
+	if err != nil {
+		return err
+	}
+	existing, err := stub.GetState(notarizationKey)
+	if err != nil || existing != nil {
+		// notarized again since the upgrade, the record under the key is left as a plain value
+		return err
+	}
+	fmt.Printf("Moving the notarization of key='%s'\n", key)
+	if err := stub.PutState(notarizationKey, value); err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+
+// reservedKeysStub refuses the writes of function to the keys of the reservedObjectTypes it
+// does not own
+type reservedKeysStub struct {
+	shim.ChaincodeStubInterface
+	function string
+}
+
+// guardReservedKeys returns the stub to run function with, function being a shim Chaincode or a
+// Contract name
+func guardReservedKeys(function string, stub shim.ChaincodeStubInterface) shim.ChaincodeStubInterface {
+	name := function[strings.LastIndex(function, ":")+1:]
+	if name != "" {
+		name = strings.ToLower(name[:1]) + name[1:]
+	}
+	return &reservedKeysStub{stub, name}
+}
+
+func (stub *reservedKeysStub) checkKey(key string) error {
+	for objectType, owners := range reservedObjectTypes {
+		prefix, err := stub.CreateCompositeKey(objectType, []string{})
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for _, owner := range owners {
+			if owner == stub.function {
+				return nil
+			}
+		}
+		return fmt.Errorf("The keys of %s are reserved to %s", objectType, strings.Join(owners, " and "))
+	}
+	return nil
+}
+
+func (stub *reservedKeysStub) PutState(key string, value []byte) error {
+	if err := stub.checkKey(key); err != nil {
+		return err
+	}
+	return stub.ChaincodeStubInterface.PutState(key, value)
+}
+
+func (stub *reservedKeysStub) DelState(key string) error {
+	if err := stub.checkKey(key); err != nil {
+		return err
+	}
+	return stub.ChaincodeStubInterface.DelState(key)
+}
+
+func parsePublicKeyPEM(pubKeyPEM string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(pubKeyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("Error decoding the public key PEM")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing the public key. %s", err.Error())
+		}
+		return publicKey, nil
+	case "CERTIFICATE":
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing the certificate. %s", err.Error())
+		}
+		return certificate.PublicKey, nil
+	}
+	return nil, fmt.Errorf("Unsupported PEM block type %s, expecting PUBLIC KEY or CERTIFICATE", block.Type)
+}
+
+// verifySignature checks an ECDSA or Ed25519 signature over hash and returns the algorithm name
+func verifySignature(publicKey interface{}, hash []byte, signature []byte) (string, error) {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		sig := ecdsaSignature{}
+		rest, err := asn1.Unmarshal(signature, &sig)
+		if err != nil || len(rest) != 0 || sig.R == nil || sig.S == nil {
+			return "", fmt.Errorf("Malformed ECDSA signature, expecting ASN.1 DER")
+		}
+		if !ecdsa.Verify(key, hash, sig.R, sig.S) {
+			return "", fmt.Errorf("Invalid ECDSA signature")
+		}
+		return "ECDSA", nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, hash, signature) {
+			return "", fmt.Errorf("Invalid Ed25519 signature")
+		}
+		return "Ed25519", nil
+	}
+	return "", fmt.Errorf("Unsupported public key type %T, expecting ECDSA or Ed25519", publicKey)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func publicKeyPEM(publicKey interface{}) string {
+	der, _ := x509.MarshalPKIXPublicKey(publicKey)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func (suite *ChaincodeTS) notarize(key, payload string, signature []byte, pubKeyPEM string) pb.Response {
+	return suite.stub.MockInvoke("1", [][]byte{
+		[]byte("notarize"),
+		[]byte(key),
+		[]byte(payload),
+		[]byte(base64.StdEncoding.EncodeToString(signature)),
+		[]byte(pubKeyPEM)})
+}
+
+func (suite *ChaincodeTS) TestNotarizeECDSA() {
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	hash := sha256.Sum256([]byte("document"))
+	r, s, _ := ecdsa.Sign(rand.Reader, privateKey, hash[:])
+	signature, _ := asn1.Marshal(ecdsaSignature{r, s})
+
+	result := suite.notarize("doc1", "document", signature, publicKeyPEM(&privateKey.PublicKey))
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "notarize failed: "+result.Message)
+
+	var notarization Notarization
+	json.Unmarshal(result.Payload, &notarization)
+	assert.Equal(suite.T(), hex.EncodeToString(hash[:]), notarization.PayloadHash)
+	assert.Equal(suite.T(), "ECDSA", notarization.Algorithm)
+	assert.Len(suite.T(), notarization.Signer, 64)
+	assert.False(suite.T(), notarization.Timestamp.IsZero())
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("verifyNotarization"), []byte("doc1"), []byte("document")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "verifyNotarization failed: "+result.Message)
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("verifyNotarization"), []byte("doc1"), []byte("forged")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "another document should not match")
+
+	result = suite.notarize("doc1", "document", signature, publicKeyPEM(&privateKey.PublicKey))
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "a notarization should not be overwritten")
+}
+
+func (suite *ChaincodeTS) TestNotarizeEd25519() {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	hash := sha256.Sum256([]byte("document"))
+	signature := ed25519.Sign(privateKey, hash[:])
+
+	result := suite.notarize("doc1", "document", signature, publicKeyPEM(publicKey))
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "notarize failed: "+result.Message)
+
+	var notarization Notarization
+	json.Unmarshal(result.Payload, &notarization)
+	assert.Equal(suite.T(), "Ed25519", notarization.Algorithm)
+}
+
+func (suite *ChaincodeTS) TestNotarizeRejectsInvalidSignature() {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	hash := sha256.Sum256([]byte("document"))
+	signature := ed25519.Sign(privateKey, hash[:])
+
+	result := suite.notarize("doc1", "tampered document", signature, publicKeyPEM(publicKey))
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "the signature should not verify")
+	assert.Contains(suite.T(), result.Message, "Invalid Ed25519 signature")
+	suite.checkValueNotExist("doc1")
+
+	result = suite.notarize("doc1", "document", signature, "not a PEM")
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "the public key should not parse")
+}
+
+func (suite *ChaincodeTS) TestNotarizationCannotBeOverwritten() {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	hash := sha256.Sum256([]byte("document"))
+	result := suite.notarize("doc1", "document", ed25519.Sign(privateKey, hash[:]), publicKeyPEM(publicKey))
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "notarize failed: "+result.Message)
+
+	suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("doc2"), []byte("forged")})
+	notarizationKey, _ := suite.stub.CreateCompositeKey(notarizationObjectType, []string{"doc1"})
+	for _, args := range [][]string{
+		{"put", notarizationKey, "forged"},
+		{"putAll", notarizationKey, "forged"},
+		{"delete", notarizationKey},
+		{"rename", notarizationKey, "stolen"},
+		{"copy", "doc2", notarizationKey, `{"overwrite":true}`},
+		{"bulkCreateCompositeKey", `[{"ObjectType":"notarization","Attributes":["doc1"]}]`},
+		{"bulkDeleteCompositeKey", `[{"ObjectType":"notarization","Attributes":["doc1"]}]`},
+	} {
+		byteArgs := make([][]byte, 0, len(args))
+		for _, arg := range args {
+			byteArgs = append(byteArgs, []byte(arg))
+		}
+		result = suite.stub.MockInvoke("1", byteArgs)
+		assert.EqualValues(suite.T(), shim.ERROR, result.Status, "%s on a notarization should fail", args[0])
+	}
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte(notarizationKey), []byte("forged")})
+	assert.Contains(suite.T(), result.Message, "reserved")
+
+	// the value of the notarized key is independent of its notarization
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("doc1"), []byte("forged")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "put failed: "+result.Message)
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("delete"), []byte("doc1")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "delete failed: "+result.Message)
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("verifyNotarization"), []byte("doc1"), []byte("document")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "the notarization should be left as is: "+result.Message)
+}
+
+func (suite *ChaincodeTS) TestNotarizationsMigration() {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	hash := sha256.Sum256([]byte("document"))
+	result := suite.notarize("doc1", "document", ed25519.Sign(privateKey, hash[:]), publicKeyPEM(publicKey))
+	notarizationJson := result.Payload
+
+	// a ledger notarizing under the key itself
+	notarizationKey, _ := suite.stub.CreateCompositeKey(notarizationObjectType, []string{"doc1"})
+	suite.dropSchemaVersion()
+	suite.stub.MockTransactionStart("legacy")
+	suite.stub.DelState(notarizationKey)
+	suite.stub.PutState("doc1", notarizationJson)
+	suite.stub.PutState("doc2", []byte(`{"TxId":"t1","PayloadHash":"00","Note":"not a notarization"}`))
+	suite.stub.MockTransactionEnd("legacy")
+
+	for !suite.migrate("10").Done {
+	}
+	suite.checkValueExists(notarizationKey, string(notarizationJson))
+	suite.checkValueNotExist("doc1")
+	suite.checkValueExists("doc2", `{"TxId":"t1","PayloadHash":"00","Note":"not a notarization"}`)
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("verifyNotarization"), []byte("doc1"), []byte("document")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "verifyNotarization failed: "+result.Message)
+}
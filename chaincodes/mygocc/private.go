@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// The private data functions mirror put, get, scan, query and delete on a private data collection.
+// Values are passed in the transient map so that they are not recorded in the transaction.
+
+// putPrivate puts the value of the "value" transient map field in a collection
+func (c *Chaincode) putPrivate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting collection and key")
+	}
+	collection := args[0]
+	key := args[1]
+
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return shim.Error("Error getting the transient map. " + err.Error())
+	}
+	value, ok := transientMap[transientValueField]
+	if !ok {
+		return shim.Error(transientValueField + " must be passed in the transient map")
+	}
+
+	fmt.Printf("Putting private key='%s' collection='%s'\n", key, collection)
+	err = stub.PutPrivateData(collection, key, value)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+func (c *Chaincode) getPrivate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting collection and key")
+	}
+	collection := args[0]
+	key := args[1]
+
+	fmt.Printf("Getting private key='%s' collection='%s'\n", key, collection)
+	payload, err := stub.GetPrivateData(collection, key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
+
+// scanPrivate returns the keys of a collection in [startKey, endKey)
+func (c *Chaincode) scanPrivate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting collection, startKey and endKey")
+	}
+	collection := args[0]
+	startKey := args[1]
+	endKey := args[2]
+
+	fmt.Printf("scanPrivate collection='%s' startKey='%s' endKey='%s'\n", collection, startKey, endKey)
+	resultsIterator, err := stub.GetPrivateDataByRange(collection, startKey, endKey)
+	if err != nil {
+		fmt.Println("Error with GetPrivateDataByRange")
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	payload, err := encodeKVs(resultsIterator)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
+
+// queryPrivate runs a rich query on a collection, the state database must be CouchDB
+func (c *Chaincode) queryPrivate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting collection and queryString")
+	}
+	collection := args[0]
+	queryString := args[1]
+
+	fmt.Printf("queryPrivate collection='%s' queryString:\n%s\n", collection, queryString)
+	resultsIterator, err := stub.GetPrivateDataQueryResult(collection, queryString)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	payload, err := encodeKVs(resultsIterator)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
+
+func (c *Chaincode) deletePrivate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting collection and key")
+	}
+	collection := args[0]
+	key := args[1]
+
+	fmt.Printf("Deleting private key='%s' collection='%s'\n", key, collection)
+	err := stub.DelPrivateData(collection, key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// getPrivateHash returns the hex SHA-256 hash of a private value, which is readable by
+// the peers that are not members of the collection
+func (c *Chaincode) getPrivateHash(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting collection and key")
+	}
+	collection := args[0]
+	key := args[1]
+
+	hash, err := stub.GetPrivateDataHash(collection, key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if hash == nil {
+		return shim.Success(nil)
+	}
+
+	return shim.Success([]byte(hex.EncodeToString(hash)))
+}
+
+// verifyPrivate checks a claimed value against the hash of a private value, so that the peers
+// that are not members of the collection can verify it. The claimed value is passed as argument
+// or, when omitted, in the "value" transient map field.
+func (c *Chaincode) verifyPrivate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 2 || len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting collection, key and an optional value")
+	}
+	collection := args[0]
+	key := args[1]
+
+	var value []byte
+	if len(args) == 3 {
+		value = []byte(args[2])
+	} else {
+		transientMap, err := stub.GetTransient()
+		if err != nil {
+			return shim.Error("Error getting the transient map. " + err.Error())
+		}
+		var ok bool
+		if value, ok = transientMap[transientValueField]; !ok {
+			return shim.Error("The value must be passed as argument or in the transient map")
+		}
+	}
+
+	hash, err := stub.GetPrivateDataHash(collection, key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if hash == nil {
+		return shim.Error("No private value found for key: " + key)
+	}
+
+	claimedHash := sha256.Sum256(value)
+	if !bytes.Equal(claimedHash[:], hash) {
+		return shim.Error("The value does not match the private value of key: " + key)
+	}
+
+	return shim.Success(nil)
+}
+
+// encodeKVs encodes the results of an iterator as a JSON list of KV
+func encodeKVs(resultsIterator shim.StateQueryIteratorInterface) ([]byte, error) {
+	arr := make([]KV, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, KV{
+			Key:   queryResponse.Key,
+			Value: string(queryResponse.Value),
+		})
+	}
+
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	err := encoder.Encode(arr)
+	if err != nil {
+		fmt.Println("Error encoding the data")
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/stretchr/testify/assert"
+
+	"mangostub"
+)
+
+// The MockStub does not implement the private data deletion, hashes and range queries
+
+func (stub *transientStub) DelPrivateData(collection string, key string) error {
+	delete(stub.PvtState[collection], key)
+	return nil
+}
+
+func (stub *transientStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	value, ok := stub.PvtState[collection][key]
+	if !ok {
+		return nil, nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+func (stub *transientStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	keys := make([]string, 0)
+	for key := range stub.PvtState[collection] {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	results := make([]*queryresult.KV, 0)
+	for _, key := range keys {
+		results = append(results, &queryresult.KV{Namespace: collection, Key: key, Value: stub.PvtState[collection][key]})
+	}
+	return &kvIterator{results: results}, nil
+}
+
+type kvIterator struct {
+	results []*queryresult.KV
+}
+
+func (it *kvIterator) HasNext() bool {
+	return len(it.results) > 0
+}
+
+func (it *kvIterator) Next() (*queryresult.KV, error) {
+	if len(it.results) == 0 {
+		return nil, errors.New("no more results")
+	}
+	kv := it.results[0]
+	it.results = it.results[1:]
+	return kv, nil
+}
+
+func (it *kvIterator) Close() error {
+	return nil
+}
+
+func (suite *ChaincodeTS) putPrivate(cc *transientChaincode, collection, key, value string) {
+	cc.transient = map[string][]byte{transientValueField: []byte(value)}
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("putPrivate"), []byte(collection), []byte(key)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "putPrivate failed: "+result.Message)
+	cc.transient = nil
+}
+
+func (suite *ChaincodeTS) TestPutPrivate() {
+	cc := suite.useTransientStub()
+	suite.putPrivate(cc, "collectionA", "key1", "secret")
+
+	value, _ := suite.stub.GetPrivateData("collectionA", "key1")
+	assert.Equal(suite.T(), "secret", string(value))
+	suite.checkValueNotExist("key1")
+
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("getPrivate"), []byte("collectionA"), []byte("key1")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "getPrivate failed: "+result.Message)
+	assert.Equal(suite.T(), "secret", string(result.Payload))
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("getPrivate"), []byte("collectionB"), []byte("key1")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status)
+	assert.Nil(suite.T(), result.Payload, "collections should not share keys")
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("putPrivate"), []byte("collectionA"), []byte("key2")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "putPrivate without a transient value should fail")
+}
+
+func (suite *ChaincodeTS) TestScanPrivate() {
+	cc := suite.useTransientStub()
+	suite.putPrivate(cc, "collectionA", "key1", "value1")
+	suite.putPrivate(cc, "collectionA", "key2", "value2")
+	suite.putPrivate(cc, "collectionA", "key3", "value3")
+	suite.putPrivate(cc, "collectionB", "key1", "other")
+
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("scanPrivate"), []byte("collectionA"), []byte("key1"), []byte("key3")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "scanPrivate failed: "+result.Message)
+
+	var kvList []KV
+	json.Unmarshal(result.Payload, &kvList)
+	assert.Equal(suite.T(), []KV{{Key: "key1", Value: "value1"}, {Key: "key2", Value: "value2"}}, kvList)
+}
+
+func (suite *ChaincodeTS) TestDeletePrivateAndHash() {
+	cc := suite.useTransientStub()
+	suite.putPrivate(cc, "collectionA", "key1", "secret")
+
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("getPrivateHash"), []byte("collectionA"), []byte("key1")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "getPrivateHash failed: "+result.Message)
+	hash := sha256.Sum256([]byte("secret"))
+	assert.Equal(suite.T(), hex.EncodeToString(hash[:]), string(result.Payload))
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("deletePrivate"), []byte("collectionA"), []byte("key1")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "deletePrivate failed: "+result.Message)
+
+	value, _ := suite.stub.GetPrivateData("collectionA", "key1")
+	assert.Nil(suite.T(), value, "Private value has not been deleted")
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("getPrivateHash"), []byte("collectionA"), []byte("key1")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status)
+	assert.Nil(suite.T(), result.Payload)
+}
+
+func (suite *ChaincodeTS) TestVerifyPrivate() {
+	cc := suite.useTransientStub()
+	suite.putPrivate(cc, "collectionA", "key1", `{"price":99}`)
+
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("verifyPrivate"), []byte("collectionA"), []byte("key1"), []byte(`{"price":99}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "verifyPrivate failed: "+result.Message)
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("verifyPrivate"), []byte("collectionA"), []byte("key1"), []byte(`{"price":10}`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "another value should not verify")
+
+	cc.transient = map[string][]byte{transientValueField: []byte(`{"price":99}`)}
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("verifyPrivate"), []byte("collectionA"), []byte("key1")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "verifyPrivate with a transient value failed: "+result.Message)
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("verifyPrivate"), []byte("collectionA"), []byte("missing"), []byte(`{"price":99}`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "a missing key should not verify")
+}
+
+func (suite *ChaincodeTS) TestQueryPrivate() {
+	stub := mangostub.NewMockStub("mockStub", new(Chaincode))
+	suite.stub = stub.MockStub
+
+	stub.MockTransactionStart("1")
+	stub.PutPrivateData("collectionA", "marble1", []byte(`{"color":"blue","size":35}`))
+	stub.PutPrivateData("collectionA", "marble2", []byte(`{"color":"red","size":50}`))
+	stub.PutPrivateData("collectionA", "marble3", []byte(`{"color":"blue","size":70}`))
+	stub.PutPrivateData("collectionB", "marble4", []byte(`{"color":"blue","size":10}`))
+	stub.MockTransactionEnd("1")
+
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("queryPrivate"), []byte("collectionA"),
+		[]byte(`{"selector":{"color":"blue","size":{"$gt":40}}}`)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "queryPrivate failed: "+result.Message)
+
+	var kvList []KV
+	json.Unmarshal(result.Payload, &kvList)
+	assert.Equal(suite.T(), []KV{{Key: "marble3", Value: `{"color":"blue","size":70}`}}, kvList)
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("queryPrivate"), []byte("collectionA"), []byte(`{"color":"blue"}`)})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "a query without selector should fail")
+}
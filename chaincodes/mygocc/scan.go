@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// ScanOptions is the optional JSON argument of scan and scanPrefix
+type ScanOptions struct {
+	RecordFilter
+	Limit   int    // maximum number of results, 0 for no limit
+	Reverse bool   `metadata:",optional"` // return the results in descending key order
+	After   string `metadata:",optional"` // continue after this key, usually the LastKey of a previous response
+}
+
+type ScanResult struct {
+	Results []KV
+	LastKey string // key of the last result returned
+	HasMore bool   // more results are left in the range past LastKey
+}
+
+// scanPrefix scans all the keys starting with the given prefix
+func (c *Chaincode) scanPrefix(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting prefix and an optional options JSON")
+	}
+	prefix := args[0]
+
+	opts, err := parseScanOptions(args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("scanPrefix prefix='%s'\n", prefix)
+	result, err := scanRange(stub, prefix, prefixEndKey(prefix), opts)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	err = encoder.Encode(result)
+	if err != nil {
+		fmt.Println("Error encoding the data")
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(buffer.Bytes())
+}
+
+func parseScanOptions(args []string) (ScanOptions, error) {
+	opts := ScanOptions{}
+	if len(args) == 0 || args[0] == "" {
+		return opts, nil
+	}
+	if err := json.Unmarshal([]byte(args[0]), &opts); err != nil {
+		return opts, fmt.Errorf("Error unmarshalling the options. %s", err.Error())
+	}
+	if opts.Limit < 0 {
+		return opts, fmt.Errorf("limit must not be negative")
+	}
+	return opts, nil
+}
+
+// scanRange returns the keys in [startKey, endKey) honouring the record filter, limit, reverse
+// order and the After continuation key; the limit counts matching records only.
+// Values encrypted with the key passed in the transient map are decrypted before filtering.
+// The ledger iterators only go forward, so a reverse scan buffers the whole range
+// before picking its results.
+func scanRange(stub shim.ChaincodeStubInterface, startKey, endKey string, opts ScanOptions) (ScanResult, error) {
+	if opts.After != "" {
+		if opts.Reverse {
+			if endKey == "" || opts.After < endKey {
+				endKey = opts.After
+			}
+		} else if opts.After >= startKey {
+			// the smallest key greater than After
+			startKey = opts.After + "\x00"
+		}
+	}
+
+	filter, err := opts.compile()
+	if err != nil {
+		return ScanResult{}, err
+	}
+	vc, err := transientCipher(stub)
+	if err != nil {
+		return ScanResult{}, err
+	}
+
+	result := ScanResult{Results: make([]KV, 0)}
+	resultsIterator, err := stub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		fmt.Println("Error with GetStateByRange")
+		return result, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return result, err
+		}
+		value := queryResponse.Value
+		if vc != nil {
+			plaintext, encrypted, err := vc.decrypt(value)
+			if err != nil {
+				return result, fmt.Errorf("Error decrypting key '%s'. %s", queryResponse.Key, err.Error())
+			}
+			if encrypted {
+				value = plaintext
+			}
+		}
+		value, ok := filter.apply(value)
+		if !ok {
+			continue
+		}
+		if !opts.Reverse && opts.Limit > 0 && len(result.Results) == opts.Limit {
+			result.HasMore = true
+			break
+		}
+		result.Results = append(result.Results, KV{
+			Key:   queryResponse.Key,
+			Value: string(value),
+		})
+	}
+
+	if opts.Reverse {
+		arr := result.Results
+		for i, j := 0, len(arr)-1; i < j; i, j = i+1, j-1 {
+			arr[i], arr[j] = arr[j], arr[i]
+		}
+		if opts.Limit > 0 && len(arr) > opts.Limit {
+			result.Results = arr[:opts.Limit]
+			result.HasMore = true
+		}
+	}
+
+	if len(result.Results) > 0 {
+		result.LastKey = result.Results[len(result.Results)-1].Key
+	}
+
+	return result, nil
+}
+
+// prefixEndKey returns the exclusive end key of a range scan over all keys starting with prefix.
+// Like the partial composite key queries, it appends the largest unicode code point so the
+// end key stays valid UTF-8. An empty prefix means the whole (simple key) namespace.
+func prefixEndKey(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	return prefix + string(utf8.MaxRune)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/stretchr/testify/assert"
+)
+
+func (suite *ChaincodeTS) putRange(format string, from, to int) {
+	for i := from; i <= to; i++ {
+		result := suite.stub.MockInvoke("1", [][]byte{
+			[]byte("put"),
+			[]byte(fmt.Sprintf(format, i)),
+			[]byte(fmt.Sprintf("value%02d", i))})
+		assert.EqualValues(suite.T(), shim.OK, result.Status, "Put failed")
+	}
+}
+
+func (suite *ChaincodeTS) scanResult(args ...string) ScanResult {
+	invokeArgs := make([][]byte, 0)
+	for _, arg := range args {
+		invokeArgs = append(invokeArgs, []byte(arg))
+	}
+	result := suite.stub.MockInvoke("1", invokeArgs)
+	assert.EqualValues(suite.T(), shim.OK, result.Status, args[0]+" failed")
+
+	var scanResult ScanResult
+	err := json.Unmarshal(result.Payload, &scanResult)
+	assert.Nil(suite.T(), err, "Unable to unmarshal the scan result")
+	return scanResult
+}
+
+func resultKeys(scanResult ScanResult) []string {
+	keys := make([]string, 0)
+	for _, kv := range scanResult.Results {
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+func (suite *ChaincodeTS) TestScanPrefix() {
+	suite.putRange("key%02d", 1, 3)
+	suite.putRange("kez%02d", 1, 2)
+	suite.putRange("ké%02d", 1, 2)
+
+	scanResult := suite.scanResult("scanPrefix", "key")
+	assert.Equal(suite.T(), []string{"key01", "key02", "key03"}, resultKeys(scanResult))
+	assert.Equal(suite.T(), "key03", scanResult.LastKey)
+	assert.False(suite.T(), scanResult.HasMore)
+
+	scanResult = suite.scanResult("scanPrefix", "ké")
+	assert.Equal(suite.T(), []string{"ké01", "ké02"}, resultKeys(scanResult))
+}
+
+func (suite *ChaincodeTS) TestScanWithLimit() {
+	suite.putRange("key%02d", 1, 9)
+
+	scanResult := suite.scanResult("scan", "key02", "key08", `{"limit":3}`)
+	assert.Equal(suite.T(), []string{"key02", "key03", "key04"}, resultKeys(scanResult))
+	assert.True(suite.T(), scanResult.HasMore)
+
+	// continue from the last key returned
+	options, _ := json.Marshal(ScanOptions{Limit: 3, After: scanResult.LastKey})
+	scanResult = suite.scanResult("scan", "key02", "key08", string(options))
+	assert.Equal(suite.T(), []string{"key05", "key06", "key07"}, resultKeys(scanResult))
+
+	options, _ = json.Marshal(ScanOptions{Limit: 3, After: scanResult.LastKey})
+	scanResult = suite.scanResult("scan", "key02", "key08", string(options))
+	assert.Empty(suite.T(), scanResult.Results)
+	assert.False(suite.T(), scanResult.HasMore)
+}
+
+func (suite *ChaincodeTS) TestScanReverse() {
+	suite.putRange("key%02d", 1, 9)
+
+	scanResult := suite.scanResult("scanPrefix", "key", `{"reverse":true,"limit":4}`)
+	assert.Equal(suite.T(), []string{"key09", "key08", "key07", "key06"}, resultKeys(scanResult))
+	assert.True(suite.T(), scanResult.HasMore)
+
+	options, _ := json.Marshal(ScanOptions{Reverse: true, Limit: 4, After: scanResult.LastKey})
+	scanResult = suite.scanResult("scanPrefix", "key", string(options))
+	assert.Equal(suite.T(), []string{"key05", "key04", "key03", "key02"}, resultKeys(scanResult))
+
+	options, _ = json.Marshal(ScanOptions{Reverse: true, Limit: 4, After: scanResult.LastKey})
+	scanResult = suite.scanResult("scanPrefix", "key", string(options))
+	assert.Equal(suite.T(), []string{"key01"}, resultKeys(scanResult))
+	assert.False(suite.T(), scanResult.HasMore)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+
+	"schema"
+)
+
+// migrations is the ordered registry of the schema migrations, migrations[i] upgrades to version i+1.
+// The Version of the last one is the schema version of this chaincode.
+var migrations = []schema.Migration{
+	{Version: 1, Description: "Versioned schema", Step: schema.Noop},
+	{Version: 2, Description: "Notarizations under a reserved object type", Step: schema.Range(notarizationsMigration)},
+}
+
+type SchemaVersion struct {
+	Version   int    // schema version of the ledger, 0 for a ledger written before versioning
+	Latest    int    // schema version of the chaincode
+	ResumeKey string `metadata:",optional"` // key the running migration resumes from
+}
+
+type MigrationResult struct {
+	SchemaVersion
+	Processed int  // records processed by this call
+	Done      bool // the ledger is at the latest schema version
+}
+
+// checkSchemaVersion refuses to run a chaincode older than the ledger it finds
+func checkSchemaVersion(stub shim.ChaincodeStubInterface) error {
+	return schema.Check(stub, migrations)
+}
+
+// initSchemaVersion records the latest schema version on a new ledger, which has nothing to
+// migrate. A ledger holding keys without a schema version predates the versioning and stays at
+// version 0 until migrated. Only the simple keys are looked for: GetStateByRange does not return
+// the composite keys, and the migrations only rewrite simple keys.
+func initSchemaVersion(stub shim.ChaincodeStubInterface) error {
+	state, err := schema.LoadState(stub)
+	if err != nil || state.Version != 0 {
+		return err
+	}
+
+	resultsIterator, err := stub.GetStateByRange("\x01", string(utf8.MaxRune))
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+	if resultsIterator.HasNext() {
+		return nil
+	}
+
+	fmt.Printf("New ledger, recording schema version %d\n", schema.Latest(migrations))
+	return schema.SaveState(stub, schema.State{Version: schema.Latest(migrations)})
+}
+
+func loadSchemaState(stub shim.ChaincodeStubInterface) (SchemaVersion, error) {
+	state, err := schema.LoadState(stub)
+	return SchemaVersion{Version: state.Version, Latest: schema.Latest(migrations), ResumeKey: state.ResumeKey}, err
+}
+
+func (c *Chaincode) getSchemaVersion(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	state, err := loadSchemaState(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	stateJson, err := json.Marshal(state)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(stateJson)
+}
+
+// migrate runs the pending migration over at most limit records and saves where it stopped.
+// A transaction migrates to one version at most, call it again until Done.
+func (c *Chaincode) migrate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting limit")
+	}
+	limit, err := strconv.Atoi(args[0])
+	if err != nil || limit <= 0 {
+		return shim.Error("limit must be a positive integer")
+	}
+
+	state, processed, err := schema.Migrate(stub, migrations, limit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	result := MigrationResult{Processed: processed}
+	result.SchemaVersion = SchemaVersion{Version: state.Version, Latest: schema.Latest(migrations), ResumeKey: state.ResumeKey}
+	result.Done = state.Version == result.Latest
+
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	err = encoder.Encode(result)
+	if err != nil {
+		fmt.Println("Error encoding the data")
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(buffer.Bytes())
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/stretchr/testify/assert"
+
+	"schema"
+)
+
+// useMigrations replaces the migration registry and returns the function restoring it
+func useMigrations(registry []schema.Migration) func() {
+	saved := migrations
+	migrations = registry
+	return func() { migrations = saved }
+}
+
+func (suite *ChaincodeTS) migrate(limit string) MigrationResult {
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("migrate"), []byte(limit)})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "migrate failed: "+result.Message)
+
+	var migrationResult MigrationResult
+	err := json.Unmarshal(result.Payload, &migrationResult)
+	assert.Nil(suite.T(), err, "Unable to unmarshal the migration result")
+	return migrationResult
+}
+
+// dropSchemaVersion turns the ledger into one written before the schema versioning
+func (suite *ChaincodeTS) dropSchemaVersion() {
+	versionKey, _ := suite.stub.CreateCompositeKey("schemaVersion", []string{})
+	suite.stub.MockTransactionStart("legacy")
+	suite.stub.DelState(versionKey)
+	suite.stub.MockTransactionEnd("legacy")
+}
+
+func (suite *ChaincodeTS) TestMigrationsRegistry() {
+	for i, m := range migrations {
+		assert.Equal(suite.T(), i+1, m.Version, "migrations must be ordered without gaps")
+		assert.NotNil(suite.T(), m.Step)
+	}
+}
+
+func (suite *ChaincodeTS) TestMigrate() {
+	defer useMigrations([]schema.Migration{
+		{Version: 1, Description: "Versioned schema", Step: schema.Noop},
+		{Version: 2, Description: "Upper case values", Step: schema.Range(func(stub shim.ChaincodeStubInterface, key string, value []byte) error {
+			return stub.PutState(key, []byte(strings.ToUpper(string(value))))
+		})},
+	})()
+	suite.dropSchemaVersion()
+	suite.putRange("key%02d", 1, 5)
+
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("getSchemaVersion")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status)
+	assert.JSONEq(suite.T(), `{"Version":0,"Latest":2,"ResumeKey":""}`, string(result.Payload))
+
+	// a transaction migrates to one version at most
+	migrationResult := suite.migrate("2")
+	assert.Equal(suite.T(), 1, migrationResult.Version)
+	assert.Equal(suite.T(), 0, migrationResult.Processed)
+	assert.False(suite.T(), migrationResult.Done)
+
+	migrationResult = suite.migrate("2")
+	assert.Equal(suite.T(), 1, migrationResult.Version)
+	assert.Equal(suite.T(), "key03", migrationResult.ResumeKey)
+	assert.Equal(suite.T(), 2, migrationResult.Processed)
+	assert.False(suite.T(), migrationResult.Done)
+	suite.checkValuesExist([]string{"key02", "VALUE02", "key03", "value03"})
+
+	migrationResult = suite.migrate("2")
+	assert.Equal(suite.T(), "key05", migrationResult.ResumeKey)
+
+	migrationResult = suite.migrate("2")
+	assert.Equal(suite.T(), 2, migrationResult.Version)
+	assert.Equal(suite.T(), 1, migrationResult.Processed)
+	assert.True(suite.T(), migrationResult.Done)
+	suite.checkValuesExist([]string{"key01", "VALUE01", "key05", "VALUE05"})
+
+	migrationResult = suite.migrate("2")
+	assert.Equal(suite.T(), 0, migrationResult.Processed)
+	assert.True(suite.T(), migrationResult.Done)
+}
+
+func (suite *ChaincodeTS) TestInitRecordsSchemaVersion() {
+	result := suite.stub.MockInvoke("1", [][]byte{[]byte("getSchemaVersion")})
+	assert.JSONEq(suite.T(), `{"Version":2,"Latest":2,"ResumeKey":""}`, string(result.Payload), "a new ledger starts at the latest version")
+
+	// a legacy ledger is left for migrate
+	suite.dropSchemaVersion()
+	suite.stub.MockInvoke("1", [][]byte{[]byte("put"), []byte("key1"), []byte("value1")})
+	result = suite.stub.MockInit("1", [][]byte{[]byte("init")})
+	assert.EqualValues(suite.T(), shim.OK, result.Status, "Init failed: "+result.Message)
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("getSchemaVersion")})
+	assert.JSONEq(suite.T(), `{"Version":0,"Latest":2,"ResumeKey":""}`, string(result.Payload))
+}
+
+func (suite *ChaincodeTS) TestInitRefusesDowngrade() {
+	defer useMigrations([]schema.Migration{
+		{Version: 1, Description: "Versioned schema", Step: schema.Noop},
+		{Version: 2, Description: "Noop", Step: schema.Noop},
+	})()
+	suite.migrate("1")
+
+	// deploy a chaincode that only knows the first version
+	migrations = migrations[:1]
+	result := suite.stub.MockInit("1", [][]byte{[]byte("init")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "Init should refuse an older chaincode")
+	assert.Contains(suite.T(), result.Message, "refusing to downgrade")
+
+	result = suite.stub.MockInvoke("1", [][]byte{[]byte("migrate"), []byte("1")})
+	assert.EqualValues(suite.T(), shim.ERROR, result.Status, "migrate should refuse an older chaincode")
+}
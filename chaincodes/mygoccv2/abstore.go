@@ -17,11 +17,13 @@ limitations under the License.
 package main
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
+	"math/big"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-contract-api-go/metadata"
+
+	"ccserver"
 )
 
 // ABstore Chaincode implementation
@@ -29,107 +31,165 @@ type ABstore struct {
 	contractapi.Contract
 }
 
-func (t *ABstore) Init(ctx contractapi.TransactionContextInterface, A string, Aval int, B string, Bval int) error {
+// Init sets the balances of A and B, amounts are decimals with at most amountScale decimals.
+// Both accounts are bound to the client identity, which can transfer their ownership.
+// The admin and minter roles are granted to the clients of adminMSPs and minterMSPs, see SetRoleMSPs.
+// Init only runs once, on an empty ledger, afterwards money enters the ledger with Mint.
+func (t *ABstore) Init(ctx contractapi.TransactionContextInterface, A string, Aval string, B string, Bval string, adminMSPs []string, minterMSPs []string) error {
 	fmt.Println("ABstore Init")
-	var err error
+	err := checkSchemaVersion(ctx.GetStub())
+	if err != nil {
+		return err
+	}
+	if err := checkNotInitialized(ctx.GetStub()); err != nil {
+		return err
+	}
+	if A == B {
+		return &InvalidArgumentError{Argument: "B", Reason: "must be another account than A"}
+	}
+	owner, err := clientOwner(ctx.GetStub())
+	if err != nil {
+		return err
+	}
+	if err := initRoleMSPs(ctx.GetStub(), adminMSPs, minterMSPs); err != nil {
+		return err
+	}
 	// Initialize the chaincode
-	fmt.Printf("Aval = %d, Bval = %d\n", Aval, Bval)
-	// Write the state to the ledger
-	err = ctx.GetStub().PutState(A, []byte(strconv.Itoa(Aval)))
+	Abalance, err := parseBalanceAmount(Aval)
 	if err != nil {
 		return err
 	}
-
-	err = ctx.GetStub().PutState(B, []byte(strconv.Itoa(Bval)))
+	Bbalance, err := parseBalanceAmount(Bval)
 	if err != nil {
 		return err
 	}
+	fmt.Printf("Aval = %s, Bval = %s\n", formatAmount(Abalance), formatAmount(Bbalance))
+	// Write the state to the ledger, the ledger is empty and starts in the latest layout
+	for _, initial := range []struct {
+		account string
+		balance *big.Int
+	}{{A, Abalance}, {B, Bbalance}} {
+		key, err := balanceKey(ctx.GetStub(), defaultAsset, initial.account)
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().PutState(key, []byte(formatAmount(initial.balance))); err != nil {
+			return err
+		}
+		if err := putAccountOwner(ctx.GetStub(), initial.account, owner); err != nil {
+			return err
+		}
+		// the initial balances open the statements as mints
+		if initial.balance.Sign() == 0 {
+			continue
+		}
+		if err := recordTransfer(ctx.GetStub(), defaultAsset, "", nil, initial.account, initial.balance, initial.balance, nil); err != nil {
+			return err
+		}
+	}
 
-	return nil
+	// a new ledger starts at the latest schema version, with nothing to migrate
+	if err := saveSchemaState(ctx.GetStub(), SchemaVersion{Version: latestSchemaVersion()}); err != nil {
+		return err
+	}
+	return putTotalSupply(ctx.GetStub(), defaultAsset, new(big.Int).Add(Abalance, Bbalance))
 }
 
-// Transaction makes payment of X units from A to B
-func (t *ABstore) Invoke(ctx contractapi.TransactionContextInterface, A, B string, X int) error {
-	var err error
-	var Aval int
-	var Bval int
-	// Get the state from the ledger
-	// TODO: will be nice to have a GetAllState call to ledger
-	Avalbytes, err := ctx.GetStub().GetState(A)
+// Transaction makes payment of X units from A to B, only the owner of A can debit it.
+// A also pays the fee of the fee schedule to the treasury.
+func (t *ABstore) Invoke(ctx contractapi.TransactionContextInterface, A, B string, X string) error {
+	amount, err := parsePositiveAmount(X)
 	if err != nil {
-		return fmt.Errorf("Failed to get state")
+		return err
+	}
+	if err := checkOwner(ctx.GetStub(), A); err != nil {
+		return err
 	}
-	if Avalbytes == nil {
-		return fmt.Errorf("Entity not found")
+	fee, err := paymentFee(ctx.GetStub(), defaultAsset, A, amount)
+	if err != nil {
+		return err
 	}
-	Aval, _ = strconv.Atoi(string(Avalbytes))
+	return transferFunds(ctx.GetStub(), defaultAsset, A, B, amount, fee, "")
+}
 
-	Bvalbytes, err := ctx.GetStub().GetState(B)
+// Delete  an entity from state, the owner of the account or an admin can delete it once empty in all the assets
+func (t *ABstore) Delete(ctx contractapi.TransactionContextInterface, A string) error {
+	if err := checkOwnerOrAdmin(ctx.GetStub(), A); err != nil {
+		return err
+	}
+	balance, err := getBalance(ctx.GetStub(), A)
 	if err != nil {
-		return fmt.Errorf("Failed to get state")
+		return err
 	}
-	if Bvalbytes == nil {
-		return fmt.Errorf("Entity not found")
+	if balance.Sign() != 0 {
+		return &AccountNotEmptyError{Account: A, Balance: formatAmount(balance)}
 	}
-	Bval, _ = strconv.Atoi(string(Bvalbytes))
-
-	// Perform the execution
-	Aval = Aval - X
-	Bval = Bval + X
-	fmt.Printf("Aval = %d, Bval = %d\n", Aval, Bval)
-
-	// Write the state back to the ledger
-	err = ctx.GetStub().PutState(A, []byte(strconv.Itoa(Aval)))
+	assets, err := registeredAssets(ctx.GetStub())
 	if err != nil {
 		return err
 	}
-
-	err = ctx.GetStub().PutState(B, []byte(strconv.Itoa(Bval)))
+	for _, asset := range assets {
+		balance, err := getAssetBalance(ctx.GetStub(), asset, A)
+		if err != nil {
+			return err
+		}
+		if balance.Sign() != 0 {
+			return &AccountNotEmptyError{Account: A, Balance: asset.formatAmount(balance) + " " + asset.Symbol}
+		}
+	}
+	treasury, err := isTreasury(ctx.GetStub(), A)
 	if err != nil {
 		return err
 	}
-
-	return nil
-}
-
-// Delete  an entity from state
-func (t *ABstore) Delete(ctx contractapi.TransactionContextInterface, A string) error {
-
-	// Delete the key from the state in ledger
-	err := ctx.GetStub().DelState(A)
+	if treasury {
+		return &InvalidArgumentError{Argument: "account", Reason: A + " is the fee treasury"}
+	}
+	pending, err := hasHolds(ctx.GetStub(), A)
 	if err != nil {
-		return fmt.Errorf("Failed to delete state")
+		return err
+	}
+	if pending {
+		return &HoldsPendingError{Account: A}
 	}
 
-	return nil
-}
-
-// Query callback representing the query of a chaincode
-func (t *ABstore) Query(ctx contractapi.TransactionContextInterface, A string) (string, error) {
-	var err error
-	// Get the state from the ledger
-	Avalbytes, err := ctx.GetStub().GetState(A)
-	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for " + A + "\"}"
-		return "", errors.New(jsonResp)
+	// Delete the keys from the state in ledger
+	if err := delBalances(ctx.GetStub(), A); err != nil {
+		return err
 	}
 
-	if Avalbytes == nil {
-		jsonResp := "{\"Error\":\"Nil amount for " + A + "\"}"
-		return "", errors.New(jsonResp)
+	if err := deleteAllowances(ctx.GetStub(), A); err != nil {
+		return err
 	}
+	return delAccountOwner(ctx.GetStub(), A)
+}
+
+// Query callback representing the query of a chaincode
+func (t *ABstore) Query(ctx contractapi.TransactionContextInterface, A string) (Account, error) {
+	return getAccount(ctx.GetStub(), A)
+}
 
-	jsonResp := "{\"Name\":\"" + A + "\",\"Amount\":\"" + string(Avalbytes) + "\"}"
-	fmt.Printf("Query Response:%s\n", jsonResp)
-	return string(Avalbytes), nil
+// GetEvaluateTransactions returns the read-only transactions, tagged as evaluate in the metadata
+func (t *ABstore) GetEvaluateTransactions() []string {
+	return []string{"Query", "GetAllAccounts", "GetAccountOwner", "Allowance", "TotalSupply", "CheckInvariants", "GetHold", "GetTransfers", "GetAsset", "QueryAsset", "GetFeeSchedule", "GetSchemaVersion", "GetRoleMSPs", "GetCorruptedBalances"}
 }
 
 func main() {
-	cc, err := contractapi.NewChaincode(new(ABstore))
+	abstore := new(ABstore)
+	abstore.Info = metadata.InfoMetadata{
+		Title:       "ABstore",
+		Description: "Transfers of fixed-scale decimal amounts between identity-bound accounts",
+		Version:     "2.0.0",
+	}
+	cc, err := contractapi.NewChaincode(abstore)
 	if err != nil {
 		panic(err.Error())
 	}
-	if err := cc.Start(); err != nil {
+	cc.Info = metadata.InfoMetadata{
+		Title:   "mygoccv2",
+		Version: "2.0.0",
+		License: &metadata.LicenseMetadata{Name: "Apache-2.0", URL: "http://www.apache.org/licenses/LICENSE-2.0"},
+	}
+	if err := ccserver.Run(cc); err != nil {
 		fmt.Printf("Error starting ABstore chaincode: %s", err)
 	}
 }
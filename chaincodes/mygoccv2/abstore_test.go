@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func checkBalance(t *testing.T, stub *shimtest.MockStub, account string, balance string) {
+	result := invoke(stub, "Query", account)
+	assert.EqualValues(t, shim.OK, result.Status, "Query failed: "+result.Message)
+	var a Account
+	json.Unmarshal(result.Payload, &a)
+	assert.Equal(t, balance, a.Balance)
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		amount string
+		units  string
+	}{
+		{"100", "10000"},
+		{"0", "0"},
+		{"12.5", "1250"},
+		{"12.05", "1205"},
+		{"-3.10", "-310"},
+		{"123456789012345678901234567890", "12345678901234567890123456789000"},
+	}
+	for _, test := range tests {
+		value, err := parseAmount(test.amount)
+		assert.Nil(t, err, test.amount)
+		assert.Equal(t, test.units, value.String(), test.amount)
+	}
+
+	for _, amount := range []string{"", "abc", "1.234", "1.", ".5", "1e3", "+1", " 1"} {
+		_, err := parseAmount(amount)
+		var invalid *InvalidAmountError
+		assert.True(t, errors.As(err, &invalid), "%q should be rejected", amount)
+	}
+
+	assert.Equal(t, "12.05", formatAmount(big.NewInt(1205)))
+	assert.Equal(t, "0.00", formatAmount(big.NewInt(0)))
+	assert.Equal(t, "-0.07", formatAmount(big.NewInt(-7)))
+}
+
+func TestInvoke(t *testing.T) {
+	stub := newABstoreStub(t)
+	result := invoke(stub, "Init", "a", "100", "b", "200.50", org1MSPs, org1MSPs)
+	assert.EqualValues(t, shim.OK, result.Status, "Init failed: "+result.Message)
+
+	result = invoke(stub, "Invoke", "a", "b", "10.25")
+	assert.EqualValues(t, shim.OK, result.Status, "Invoke failed: "+result.Message)
+	checkBalance(t, stub, "a", "89.75")
+	checkBalance(t, stub, "b", "210.75")
+
+	// the whole balance can be transferred
+	result = invoke(stub, "Invoke", "a", "b", "89.75")
+	assert.EqualValues(t, shim.OK, result.Status, "Invoke failed: "+result.Message)
+	checkBalance(t, stub, "a", "0.00")
+
+	result = invoke(stub, "Init", "a", "-1", "b", "0", org1MSPs, org1MSPs)
+	assert.EqualValues(t, shim.ERROR, result.Status, "negative balances should be rejected")
+}
+
+func TestInvokeErrors(t *testing.T) {
+	stub := newABstoreStub(t)
+	invoke(stub, "Init", "a", "100", "b", "200", org1MSPs, org1MSPs)
+
+	tests := []struct {
+		from, to, amount string
+		message          string
+	}{
+		{"a", "b", "0", "must be greater than zero"},
+		{"a", "b", "-5", "must be greater than zero"},
+		{"a", "b", "1.001", "at most 2 decimals"},
+		{"a", "b", "ten", "expecting a decimal number"},
+		{"a", "b", "100.01", "Insufficient funds in a"},
+		{"a", "a", "1", "Cannot transfer from a to itself"},
+		{"a", "c", "1", "Entity not found: c"},
+	}
+	for _, test := range tests {
+		result := invoke(stub, "Invoke", test.from, test.to, test.amount)
+		assert.EqualValues(t, shim.ERROR, result.Status, "%s -> %s %s should fail", test.from, test.to, test.amount)
+		assert.Contains(t, result.Message, test.message)
+	}
+	checkBalance(t, stub, "a", "100.00")
+	checkBalance(t, stub, "b", "200.00")
+}
+
+func TestLegacyBalances(t *testing.T) {
+	stub := newABstoreStub(t)
+	stub.MockTransactionStart("1")
+	stub.PutState("a", []byte("100"))
+	stub.PutState("b", []byte("200"))
+	stub.PutState("corrupted", []byte("12abc"))
+	owner, _ := clientOwner(stub)
+	for _, account := range []string{"a", "b", "corrupted"} {
+		putAccountOwner(stub, account, owner)
+	}
+	stub.MockTransactionEnd("1")
+
+	// integer balances are read as they are, but cannot change until the ledger is migrated
+	checkBalance(t, stub, "a", "100.00")
+	result := invoke(stub, "Invoke", "a", "b", "1.5")
+	assert.EqualValues(t, shim.ERROR, result.Status)
+	assert.Contains(t, result.Message, string(CodeMigrationRequired))
+	checkBalance(t, stub, "b", "200.00")
+
+	// corrupted balances are no longer read as zero
+	result = invoke(stub, "Query", "corrupted")
+	assert.EqualValues(t, shim.ERROR, result.Status)
+	assert.Contains(t, result.Message, "Corrupted balance")
+
+	// the migration quarantines them and carries on
+	result = migrateAll(t, stub, "10")
+	assert.EqualValues(t, shim.OK, result.Status, "Migrate failed: "+result.Message)
+	checkBalance(t, stub, "corrupted", "0.00")
+	result = invoke(stub, "GetCorruptedBalances")
+	assert.EqualValues(t, shim.OK, result.Status, "GetCorruptedBalances failed: "+result.Message)
+	assert.JSONEq(t, `[{"account":"corrupted","value":"12abc"}]`, string(result.Payload))
+	result = invoke(stub, "Invoke", "a", "b", "1.5")
+	assert.EqualValues(t, shim.OK, result.Status, "Invoke failed: "+result.Message)
+	checkBalance(t, stub, "b", "201.50")
+
+	// until an admin repairs their balance
+	alice, _, admin := identities(t)
+	result = invokeAs(stub, alice, "RepairBalance", "corrupted", "12.00")
+	assert.EqualValues(t, shim.ERROR, result.Status, "only admins should repair balances")
+	assert.Contains(t, result.Message, string(CodeMissingRole))
+	result = invokeAs(stub, admin, "RepairBalance", "a", "12.00")
+	assert.EqualValues(t, shim.ERROR, result.Status, "a sound balance should not be repaired")
+	result = invokeAs(stub, admin, "RepairBalance", "corrupted", "12.00")
+	assert.EqualValues(t, shim.OK, result.Status, "RepairBalance failed: "+result.Message)
+	checkBalance(t, stub, "corrupted", "12.00")
+	event := lastEvent(stub)
+	assert.Equal(t, "Transfer", event.EventName)
+	assert.JSONEq(t, `{"asset":"ABS","from":"","to":"corrupted","value":"12.00"}`, string(event.Payload))
+	assert.True(t, checkInvariants(t, stub).Consistent, "the repair should be counted in the total supply")
+
+	result = invoke(stub, "GetCorruptedBalances")
+	assert.JSONEq(t, `[]`, string(result.Payload))
+	result = invokeAs(stub, admin, "RepairBalance", "corrupted", "12.00")
+	assert.EqualValues(t, shim.ERROR, result.Status, "a balance is repaired once")
+}
+
+func TestDecimalBalancesMigration(t *testing.T) {
+	defer useMigrations(migrations[:2])()
+	stub := newABstoreStub(t)
+	stub.MockTransactionStart("1")
+	stub.PutState("a", []byte("100"))
+	stub.PutState("b", []byte("25.50"))
+	stub.MockTransactionEnd("1")
+
+	result := migrateAll(t, stub, "10")
+	assert.EqualValues(t, shim.OK, result.Status, "Migrate failed: "+result.Message)
+
+	a, _ := stub.GetState("a")
+	b, _ := stub.GetState("b")
+	assert.Equal(t, "100.00", string(a))
+	assert.Equal(t, "25.50", string(b))
+}
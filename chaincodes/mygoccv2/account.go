@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Account is the balance and owner of an account
+type Account struct {
+	Name      string       `json:"name"`
+	Balance   string       `json:"balance"`   // decimal with amountScale decimals
+	Held      string       `json:"held"`      // sum of the active holds on the account
+	Available string       `json:"available"` // balance minus the active holds, what the account can spend
+	Owner     AccountOwner `json:"owner"`     // empty for an account without owner
+}
+
+// AccountsPage is a page of accounts in name order
+type AccountsPage struct {
+	Accounts []Account `json:"accounts"`
+	Bookmark string    `json:"bookmark" metadata:",optional"` // bookmark of the next page, empty on the last page
+}
+
+// GetAllAccounts returns at most pageSize accounts starting from the bookmark of the previous page
+func (t *ABstore) GetAllAccounts(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (AccountsPage, error) {
+	page := AccountsPage{Accounts: make([]Account, 0)}
+	if pageSize <= 0 {
+		return page, &InvalidArgumentError{Argument: "pageSize", Reason: "must be a positive integer"}
+	}
+
+	stub := ctx.GetStub()
+	if err := checkLatestSchema(stub); err != nil {
+		return page, err
+	}
+	// paginated queries are only served to evaluated transactions, which is what GetAllAccounts is
+	resultsIterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(balanceObjectType, []string{defaultAssetSymbol}, int32(pageSize), bookmark)
+	if err != nil {
+		return page, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return page, err
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return page, err
+		}
+		account, err := getAccount(stub, attributes[1])
+		if err != nil {
+			return page, err
+		}
+		page.Accounts = append(page.Accounts, account)
+	}
+	page.Bookmark = metadata.Bookmark
+	return page, nil
+}
+
+func getAccount(stub shim.ChaincodeStubInterface, name string) (Account, error) {
+	account := Account{Name: name}
+	balance, err := getBalance(stub, name)
+	if err != nil {
+		return account, err
+	}
+	account.Balance = formatAmount(balance)
+	held, err := heldAmount(stub, name, "")
+	if err != nil {
+		return account, err
+	}
+	account.Held = formatAmount(held)
+	account.Available = formatAmount(balance.Sub(balance, held))
+
+	owner, err := getAccountOwner(stub, name)
+	if err != nil {
+		return account, err
+	}
+	if owner != nil {
+		account.Owner = *owner
+	}
+	fmt.Printf("Account %s = %s\n", name, account.Balance)
+	return account, nil
+}
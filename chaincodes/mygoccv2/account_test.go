@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func getAllAccounts(t *testing.T, stub *shimtest.MockStub, pageSize int, bookmark string) AccountsPage {
+	result := invoke(stub, "GetAllAccounts", fmt.Sprint(pageSize), bookmark)
+	assert.EqualValues(t, shim.OK, result.Status, "GetAllAccounts failed: "+result.Message)
+	var page AccountsPage
+	err := json.Unmarshal(result.Payload, &page)
+	assert.Nil(t, err, "Unable to unmarshal the accounts")
+	return page
+}
+
+func TestQuery(t *testing.T) {
+	stub := newABstoreStub(t)
+	invoke(stub, "Init", "a", "100", "b", "200.5", org1MSPs, org1MSPs)
+
+	result := invoke(stub, "Query", "b")
+	assert.EqualValues(t, shim.OK, result.Status, "Query failed: "+result.Message)
+	assert.JSONEq(t, `{"name":"b","balance":"200.50","held":"0.00","available":"200.50","owner":{"mspId":"Org1MSP","id":"alice"}}`, string(result.Payload))
+
+	result = invoke(stub, "Query", "c")
+	assert.EqualValues(t, shim.ERROR, result.Status)
+	assert.Equal(t, "ACCOUNT_NOT_FOUND: Entity not found: c", result.Message)
+}
+
+func TestGetAllAccounts(t *testing.T) {
+	alice, bob, _ := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "200", org1MSPs, org1MSPs)
+	for _, account := range []string{"c", "d", "e"} {
+		invokeAs(stub, bob, "CreateAccount", account)
+	}
+
+	page := getAllAccounts(t, stub, 2, "")
+	assert.Equal(t, []Account{
+		{Name: "a", Balance: "100.00", Held: "0.00", Available: "100.00", Owner: AccountOwner{MSPID: "Org1MSP", ID: "alice"}},
+		{Name: "b", Balance: "200.00", Held: "0.00", Available: "200.00", Owner: AccountOwner{MSPID: "Org1MSP", ID: "alice"}},
+	}, page.Accounts)
+	assert.NotEmpty(t, page.Bookmark)
+
+	names := make([]string, 0)
+	for bookmark := ""; ; {
+		page = getAllAccounts(t, stub, 2, bookmark)
+		for _, account := range page.Accounts {
+			names = append(names, account.Name)
+		}
+		if bookmark = page.Bookmark; bookmark == "" {
+			break
+		}
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, names, "the owner keys should not be listed")
+
+	result := invoke(stub, "GetAllAccounts", "0", "")
+	assert.EqualValues(t, shim.ERROR, result.Status)
+	assert.Contains(t, result.Message, string(CodeInvalidArgument))
+}
+
+func TestErrorCodes(t *testing.T) {
+	errs := []CodedError{
+		&InvalidArgumentError{}, &InvalidAmountError{}, &AccountNotFoundError{}, &AccountExistsError{},
+		&CorruptedBalanceError{}, &InsufficientFundsError{}, &SelfTransferError{}, &NotAccountOwnerError{},
+		&InsufficientAllowanceError{}, &MissingRoleError{}, &AlreadyInitializedError{}, &MigrationRequiredError{},
+		&AccountNotEmptyError{}, &HoldNotFoundError{}, &HoldExpiredError{}, &HoldsPendingError{},
+		&HoldNotExpiredError{}, &InvalidPreimageError{}, &CorruptedStateError{}, &InternalError{},
+	}
+	codes := make(map[ErrorCode]bool)
+	for _, err := range errs {
+		assert.Regexp(t, "^"+string(err.Code())+": ", err.Error())
+		codes[err.Code()] = true
+	}
+	assert.Len(t, codes, len(errs), "the error codes should be distinct")
+
+	_, err := parsePositiveAmount("0")
+	var coded CodedError
+	assert.True(t, errors.As(err, &coded))
+	assert.Equal(t, CodeInvalidAmount, coded.Code())
+
+	err = &MigrationFailedError{Version: 2, Err: &CorruptedBalanceError{Account: "a", Value: "x"}}
+	assert.Equal(t, `CORRUPTED_BALANCE: Migration to schema version 2 failed: Corrupted balance "x" for a`, err.Error())
+	err = &MigrationFailedError{Version: 2, Err: errors.New("boom")}
+	assert.Equal(t, "INTERNAL: Migration to schema version 2 failed: boom", err.Error())
+}
+
+func TestCorruptedState(t *testing.T) {
+	alice, _, _ := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100")
+
+	key, _ := stub.CreateCompositeKey(feeScheduleObjectType, []string{defaultAssetSymbol})
+	stub.MockTransactionStart("1")
+	stub.PutState(key, []byte("{"))
+	stub.MockTransactionEnd("1")
+	result := invoke(stub, "GetFeeSchedule", defaultAssetSymbol)
+	assert.EqualValues(t, shim.ERROR, result.Status)
+	assert.Contains(t, result.Message, string(CodeCorruptedState))
+}
+
+func TestMetadata(t *testing.T) {
+	stub := newABstoreStub(t)
+	result := invoke(stub, "org.hyperledger.fabric:GetMetadata")
+	assert.EqualValues(t, shim.OK, result.Status, "GetMetadata failed: "+result.Message)
+
+	var contractMetadata struct {
+		Contracts map[string]struct {
+			Transactions []struct {
+				Name    string
+				Tag     []string
+				Returns map[string]interface{}
+			}
+		}
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{}
+			}
+		}
+	}
+	err := json.Unmarshal(result.Payload, &contractMetadata)
+	assert.Nil(t, err, "Unable to unmarshal the metadata")
+
+	tags := make(map[string][]string)
+	for _, tx := range contractMetadata.Contracts["ABstore"].Transactions {
+		tags[tx.Name] = tx.Tag
+	}
+	assert.Equal(t, []string{"evaluate"}, tags["Query"])
+	assert.Equal(t, []string{"evaluate"}, tags["GetAllAccounts"])
+	assert.Equal(t, []string{"submit"}, tags["Invoke"])
+
+	assert.Contains(t, contractMetadata.Components.Schemas, "Account")
+	assert.Contains(t, contractMetadata.Components.Schemas["Account"].Properties, "balance")
+	assert.Contains(t, contractMetadata.Components.Schemas["AccountsPage"].Properties, "bookmark")
+}
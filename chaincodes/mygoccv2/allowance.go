@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	allowanceObjectType = "allowance"
+	approvalEvent       = "Approval"
+	transferEvent       = "Transfer"
+)
+
+// ApprovalEvent is the payload of the Approval event
+type ApprovalEvent struct {
+	Owner   string `json:"owner"`
+	Spender string `json:"spender"`
+	Value   string `json:"value"`
+}
+
+// TransferEvent is the payload of the Transfer event
+type TransferEvent struct {
+	Asset string `json:"asset"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value string `json:"value"`
+	Fee   string `json:"fee,omitempty"` // fee paid by the sender on top of the value, when charged
+}
+
+// String formats the identity as MSPID:ID, the format of the spenders
+func (o AccountOwner) String() string {
+	return o.MSPID + ":" + o.ID
+}
+
+// parseSpender parses a MSPID:ID spender identity
+func parseSpender(spender string) (AccountOwner, error) {
+	return parseIdentity("spender", spender)
+}
+
+// parseIdentity parses the MSPID:ID identity passed as argument
+func parseIdentity(argument string, identity string) (AccountOwner, error) {
+	i := strings.Index(identity, ":")
+	if i <= 0 || i == len(identity)-1 {
+		return AccountOwner{}, &InvalidArgumentError{Argument: argument, Reason: "expecting MSPID:ID"}
+	}
+	return AccountOwner{MSPID: identity[:i], ID: identity[i+1:]}, nil
+}
+
+// Approve allows the spender identity, formatted as MSPID:ID, to transfer up to amount from the
+// owner account with TransferFrom. The amount replaces the previous allowance, 0 revokes it.
+// Only the owner of the account can approve.
+func (t *ABstore) Approve(ctx contractapi.TransactionContextInterface, owner string, spender string, amount string) error {
+	stub := ctx.GetStub()
+	spenderIdentity, err := parseSpender(spender)
+	if err != nil {
+		return err
+	}
+	value, err := parseBalanceAmount(amount)
+	if err != nil {
+		return err
+	}
+	if _, err := getBalance(stub, owner); err != nil {
+		return err
+	}
+	if err := checkOwner(stub, owner); err != nil {
+		return err
+	}
+
+	if err := putAllowance(stub, owner, spenderIdentity, value); err != nil {
+		return err
+	}
+	return setEvent(stub, approvalEvent, ApprovalEvent{Owner: owner, Spender: spenderIdentity.String(), Value: formatAmount(value)})
+}
+
+// Allowance returns the amount the spender identity can still transfer from the owner account
+func (t *ABstore) Allowance(ctx contractapi.TransactionContextInterface, owner string, spender string) (string, error) {
+	spenderIdentity, err := parseSpender(spender)
+	if err != nil {
+		return "", err
+	}
+	allowance, err := getAllowance(ctx.GetStub(), owner, spenderIdentity)
+	if err != nil {
+		return "", err
+	}
+	return formatAmount(allowance), nil
+}
+
+// TransferFrom transfers amount from the owner account to another account on behalf of the
+// owner, within the allowance the owner approved for the client identity. The owner pays the fee
+// of the fee schedule on top of the amount, the fee does not use the allowance.
+func (t *ABstore) TransferFrom(ctx contractapi.TransactionContextInterface, owner string, to string, amount string) error {
+	stub := ctx.GetStub()
+	value, err := parsePositiveAmount(amount)
+	if err != nil {
+		return err
+	}
+	spender, err := clientOwner(stub)
+	if err != nil {
+		return err
+	}
+	allowance, err := getAllowance(stub, owner, spender)
+	if err != nil {
+		return err
+	}
+	if allowance.Cmp(value) < 0 {
+		return &InsufficientAllowanceError{Owner: owner, Spender: spender.String(), Allowance: formatAmount(allowance), Amount: formatAmount(value)}
+	}
+
+	fee, err := paymentFee(stub, defaultAsset, owner, value)
+	if err != nil {
+		return err
+	}
+	if err := transferFunds(stub, defaultAsset, owner, to, value, fee, ""); err != nil {
+		return err
+	}
+	return putAllowance(stub, owner, spender, allowance.Sub(allowance, value))
+}
+
+func allowanceKey(stub shim.ChaincodeStubInterface, owner string, spender AccountOwner) (string, error) {
+	return stub.CreateCompositeKey(allowanceObjectType, []string{owner, spender.MSPID, spender.ID})
+}
+
+func getAllowance(stub shim.ChaincodeStubInterface, owner string, spender AccountOwner) (*big.Int, error) {
+	key, err := allowanceKey(stub, owner, spender)
+	if err != nil {
+		return nil, err
+	}
+	allowanceBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, &InternalError{Reason: "Failed to get state"}
+	}
+	if allowanceBytes == nil {
+		return new(big.Int), nil
+	}
+	allowance, err := parseAmount(string(allowanceBytes))
+	if err != nil {
+		return nil, &CorruptedStateError{Object: fmt.Sprintf("allowance %q of %s for %s", string(allowanceBytes), spender.String(), owner)}
+	}
+	return allowance, nil
+}
+
+// putAllowance saves an allowance, a zero allowance is deleted
+func putAllowance(stub shim.ChaincodeStubInterface, owner string, spender AccountOwner, allowance *big.Int) error {
+	key, err := allowanceKey(stub, owner, spender)
+	if err != nil {
+		return err
+	}
+	if allowance.Sign() == 0 {
+		return stub.DelState(key)
+	}
+	return stub.PutState(key, []byte(formatAmount(allowance)))
+}
+
+// deleteAllowances deletes the allowances of an owner account
+func deleteAllowances(stub shim.ChaincodeStubInterface, owner string) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(allowanceObjectType, []string{owner})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		if err := stub.DelState(queryResponse.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setEvent sets the chaincode event of the transaction, a transaction has a single event
+func setEvent(stub shim.ChaincodeStubInterface, name string, payload interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(name, payloadBytes)
+}
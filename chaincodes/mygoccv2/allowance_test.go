@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// lastEvent drains the events set by the previous invocations and returns the last one
+func lastEvent(stub *shimtest.MockStub) *pb.ChaincodeEvent {
+	var event *pb.ChaincodeEvent
+	for {
+		select {
+		case event = <-stub.ChaincodeEventsChannel:
+		default:
+			return event
+		}
+	}
+}
+
+func checkAllowance(t *testing.T, stub *shimtest.MockStub, owner string, spender string, allowance string) {
+	result := invoke(stub, "Allowance", owner, spender)
+	assert.EqualValues(t, shim.OK, result.Status, "Allowance failed: "+result.Message)
+	assert.Equal(t, allowance, string(result.Payload))
+}
+
+func TestApprove(t *testing.T) {
+	alice, bob, _ := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	checkAllowance(t, stub, "a", "Org1MSP:bob", "0.00")
+
+	result := invokeAs(stub, alice, "Approve", "a", "Org1MSP:bob", "25.5")
+	assert.EqualValues(t, shim.OK, result.Status, "Approve failed: "+result.Message)
+	checkAllowance(t, stub, "a", "Org1MSP:bob", "25.50")
+
+	event := lastEvent(stub)
+	assert.Equal(t, "Approval", event.EventName)
+	assert.JSONEq(t, `{"owner":"a","spender":"Org1MSP:bob","value":"25.50"}`, string(event.Payload))
+
+	// approving again replaces the allowance
+	invokeAs(stub, alice, "Approve", "a", "Org1MSP:bob", "10")
+	checkAllowance(t, stub, "a", "Org1MSP:bob", "10.00")
+
+	result = invokeAs(stub, bob, "Approve", "a", "Org1MSP:bob", "1000")
+	assert.EqualValues(t, shim.ERROR, result.Status, "only the owner of a should approve")
+	assert.Contains(t, result.Message, string(CodeNotAccountOwner))
+
+	for _, args := range [][]string{
+		{"a", "bob", "10"},
+		{"a", "Org1MSP:", "10"},
+		{"a", "Org1MSP:bob", "-1"},
+		{"c", "Org1MSP:bob", "10"},
+	} {
+		result = invokeAs(stub, alice, "Approve", args...)
+		assert.EqualValues(t, shim.ERROR, result.Status, "Approve %v should fail", args)
+	}
+}
+
+func TestTransferFrom(t *testing.T) {
+	alice, bob, admin := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	invokeAs(stub, alice, "Approve", "a", "Org1MSP:bob", "30")
+
+	result := invokeAs(stub, bob, "TransferFrom", "a", "b", "20")
+	assert.EqualValues(t, shim.OK, result.Status, "TransferFrom failed: "+result.Message)
+	checkBalance(t, stub, "a", "80.00")
+	checkBalance(t, stub, "b", "20.00")
+	checkAllowance(t, stub, "a", "Org1MSP:bob", "10.00")
+
+	event := lastEvent(stub)
+	assert.Equal(t, "Transfer", event.EventName)
+	var transferred TransferEvent
+	json.Unmarshal(event.Payload, &transferred)
+	assert.Equal(t, TransferEvent{Asset: "ABS", From: "a", To: "b", Value: "20.00"}, transferred)
+
+	result = invokeAs(stub, bob, "TransferFrom", "a", "b", "10.01")
+	assert.EqualValues(t, shim.ERROR, result.Status, "TransferFrom should not exceed the allowance")
+	assert.Contains(t, result.Message, string(CodeInsufficientAllowance))
+
+	result = invokeAs(stub, admin, "TransferFrom", "a", "b", "1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "the admin has no allowance")
+
+	// the remaining allowance is spent, then removed
+	result = invokeAs(stub, bob, "TransferFrom", "a", "b", "10")
+	assert.EqualValues(t, shim.OK, result.Status, "TransferFrom failed: "+result.Message)
+	checkAllowance(t, stub, "a", "Org1MSP:bob", "0.00")
+	allowanceKey, _ := stub.CreateCompositeKey(allowanceObjectType, []string{"a", "Org1MSP", "bob"})
+	allowance, _ := stub.GetState(allowanceKey)
+	assert.Nil(t, allowance)
+}
+
+func TestTransferFromChecksBalances(t *testing.T) {
+	alice, bob, _ := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "5", "b", "0", org1MSPs, org1MSPs)
+	invokeAs(stub, alice, "Approve", "a", "Org1MSP:bob", "50")
+
+	result := invokeAs(stub, bob, "TransferFrom", "a", "b", "10")
+	assert.EqualValues(t, shim.ERROR, result.Status, "the allowance does not bypass the balance")
+	assert.Contains(t, result.Message, string(CodeInsufficientFunds))
+	checkAllowance(t, stub, "a", "Org1MSP:bob", "50.00")
+
+	// deleting the account revokes its allowances
+	invokeAs(stub, alice, "Invoke", "a", "b", "5")
+	result = invokeAs(stub, alice, "Delete", "a")
+	assert.EqualValues(t, shim.OK, result.Status, "Delete failed: "+result.Message)
+	invokeAs(stub, alice, "CreateAccount", "a")
+	checkAllowance(t, stub, "a", "Org1MSP:bob", "0.00")
+}
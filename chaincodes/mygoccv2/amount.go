@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// amountScale is the number of decimals of the balances, which are stored as decimal strings
+// with exactly amountScale decimals. Balances written as integers before are still readable.
+const amountScale = 2
+
+// corruptedBalanceObjectType keeps the legacy balances the migration could not parse, by account
+const corruptedBalanceObjectType = "corruptedBalance"
+
+var amountPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// parseAmount parses a decimal string with at most amountScale decimals into minor units
+func parseAmount(amount string) (*big.Int, error) {
+	return parseScaledAmount(amount, amountScale)
+}
+
+// parseScaledAmount parses a decimal string with at most scale decimals into minor units
+func parseScaledAmount(amount string, scale int) (*big.Int, error) {
+	if !amountPattern.MatchString(amount) {
+		return nil, &InvalidAmountError{Amount: amount, Reason: "expecting a decimal number"}
+	}
+	units := amount
+	decimals := ""
+	if i := strings.IndexByte(amount, '.'); i >= 0 {
+		units, decimals = amount[:i], amount[i+1:]
+	}
+	if len(decimals) > scale {
+		return nil, &InvalidAmountError{Amount: amount, Reason: fmt.Sprintf("at most %d decimals are allowed", scale)}
+	}
+	value, _ := new(big.Int).SetString(units+decimals+strings.Repeat("0", scale-len(decimals)), 10)
+	return value, nil
+}
+
+// parsePositiveAmount parses the amount of an operation, which must be greater than zero
+func parsePositiveAmount(amount string) (*big.Int, error) {
+	return defaultAsset.parsePositiveAmount(amount)
+}
+
+// parseBalanceAmount parses an initial balance, which must not be negative
+func parseBalanceAmount(amount string) (*big.Int, error) {
+	value, err := parseAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+	if value.Sign() < 0 {
+		return nil, &InvalidAmountError{Amount: amount, Reason: "must not be negative"}
+	}
+	return value, nil
+}
+
+// formatAmount formats minor units as a decimal string with amountScale decimals
+func formatAmount(value *big.Int) string {
+	return formatScaledAmount(value, amountScale)
+}
+
+// formatScaledAmount formats minor units as a decimal string with scale decimals
+func formatScaledAmount(value *big.Int, scale int) string {
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	quotient, remainder := new(big.Int).QuoRem(new(big.Int).Abs(value), factor, new(big.Int))
+	sign := ""
+	if value.Sign() < 0 {
+		sign = "-"
+	}
+	if scale == 0 {
+		return sign + quotient.String()
+	}
+	return fmt.Sprintf("%s%s.%0*s", sign, quotient.String(), scale, remainder.String())
+}
+
+// getBalance returns the balance of an account in minor units of the default asset
+func getBalance(stub shim.ChaincodeStubInterface, account string) (*big.Int, error) {
+	return getAssetBalance(stub, defaultAsset, account)
+}
+
+func putBalance(stub shim.ChaincodeStubInterface, account string, balance *big.Int) error {
+	return putAssetBalance(stub, defaultAsset, account, balance)
+}
+
+// transferFunds moves amount minor units of an asset from an account to another, the sender also
+// pays the fee, if any, to the treasury. The holds only reserve the default asset. The funds of
+// the released hold are available: the ledger reads do not see the deletion of the hold in the
+// same transaction.
+func transferFunds(stub shim.ChaincodeStubInterface, asset Asset, from, to string, amount *big.Int, fee *transferFee, releasedHold string) error {
+	if from == to {
+		return &SelfTransferError{Account: from}
+	}
+	// balances only change at the latest schema version, the migrations read them in several steps
+	if err := checkLatestSchema(stub); err != nil {
+		return err
+	}
+	fromBalance, err := getAssetBalance(stub, asset, from)
+	if err != nil {
+		return err
+	}
+	toBalance, err := getAssetBalance(stub, asset, to)
+	if err != nil {
+		return err
+	}
+	held := new(big.Int)
+	if asset.Symbol == defaultAsset.Symbol {
+		if held, err = heldAmount(stub, from, releasedHold); err != nil {
+			return err
+		}
+	}
+	total := new(big.Int).Set(amount)
+	if fee != nil {
+		total.Add(total, fee.Amount)
+	}
+	if available := new(big.Int).Sub(fromBalance, held); available.Cmp(total) < 0 {
+		return &InsufficientFundsError{Account: from, Balance: asset.formatAmount(available), Amount: asset.formatAmount(total)}
+	}
+
+	fromBalance.Sub(fromBalance, total)
+	toBalance.Add(toBalance, amount)
+	fmt.Printf("%s = %s, %s = %s %s\n", from, asset.formatAmount(fromBalance), to, asset.formatAmount(toBalance), asset.Symbol)
+
+	if err := putAssetBalance(stub, asset, from, fromBalance); err != nil {
+		return err
+	}
+	event := TransferEvent{Asset: asset.Symbol, From: from, To: to, Value: asset.formatAmount(amount)}
+	if fee != nil {
+		// the balances are computed here, the ledger reads do not see the writes of the transaction
+		if fee.Treasury == to {
+			fee.TreasuryBalance = toBalance.Add(toBalance, fee.Amount)
+		} else {
+			if fee.TreasuryBalance, err = getAssetBalance(stub, asset, fee.Treasury); err != nil {
+				return err
+			}
+			fee.TreasuryBalance.Add(fee.TreasuryBalance, fee.Amount)
+			if err := putAssetBalance(stub, asset, fee.Treasury, fee.TreasuryBalance); err != nil {
+				return err
+			}
+		}
+		event.Fee = asset.formatAmount(fee.Amount)
+		fmt.Printf("fee %s to %s\n", event.Fee, fee.Treasury)
+	}
+	if err := putAssetBalance(stub, asset, to, toBalance); err != nil {
+		return err
+	}
+	if err := recordTransfer(stub, asset, from, fromBalance, to, toBalance, amount, fee); err != nil {
+		return err
+	}
+	return setEvent(stub, transferEvent, event)
+}
+
+// decimalBalancesMigration rewrites the integer balances with amountScale decimals. The balances
+// that do not parse are quarantined, so that they do not stop the migration.
+func decimalBalancesMigration(stub shim.ChaincodeStubInterface, key string, value []byte) error {
+	balance, err := parseAmount(string(value))
+	if err != nil {
+		return quarantineBalance(stub, key, value)
+	}
+	if strings.Contains(string(value), ".") {
+		return nil
+	}
+	return stub.PutState(key, []byte(formatAmount(balance)))
+}
+
+// quarantineBalance moves an unparseable balance to the corrupted balances, where RepairBalance
+// finds it, and leaves the account with a zero balance
+func quarantineBalance(stub shim.ChaincodeStubInterface, account string, value []byte) error {
+	key, err := corruptedBalanceKey(stub, account)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Quarantining the corrupted balance %q of %s\n", string(value), account)
+	if err := stub.PutState(key, value); err != nil {
+		return &InternalError{Reason: "Failed to put state"}
+	}
+	if err := stub.PutState(account, []byte(formatAmount(new(big.Int)))); err != nil {
+		return &InternalError{Reason: "Failed to put state"}
+	}
+	return nil
+}
+
+func corruptedBalanceKey(stub shim.ChaincodeStubInterface, account string) (string, error) {
+	return stub.CreateCompositeKey(corruptedBalanceObjectType, []string{account})
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	assetObjectType   = "asset"
+	balanceObjectType = "balance"
+	// defaultAssetSymbol is the asset of the functions without asset argument and of the balances
+	// written before multiple assets, it is not registered and its minters have the minter role
+	defaultAssetSymbol = "ABS"
+	maxAssetDecimals   = 18
+	// legacyLayoutVersion is the last schema version whose migrations only scan the simple keys,
+	// new default asset balances are written to simple keys until the ledger reaches it
+	legacyLayoutVersion = 3
+)
+
+var (
+	symbolPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,16}$`)
+	defaultAsset  = Asset{Symbol: defaultAssetSymbol, Decimals: amountScale}
+)
+
+// Asset is an asset the accounts hold balances of, with its own scale and issuer
+type Asset struct {
+	Symbol      string `json:"symbol"`
+	Decimals    int    `json:"decimals"`
+	Issuer      string `json:"issuer" metadata:",optional"`      // MSPID:ID identity minting and burning the asset, empty for the default asset
+	TotalSupply string `json:"totalSupply" metadata:",optional"` // set by GetAsset, empty in the registry
+}
+
+// AssetBalance is the balance of an account in an asset
+type AssetBalance struct {
+	Asset   string `json:"asset"`
+	Account string `json:"account"`
+	Balance string `json:"balance"` // decimal with the decimals of the asset
+}
+
+func (a Asset) parseAmount(amount string) (*big.Int, error) {
+	return parseScaledAmount(amount, a.Decimals)
+}
+
+// parsePositiveAmount parses the amount of an operation, which must be greater than zero
+func (a Asset) parsePositiveAmount(amount string) (*big.Int, error) {
+	value, err := a.parseAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+	if value.Sign() <= 0 {
+		return nil, &InvalidAmountError{Amount: amount, Reason: "must be greater than zero"}
+	}
+	return value, nil
+}
+
+func (a Asset) formatAmount(value *big.Int) string {
+	return formatScaledAmount(value, a.Decimals)
+}
+
+// RegisterAsset registers an asset with the number of decimals of its amounts and the MSPID:ID
+// identity of its issuer, who mints and burns it. Only admins can register assets.
+func (t *ABstore) RegisterAsset(ctx contractapi.TransactionContextInterface, symbol string, decimals int, issuer string) error {
+	stub := ctx.GetStub()
+	if !symbolPattern.MatchString(symbol) {
+		return &InvalidArgumentError{Argument: "symbol", Reason: "expecting 1 to 16 letters, digits, _ or -"}
+	}
+	if decimals < 0 || decimals > maxAssetDecimals {
+		return &InvalidArgumentError{Argument: "decimals", Reason: fmt.Sprintf("must be between 0 and %d", maxAssetDecimals)}
+	}
+	issuerIdentity, err := parseIdentity("issuer", issuer)
+	if err != nil {
+		return err
+	}
+	if err := checkRole(stub, adminRole); err != nil {
+		return err
+	}
+	if err := checkLatestSchema(stub); err != nil {
+		return err
+	}
+	if _, err := getAsset(stub, symbol); err == nil {
+		return &AssetExistsError{Symbol: symbol}
+	} else if _, notFound := err.(*AssetNotFoundError); !notFound {
+		return err
+	}
+
+	asset := Asset{Symbol: symbol, Decimals: decimals, Issuer: issuerIdentity.String()}
+	fmt.Printf("Registering asset %s with %d decimals issued by %s\n", symbol, decimals, asset.Issuer)
+	key, err := stub.CreateCompositeKey(assetObjectType, []string{symbol})
+	if err != nil {
+		return err
+	}
+	assetBytes, err := json.Marshal(asset)
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(key, assetBytes); err != nil {
+		return err
+	}
+	return putTotalSupply(stub, asset, new(big.Int))
+}
+
+// GetAsset returns an asset with its total supply
+func (t *ABstore) GetAsset(ctx contractapi.TransactionContextInterface, symbol string) (Asset, error) {
+	stub := ctx.GetStub()
+	asset, err := getAsset(stub, symbol)
+	if err != nil {
+		return asset, err
+	}
+	supply, err := getTotalSupply(stub, asset)
+	if err != nil {
+		return asset, err
+	}
+	if supply == nil {
+		return asset, &MigrationRequiredError{Reason: "the total supply is not tracked yet"}
+	}
+	asset.TotalSupply = asset.formatAmount(supply)
+	return asset, nil
+}
+
+// InvokeAsset makes payment of X units of an asset from A to B, only the owner of A can debit it.
+// A also pays the fee of the fee schedule, in the asset, to the treasury.
+func (t *ABstore) InvokeAsset(ctx contractapi.TransactionContextInterface, asset string, A, B string, X string) error {
+	stub := ctx.GetStub()
+	a, err := getAsset(stub, asset)
+	if err != nil {
+		return err
+	}
+	amount, err := a.parsePositiveAmount(X)
+	if err != nil {
+		return err
+	}
+	if err := checkOwner(stub, A); err != nil {
+		return err
+	}
+	fee, err := paymentFee(stub, a, A, amount)
+	if err != nil {
+		return err
+	}
+	return transferFunds(stub, a, A, B, amount, fee, "")
+}
+
+// QueryAsset returns the balance of an account in an asset
+func (t *ABstore) QueryAsset(ctx contractapi.TransactionContextInterface, asset string, account string) (AssetBalance, error) {
+	stub := ctx.GetStub()
+	a, err := getAsset(stub, asset)
+	if err != nil {
+		return AssetBalance{}, err
+	}
+	balance, err := getAssetBalance(stub, a, account)
+	if err != nil {
+		return AssetBalance{}, err
+	}
+	return AssetBalance{Asset: a.Symbol, Account: account, Balance: a.formatAmount(balance)}, nil
+}
+
+// MintAsset creates amount units of an asset on an account, only the issuer of the asset can mint
+func (t *ABstore) MintAsset(ctx contractapi.TransactionContextInterface, asset string, account string, amount string) error {
+	a, err := getAsset(ctx.GetStub(), asset)
+	if err != nil {
+		return err
+	}
+	return mint(ctx.GetStub(), a, account, amount)
+}
+
+// BurnAsset destroys amount units of an asset, only the issuer of the asset can burn and only from
+// the accounts it owns
+func (t *ABstore) BurnAsset(ctx contractapi.TransactionContextInterface, asset string, account string, amount string) error {
+	a, err := getAsset(ctx.GetStub(), asset)
+	if err != nil {
+		return err
+	}
+	return burn(ctx.GetStub(), a, account, amount)
+}
+
+// getAsset returns a registered asset or the default asset
+func getAsset(stub shim.ChaincodeStubInterface, symbol string) (Asset, error) {
+	if symbol == defaultAssetSymbol {
+		return defaultAsset, nil
+	}
+	key, err := stub.CreateCompositeKey(assetObjectType, []string{symbol})
+	if err != nil {
+		return Asset{}, err
+	}
+	assetBytes, err := stub.GetState(key)
+	if err != nil {
+		return Asset{}, &InternalError{Reason: "Failed to get state"}
+	}
+	if assetBytes == nil {
+		return Asset{}, &AssetNotFoundError{Symbol: symbol}
+	}
+	asset := Asset{}
+	if err := json.Unmarshal(assetBytes, &asset); err != nil {
+		return Asset{}, &CorruptedStateError{Object: "asset " + symbol}
+	}
+	return asset, nil
+}
+
+// registeredAssets returns the registered assets, without the default asset
+func registeredAssets(stub shim.ChaincodeStubInterface) ([]Asset, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(assetObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	assets := make([]Asset, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		asset := Asset{}
+		if err := json.Unmarshal(queryResponse.Value, &asset); err != nil {
+			return nil, &CorruptedStateError{Object: fmt.Sprintf("asset %q", queryResponse.Key)}
+		}
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
+
+// checkIssuer returns an error unless the client can mint and burn the asset
+func checkIssuer(stub shim.ChaincodeStubInterface, asset Asset) error {
+	if asset.Symbol == defaultAssetSymbol {
+		return checkMinter(stub)
+	}
+	client, err := clientOwner(stub)
+	if err != nil {
+		return err
+	}
+	if client.String() != asset.Issuer {
+		return &NotAssetIssuerError{Symbol: asset.Symbol}
+	}
+	return nil
+}
+
+func balanceKey(stub shim.ChaincodeStubInterface, asset Asset, account string) (string, error) {
+	return stub.CreateCompositeKey(balanceObjectType, []string{asset.Symbol, account})
+}
+
+// getAssetBalance returns the balance of an account in minor units of an asset. The accounts
+// exist once they have a balance of the default asset, their other balances default to zero.
+func getAssetBalance(stub shim.ChaincodeStubInterface, asset Asset, account string) (*big.Int, error) {
+	if asset.Symbol != defaultAssetSymbol {
+		if _, err := getBalance(stub, account); err != nil {
+			return nil, err
+		}
+	}
+	key, err := balanceKey(stub, asset, account)
+	if err != nil {
+		return nil, err
+	}
+	balanceBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, &InternalError{Reason: "Failed to get state"}
+	}
+	if balanceBytes == nil && asset.Symbol == defaultAssetSymbol && account != "" {
+		// the balance is not migrated yet
+		if balanceBytes, err = stub.GetState(account); err != nil {
+			return nil, &InternalError{Reason: "Failed to get state"}
+		}
+	}
+	if balanceBytes == nil {
+		if asset.Symbol == defaultAssetSymbol {
+			return nil, &AccountNotFoundError{Account: account}
+		}
+		return new(big.Int), nil
+	}
+	balance, err := asset.parseAmount(string(balanceBytes))
+	if err != nil {
+		return nil, &CorruptedBalanceError{Account: account, Value: string(balanceBytes)}
+	}
+	return balance, nil
+}
+
+func putAssetBalance(stub shim.ChaincodeStubInterface, asset Asset, account string, balance *big.Int) error {
+	key, err := balanceStorageKey(stub, asset, account)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte(asset.formatAmount(balance)))
+}
+
+// balanceStorageKey returns the key a balance is saved to, where it is. The new balances of the
+// default asset are saved to the legacy simple keys until the ledger reaches legacyLayoutVersion.
+func balanceStorageKey(stub shim.ChaincodeStubInterface, asset Asset, account string) (string, error) {
+	key, err := balanceKey(stub, asset, account)
+	if err != nil || asset.Symbol != defaultAssetSymbol {
+		return key, err
+	}
+	legacy, err := stub.GetState(account)
+	if err != nil {
+		return "", &InternalError{Reason: "Failed to get state"}
+	}
+	if legacy != nil {
+		return account, nil
+	}
+	current, err := stub.GetState(key)
+	if err != nil {
+		return "", &InternalError{Reason: "Failed to get state"}
+	}
+	if current != nil {
+		return key, nil
+	}
+	version, err := loadSchemaVersion(stub)
+	if err != nil {
+		return "", err
+	}
+	if version < legacyLayoutVersion {
+		return account, nil
+	}
+	return key, nil
+}
+
+// delBalances deletes the balances of an account in all the assets
+func delBalances(stub shim.ChaincodeStubInterface, account string) error {
+	if err := stub.DelState(account); err != nil {
+		return &InternalError{Reason: "Failed to delete state"}
+	}
+	assets, err := registeredAssets(stub)
+	if err != nil {
+		return err
+	}
+	for _, asset := range append(assets, defaultAsset) {
+		key, err := balanceKey(stub, asset, account)
+		if err != nil {
+			return err
+		}
+		if err := stub.DelState(key); err != nil {
+			return &InternalError{Reason: "Failed to delete state"}
+		}
+	}
+	return nil
+}
+
+// checkLatestSchema returns an error until the ledger is migrated to the latest schema version
+func checkLatestSchema(stub shim.ChaincodeStubInterface) error {
+	version, err := loadSchemaVersion(stub)
+	if err != nil {
+		return err
+	}
+	if version < latestSchemaVersion() {
+		return &MigrationRequiredError{Reason: fmt.Sprintf("the ledger schema version %d is older than %d", version, latestSchemaVersion())}
+	}
+	return nil
+}
+
+// assetBalancesMigration moves the balances from the simple keys to the default asset balances
+func assetBalancesMigration(stub shim.ChaincodeStubInterface, resumeKey string, limit int) (string, int, error) {
+	startKey := resumeKey
+	if startKey == "" {
+		// skip the composite keys, which start with 0x00
+		startKey = "\x01"
+	}
+	resultsIterator, err := stub.GetStateByRange(startKey, string(utf8.MaxRune))
+	if err != nil {
+		return "", 0, err
+	}
+	defer resultsIterator.Close()
+
+	processed := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return "", processed, err
+		}
+		if processed == limit {
+			return queryResponse.Key, processed, nil
+		}
+		key, err := balanceKey(stub, defaultAsset, queryResponse.Key)
+		if err != nil {
+			return "", processed, err
+		}
+		if err := stub.PutState(key, queryResponse.Value); err != nil {
+			return "", processed, err
+		}
+		if err := stub.DelState(queryResponse.Key); err != nil {
+			return "", processed, err
+		}
+		processed++
+	}
+	return "", processed, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func checkAssetBalance(t *testing.T, stub *shimtest.MockStub, asset string, account string, balance string) {
+	result := invoke(stub, "QueryAsset", asset, account)
+	assert.EqualValues(t, shim.OK, result.Status, "QueryAsset failed: "+result.Message)
+	var b AssetBalance
+	json.Unmarshal(result.Payload, &b)
+	assert.Equal(t, balance, b.Balance, "%s balance of %s", asset, account)
+}
+
+func getAssetSupply(t *testing.T, stub *shimtest.MockStub, symbol string) string {
+	result := invoke(stub, "GetAsset", symbol)
+	assert.EqualValues(t, shim.OK, result.Status, "GetAsset failed: "+result.Message)
+	var asset Asset
+	json.Unmarshal(result.Payload, &asset)
+	return asset.TotalSupply
+}
+
+func TestRegisterAsset(t *testing.T) {
+	alice, _, admin := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+
+	result := invokeAs(stub, admin, "RegisterAsset", "GOLD", "3", "Org1MSP:bob")
+	assert.EqualValues(t, shim.OK, result.Status, "RegisterAsset failed: "+result.Message)
+	result = invoke(stub, "GetAsset", "GOLD")
+	assert.JSONEq(t, `{"symbol":"GOLD","decimals":3,"issuer":"Org1MSP:bob","totalSupply":"0.000"}`, string(result.Payload))
+	result = invoke(stub, "GetAsset", "ABS")
+	assert.JSONEq(t, `{"symbol":"ABS","decimals":2,"issuer":"","totalSupply":"100.00"}`, string(result.Payload))
+
+	for _, test := range []struct {
+		identity []byte
+		args     []string
+		code     ErrorCode
+	}{
+		{alice, []string{"SILVER", "2", "Org1MSP:bob"}, CodeMissingRole},
+		{admin, []string{"GOLD", "2", "Org1MSP:bob"}, CodeAssetExists},
+		{admin, []string{"ABS", "2", "Org1MSP:bob"}, CodeAssetExists},
+		{admin, []string{"SIL VER", "2", "Org1MSP:bob"}, CodeInvalidArgument},
+		{admin, []string{"SILVER", "19", "Org1MSP:bob"}, CodeInvalidArgument},
+		{admin, []string{"SILVER", "2", "bob"}, CodeInvalidArgument},
+	} {
+		result = invokeAs(stub, test.identity, "RegisterAsset", test.args...)
+		assert.EqualValues(t, shim.ERROR, result.Status, "RegisterAsset %v should fail", test.args)
+		assert.Contains(t, result.Message, string(test.code), "RegisterAsset %v", test.args)
+	}
+
+	result = invoke(stub, "QueryAsset", "SILVER", "a")
+	assert.EqualValues(t, shim.ERROR, result.Status)
+	assert.Equal(t, "ASSET_NOT_FOUND: Asset not found: SILVER", result.Message)
+}
+
+func TestAssetBalances(t *testing.T) {
+	alice, bob, admin := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	invokeAs(stub, admin, "RegisterAsset", "GOLD", "3", "Org1MSP:bob")
+	checkAssetBalance(t, stub, "GOLD", "a", "0.000")
+
+	// only the issuer mints an asset, the minter role is for the default asset
+	for _, identity := range [][]byte{alice, minter(t)} {
+		result := invokeAs(stub, identity, "MintAsset", "GOLD", "a", "1")
+		assert.EqualValues(t, shim.ERROR, result.Status, "only the issuer should mint")
+		assert.Contains(t, result.Message, string(CodeNotAssetIssuer))
+	}
+	result := invokeAs(stub, bob, "MintAsset", "GOLD", "a", "1.5")
+	assert.EqualValues(t, shim.OK, result.Status, "MintAsset failed: "+result.Message)
+	result = invokeAs(stub, bob, "MintAsset", "GOLD", "c", "1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "assets are minted on existing accounts")
+	assert.Contains(t, result.Message, string(CodeAccountNotFound))
+
+	result = invokeAs(stub, alice, "InvokeAsset", "GOLD", "a", "b", "0.25")
+	assert.EqualValues(t, shim.OK, result.Status, "InvokeAsset failed: "+result.Message)
+	checkAssetBalance(t, stub, "GOLD", "a", "1.250")
+	checkAssetBalance(t, stub, "GOLD", "b", "0.250")
+	checkAssetBalance(t, stub, "ABS", "a", "100.00")
+	checkBalance(t, stub, "a", "100.00")
+	event := lastEvent(stub)
+	assert.JSONEq(t, `{"asset":"GOLD","from":"a","to":"b","value":"0.250"}`, string(event.Payload))
+
+	for _, args := range [][]string{
+		{"GOLD", "a", "b", "0.0001"},
+		{"GOLD", "a", "b", "1.251"},
+		{"SILVER", "a", "b", "1"},
+	} {
+		result = invokeAs(stub, alice, "InvokeAsset", args...)
+		assert.EqualValues(t, shim.ERROR, result.Status, "InvokeAsset %v should fail", args)
+	}
+
+	statement := getTransfers(t, stub, "a", "", "", "10", "")
+	assert.Equal(t, [][3]string{{"", "100.00", "100.00"}}, lines(statement), "the default statement has the default asset only")
+	result = invoke(stub, "GetAssetTransfers", "GOLD", "a", "", "", "10", "")
+	assert.EqualValues(t, shim.OK, result.Status, "GetAssetTransfers failed: "+result.Message)
+	json.Unmarshal(result.Payload, &statement)
+	assert.Equal(t, [][3]string{{"", "1.500", "1.500"}, {"b", "-0.250", "1.250"}}, lines(statement))
+
+	// the issuer burns from its own accounts
+	result = invokeAs(stub, bob, "BurnAsset", "GOLD", "b", "0.25")
+	assert.EqualValues(t, shim.ERROR, result.Status, "the issuer should only burn its own accounts")
+	invokeAs(stub, bob, "CreateAccount", "vault")
+	invokeAs(stub, alice, "InvokeAsset", "GOLD", "a", "vault", "1")
+	result = invokeAs(stub, bob, "BurnAsset", "GOLD", "vault", "1")
+	assert.EqualValues(t, shim.OK, result.Status, "BurnAsset failed: "+result.Message)
+	assert.Equal(t, "0.500", getAssetSupply(t, stub, "GOLD"))
+	assert.Equal(t, "100.00", getAssetSupply(t, stub, "ABS"))
+
+	// accounts holding any asset cannot be deleted
+	result = invokeAs(stub, alice, "Delete", "b")
+	assert.EqualValues(t, shim.ERROR, result.Status, "accounts with an asset balance should not be deleted")
+	assert.Contains(t, result.Message, "0.250 GOLD")
+	result = invokeAs(stub, bob, "Delete", "vault")
+	assert.EqualValues(t, shim.OK, result.Status, "Delete failed: "+result.Message)
+}
+
+func TestAssetBalancesMigration(t *testing.T) {
+	alice, _, admin := identities(t)
+	stub := newABstoreStub(t)
+	stub.MockTransactionStart("1")
+	stub.PutState("a", []byte("100"))
+	stub.PutState("b", []byte("20.50"))
+	owner, _ := clientOwner(stub)
+	for _, account := range []string{"a", "b"} {
+		putAccountOwner(stub, account, owner)
+	}
+	stub.MockTransactionEnd("1")
+
+	// legacy ledgers create accounts in the legacy layout and refuse transfers until migrated
+	invokeAs(stub, alice, "CreateAccount", "c")
+	c, _ := stub.GetState("c")
+	assert.Equal(t, "0.00", string(c))
+	result := invokeAs(stub, alice, "Invoke", "a", "c", "10")
+	assert.EqualValues(t, shim.ERROR, result.Status)
+	assert.Contains(t, result.Message, string(CodeMigrationRequired))
+	result = invoke(stub, "GetAllAccounts", "10", "")
+	assert.EqualValues(t, shim.ERROR, result.Status, "legacy ledgers cannot be listed")
+	assert.Contains(t, result.Message, string(CodeMigrationRequired))
+
+	// migrate until the asset balances step is half way, accounts are still created where the
+	// migration finds them and transfers are still refused
+	var migrationResult MigrationResult
+	for migrationResult.Version < 3 || migrationResult.ResumeKey == "" {
+		result = invokeAs(stub, admin, "Migrate", "1", org1MSPs, org1MSPs)
+		assert.EqualValues(t, shim.OK, result.Status, "Migrate failed: "+result.Message)
+		json.Unmarshal(result.Payload, &migrationResult)
+	}
+	assert.Equal(t, "b", migrationResult.ResumeKey)
+	result = invokeAs(stub, alice, "Invoke", "a", "b", "5")
+	assert.EqualValues(t, shim.ERROR, result.Status)
+	assert.Contains(t, result.Message, string(CodeMigrationRequired))
+	invokeAs(stub, alice, "CreateAccount", "aa")
+
+	for !migrationResult.Done {
+		result = invokeAs(stub, admin, "Migrate", "1", org1MSPs, org1MSPs)
+		assert.EqualValues(t, shim.OK, result.Status, "Migrate failed: "+result.Message)
+		json.Unmarshal(result.Payload, &migrationResult)
+	}
+	for _, account := range []string{"a", "aa", "b", "c"} {
+		legacy, _ := stub.GetState(account)
+		assert.Nil(t, legacy, "%s should be migrated", account)
+	}
+	checkAssetBalance(t, stub, "ABS", "a", "100.00")
+	checkAssetBalance(t, stub, "ABS", "aa", "0.00")
+	checkAssetBalance(t, stub, "ABS", "b", "20.50")
+	checkAssetBalance(t, stub, "ABS", "c", "0.00")
+
+	page := getAllAccounts(t, stub, 10, "")
+	assert.Len(t, page.Accounts, 4)
+	invariants := checkInvariants(t, stub)
+	assert.True(t, invariants.Consistent)
+	assert.Equal(t, "120.50", invariants.TotalSupply)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorCode identifies the kind of an ABstore error. The contract API only returns the error
+// message to the client, so the messages start with the code, e.g. "INSUFFICIENT_FUNDS: ...".
+type ErrorCode string
+
+const (
+	CodeInvalidArgument       ErrorCode = "INVALID_ARGUMENT"
+	CodeInvalidAmount         ErrorCode = "INVALID_AMOUNT"
+	CodeAccountNotFound       ErrorCode = "ACCOUNT_NOT_FOUND"
+	CodeAccountExists         ErrorCode = "ACCOUNT_EXISTS"
+	CodeCorruptedBalance      ErrorCode = "CORRUPTED_BALANCE"
+	CodeInsufficientFunds     ErrorCode = "INSUFFICIENT_FUNDS"
+	CodeSelfTransfer          ErrorCode = "SELF_TRANSFER"
+	CodeNotAccountOwner       ErrorCode = "NOT_ACCOUNT_OWNER"
+	CodeInsufficientAllowance ErrorCode = "INSUFFICIENT_ALLOWANCE"
+	CodeMissingRole           ErrorCode = "MISSING_ROLE"
+	CodeAlreadyInitialized    ErrorCode = "ALREADY_INITIALIZED"
+	CodeMigrationRequired     ErrorCode = "MIGRATION_REQUIRED"
+	CodeAccountNotEmpty       ErrorCode = "ACCOUNT_NOT_EMPTY"
+	CodeHoldNotFound          ErrorCode = "HOLD_NOT_FOUND"
+	CodeHoldExpired           ErrorCode = "HOLD_EXPIRED"
+	CodeHoldsPending          ErrorCode = "HOLDS_PENDING"
+	CodeHoldNotExpired        ErrorCode = "HOLD_NOT_EXPIRED"
+	CodeInvalidPreimage       ErrorCode = "INVALID_PREIMAGE"
+	CodeAssetNotFound         ErrorCode = "ASSET_NOT_FOUND"
+	CodeAssetExists           ErrorCode = "ASSET_EXISTS"
+	CodeNotAssetIssuer        ErrorCode = "NOT_ASSET_ISSUER"
+	CodeCorruptedState        ErrorCode = "CORRUPTED_STATE"
+	CodeInternal              ErrorCode = "INTERNAL"
+)
+
+// CodedError is implemented by the ABstore errors
+type CodedError interface {
+	error
+	Code() ErrorCode
+}
+
+func codedMessage(code ErrorCode, format string, args ...interface{}) string {
+	return string(code) + ": " + fmt.Sprintf(format, args...)
+}
+
+// InvalidArgumentError is returned for a malformed transaction argument
+type InvalidArgumentError struct {
+	Argument string
+	Reason   string
+}
+
+func (e *InvalidArgumentError) Code() ErrorCode { return CodeInvalidArgument }
+
+func (e *InvalidArgumentError) Error() string {
+	return codedMessage(e.Code(), "Invalid %s: %s", e.Argument, e.Reason)
+}
+
+// InvalidAmountError is returned for an amount that is malformed or out of range
+type InvalidAmountError struct {
+	Amount string
+	Reason string
+}
+
+func (e *InvalidAmountError) Code() ErrorCode { return CodeInvalidAmount }
+
+func (e *InvalidAmountError) Error() string {
+	return codedMessage(e.Code(), "Invalid amount %q: %s", e.Amount, e.Reason)
+}
+
+// AccountNotFoundError is returned for an account without balance
+type AccountNotFoundError struct {
+	Account string
+}
+
+func (e *AccountNotFoundError) Code() ErrorCode { return CodeAccountNotFound }
+
+func (e *AccountNotFoundError) Error() string {
+	return codedMessage(e.Code(), "Entity not found: %s", e.Account)
+}
+
+// AccountExistsError is returned when creating an account that already exists
+type AccountExistsError struct {
+	Account string
+}
+
+func (e *AccountExistsError) Code() ErrorCode { return CodeAccountExists }
+
+func (e *AccountExistsError) Error() string {
+	return codedMessage(e.Code(), "Account %s already exists", e.Account)
+}
+
+// CorruptedBalanceError is returned when the stored balance of an account cannot be parsed
+type CorruptedBalanceError struct {
+	Account string
+	Value   string
+}
+
+func (e *CorruptedBalanceError) Code() ErrorCode { return CodeCorruptedBalance }
+
+func (e *CorruptedBalanceError) Error() string {
+	return codedMessage(e.Code(), "Corrupted balance %q for %s", e.Value, e.Account)
+}
+
+// InsufficientFundsError is returned when a debit exceeds the available balance of an account
+type InsufficientFundsError struct {
+	Account string
+	Balance string // balance minus the active holds
+	Amount  string
+}
+
+func (e *InsufficientFundsError) Code() ErrorCode { return CodeInsufficientFunds }
+
+func (e *InsufficientFundsError) Error() string {
+	return codedMessage(e.Code(), "Insufficient funds in %s: balance %s, amount %s", e.Account, e.Balance, e.Amount)
+}
+
+// SelfTransferError is returned for a transfer from an account to itself
+type SelfTransferError struct {
+	Account string
+}
+
+func (e *SelfTransferError) Code() ErrorCode { return CodeSelfTransfer }
+
+func (e *SelfTransferError) Error() string {
+	return codedMessage(e.Code(), "Cannot transfer from %s to itself", e.Account)
+}
+
+// NotAccountOwnerError is returned when the client is not allowed to act on an account
+type NotAccountOwnerError struct {
+	Account string
+}
+
+func (e *NotAccountOwnerError) Code() ErrorCode { return CodeNotAccountOwner }
+
+func (e *NotAccountOwnerError) Error() string {
+	return codedMessage(e.Code(), "The client identity is not the owner of %s", e.Account)
+}
+
+// InsufficientAllowanceError is returned when a TransferFrom exceeds the allowance of the spender
+type InsufficientAllowanceError struct {
+	Owner     string
+	Spender   string
+	Allowance string
+	Amount    string
+}
+
+func (e *InsufficientAllowanceError) Code() ErrorCode { return CodeInsufficientAllowance }
+
+func (e *InsufficientAllowanceError) Error() string {
+	return codedMessage(e.Code(), "Insufficient allowance of %s on %s: allowance %s, amount %s", e.Spender, e.Owner, e.Allowance, e.Amount)
+}
+
+// MissingRoleError is returned when the client lacks the role required by a transaction
+type MissingRoleError struct {
+	Role string
+}
+
+func (e *MissingRoleError) Code() ErrorCode { return CodeMissingRole }
+
+func (e *MissingRoleError) Error() string {
+	return codedMessage(e.Code(), "The client identity does not have the %s role", e.Role)
+}
+
+// AlreadyInitializedError is returned by Init on a ledger that already holds accounts
+type AlreadyInitializedError struct{}
+
+func (e *AlreadyInitializedError) Code() ErrorCode { return CodeAlreadyInitialized }
+
+func (e *AlreadyInitializedError) Error() string {
+	return codedMessage(e.Code(), "The ledger is already initialized")
+}
+
+// MigrationRequiredError is returned when a transaction needs the ledger at the latest schema version
+type MigrationRequiredError struct {
+	Reason string
+}
+
+func (e *MigrationRequiredError) Code() ErrorCode { return CodeMigrationRequired }
+
+func (e *MigrationRequiredError) Error() string {
+	return codedMessage(e.Code(), "Call Migrate first, %s", e.Reason)
+}
+
+// AccountNotEmptyError is returned when deleting an account that still has a balance
+type AccountNotEmptyError struct {
+	Account string
+	Balance string
+}
+
+func (e *AccountNotEmptyError) Code() ErrorCode { return CodeAccountNotEmpty }
+
+func (e *AccountNotEmptyError) Error() string {
+	return codedMessage(e.Code(), "Account %s still has a balance of %s", e.Account, e.Balance)
+}
+
+// HoldNotFoundError is returned for a hold that does not exist or was completed
+type HoldNotFoundError struct {
+	HoldID string
+}
+
+func (e *HoldNotFoundError) Code() ErrorCode { return CodeHoldNotFound }
+
+func (e *HoldNotFoundError) Error() string {
+	return codedMessage(e.Code(), "Hold not found: %s", e.HoldID)
+}
+
+// HoldExpiredError is returned when releasing a hold after its expiry
+type HoldExpiredError struct {
+	HoldID    string
+	ExpiresAt string
+}
+
+func (e *HoldExpiredError) Code() ErrorCode { return CodeHoldExpired }
+
+func (e *HoldExpiredError) Error() string {
+	return codedMessage(e.Code(), "Hold %s expired at %s", e.HoldID, e.ExpiresAt)
+}
+
+// HoldsPendingError is returned when deleting an account that is the payer or payee of a hold
+type HoldsPendingError struct {
+	Account string
+}
+
+func (e *HoldsPendingError) Code() ErrorCode { return CodeHoldsPending }
+
+func (e *HoldsPendingError) Error() string {
+	return codedMessage(e.Code(), "Account %s has pending holds, release, cancel or expire them first", e.Account)
+}
+
+// HoldNotExpiredError is returned when refunding a hashed time-locked transfer before its timeout
+type HoldNotExpiredError struct {
+	HoldID    string
+	ExpiresAt string
+}
+
+func (e *HoldNotExpiredError) Code() ErrorCode { return CodeHoldNotExpired }
+
+func (e *HoldNotExpiredError) Error() string {
+	return codedMessage(e.Code(), "Hold %s expires at %s", e.HoldID, e.ExpiresAt)
+}
+
+// InvalidPreimageError is returned when the preimage does not match the hashlock of a transfer
+type InvalidPreimageError struct {
+	ID string
+}
+
+func (e *InvalidPreimageError) Code() ErrorCode { return CodeInvalidPreimage }
+
+func (e *InvalidPreimageError) Error() string {
+	return codedMessage(e.Code(), "The preimage does not match the hashlock of %s", e.ID)
+}
+
+// AssetNotFoundError is returned for an asset that is not registered
+type AssetNotFoundError struct {
+	Symbol string
+}
+
+func (e *AssetNotFoundError) Code() ErrorCode { return CodeAssetNotFound }
+
+func (e *AssetNotFoundError) Error() string {
+	return codedMessage(e.Code(), "Asset not found: %s", e.Symbol)
+}
+
+// AssetExistsError is returned when registering an asset that already exists
+type AssetExistsError struct {
+	Symbol string
+}
+
+func (e *AssetExistsError) Code() ErrorCode { return CodeAssetExists }
+
+func (e *AssetExistsError) Error() string {
+	return codedMessage(e.Code(), "Asset %s already exists", e.Symbol)
+}
+
+// NotAssetIssuerError is returned when the client mints or burns an asset it does not issue
+type NotAssetIssuerError struct {
+	Symbol string
+}
+
+func (e *NotAssetIssuerError) Code() ErrorCode { return CodeNotAssetIssuer }
+
+func (e *NotAssetIssuerError) Error() string {
+	return codedMessage(e.Code(), "The client identity is not the issuer of %s", e.Symbol)
+}
+
+// CorruptedStateError is returned when a stored value other than a balance cannot be parsed
+type CorruptedStateError struct {
+	Object string // the value and what it belongs to, e.g. "fee schedule"
+}
+
+func (e *CorruptedStateError) Code() ErrorCode { return CodeCorruptedState }
+
+func (e *CorruptedStateError) Error() string {
+	return codedMessage(e.Code(), "Malformed %s", e.Object)
+}
+
+// InternalError is returned when the peer fails to read or write the state
+type InternalError struct {
+	Reason string
+}
+
+func (e *InternalError) Code() ErrorCode { return CodeInternal }
+
+func (e *InternalError) Error() string {
+	return codedMessage(e.Code(), "%s", e.Reason)
+}
+
+// MigrationFailedError is returned when a migration step fails, with the code of the step error
+// or INTERNAL when the step error has none
+type MigrationFailedError struct {
+	Version int
+	Err     error
+}
+
+func (e *MigrationFailedError) Code() ErrorCode {
+	if coded, ok := e.Err.(CodedError); ok {
+		return coded.Code()
+	}
+	return CodeInternal
+}
+
+func (e *MigrationFailedError) Error() string {
+	reason := strings.TrimPrefix(e.Err.Error(), string(e.Code())+": ")
+	return codedMessage(e.Code(), "Migration to schema version %d failed: %s", e.Version, reason)
+}
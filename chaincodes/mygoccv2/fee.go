@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	feeScheduleObjectType = "feeSchedule"
+	maxBasisPoints        = 10000
+)
+
+// FeeSchedule is the fee charged on the payments in an asset made with Invoke, InvokeAsset,
+// TransferFrom and the release of a hold: a flat fee plus basis points of the amount, rounded down
+// to the minor unit, within min and max. Each asset has its own schedule, with amounts in the
+// decimals of the asset, and the payments in an asset without schedule pay no fee.
+type FeeSchedule struct {
+	Asset       string `json:"asset"`
+	Flat        string `json:"flat"`
+	BasisPoints int    `json:"basisPoints"`
+	Min         string `json:"min"`
+	Max         string `json:"max" metadata:",optional"`      // empty for no cap
+	Treasury    string `json:"treasury" metadata:",optional"` // account credited with the fees, empty without fee schedule
+}
+
+// transferFee is a fee charged on a transfer and the treasury balance after it
+type transferFee struct {
+	Amount          *big.Int
+	Treasury        string
+	TreasuryBalance *big.Int
+}
+
+// SetFeeSchedule sets the fee charged on the payments in an asset and the treasury account
+// receiving the fees, in amounts with the decimals of the asset. A max of "" leaves the fee
+// uncapped. Only admins can set the fee schedules, a zero fee disables it and needs no treasury.
+func (t *ABstore) SetFeeSchedule(ctx contractapi.TransactionContextInterface, asset string, flat string, basisPoints int, min string, max string, treasury string) error {
+	stub := ctx.GetStub()
+	a, err := getAsset(stub, asset)
+	if err != nil {
+		return err
+	}
+	schedule := FeeSchedule{Asset: a.Symbol, BasisPoints: basisPoints, Treasury: treasury}
+	flatValue, err := parseFeeAmount(a, flat)
+	if err != nil {
+		return err
+	}
+	if basisPoints < 0 || basisPoints > maxBasisPoints {
+		return &InvalidArgumentError{Argument: "basisPoints", Reason: fmt.Sprintf("must be between 0 and %d", maxBasisPoints)}
+	}
+	minValue, err := parseFeeAmount(a, min)
+	if err != nil {
+		return err
+	}
+	schedule.Flat, schedule.Min = a.formatAmount(flatValue), a.formatAmount(minValue)
+	if max != "" {
+		maxValue, err := parseFeeAmount(a, max)
+		if err != nil {
+			return err
+		}
+		if maxValue.Cmp(minValue) < 0 {
+			return &InvalidArgumentError{Argument: "max", Reason: "must not be lower than min"}
+		}
+		schedule.Max = a.formatAmount(maxValue)
+	}
+	if err := checkRole(stub, adminRole); err != nil {
+		return err
+	}
+	if flatValue.Sign() != 0 || basisPoints != 0 || minValue.Sign() != 0 || treasury != "" {
+		if _, err := getBalance(stub, treasury); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Fee schedule of %s: %s + %d bp, min %s, max %s, treasury %s\n", a.Symbol, schedule.Flat, basisPoints, schedule.Min, schedule.Max, treasury)
+	key, err := feeScheduleKey(stub, a)
+	if err != nil {
+		return err
+	}
+	scheduleBytes, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, scheduleBytes)
+}
+
+// GetFeeSchedule returns the fee schedule of an asset, with zero fees when none was set
+func (t *ABstore) GetFeeSchedule(ctx contractapi.TransactionContextInterface, asset string) (FeeSchedule, error) {
+	a, err := getAsset(ctx.GetStub(), asset)
+	if err != nil {
+		return FeeSchedule{}, err
+	}
+	schedule, err := getFeeSchedule(ctx.GetStub(), a)
+	if err != nil {
+		return FeeSchedule{}, err
+	}
+	if schedule == nil {
+		return FeeSchedule{Asset: a.Symbol, Flat: a.formatAmount(new(big.Int)), Min: a.formatAmount(new(big.Int))}, nil
+	}
+	return *schedule, nil
+}
+
+// parseFeeAmount parses an amount of the fee schedule of an asset, which must not be negative
+func parseFeeAmount(asset Asset, amount string) (*big.Int, error) {
+	value, err := asset.parseAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+	if value.Sign() < 0 {
+		return nil, &InvalidAmountError{Amount: amount, Reason: "must not be negative"}
+	}
+	return value, nil
+}
+
+func feeScheduleKey(stub shim.ChaincodeStubInterface, asset Asset) (string, error) {
+	return stub.CreateCompositeKey(feeScheduleObjectType, []string{asset.Symbol})
+}
+
+// getFeeSchedule returns the fee schedule of an asset, nil when none was set
+func getFeeSchedule(stub shim.ChaincodeStubInterface, asset Asset) (*FeeSchedule, error) {
+	key, err := feeScheduleKey(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+	scheduleBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, &InternalError{Reason: "Failed to get state"}
+	}
+	if scheduleBytes == nil {
+		return nil, nil
+	}
+	schedule := &FeeSchedule{}
+	if err := json.Unmarshal(scheduleBytes, schedule); err != nil {
+		return nil, &CorruptedStateError{Object: "fee schedule of " + asset.Symbol}
+	}
+	return schedule, nil
+}
+
+// paymentFee returns the fee of a payment from an account in minor units of the asset paid, nil
+// without fee. The treasury pays no fee.
+func paymentFee(stub shim.ChaincodeStubInterface, asset Asset, from string, amount *big.Int) (*transferFee, error) {
+	schedule, err := getFeeSchedule(stub, asset)
+	if err != nil || schedule == nil || schedule.Treasury == from {
+		return nil, err
+	}
+	fee, err := schedule.fee(asset, amount)
+	if err != nil || fee.Sign() == 0 {
+		return nil, err
+	}
+	return &transferFee{Amount: fee, Treasury: schedule.Treasury}, nil
+}
+
+// reservedFee returns the fee a hold reserved in an asset, paid to the current treasury of the
+// asset, nil once its fee schedule has no treasury or when the payer is the treasury
+func reservedFee(stub shim.ChaincodeStubInterface, asset Asset, from string, amount string) (*transferFee, error) {
+	schedule, err := getFeeSchedule(stub, asset)
+	if err != nil || schedule == nil || schedule.Treasury == "" || schedule.Treasury == from {
+		return nil, err
+	}
+	fee, err := asset.parseAmount(amount)
+	if err != nil {
+		return nil, &CorruptedStateError{Object: fmt.Sprintf("reserved fee %q", amount)}
+	}
+	return &transferFee{Amount: fee, Treasury: schedule.Treasury}, nil
+}
+
+// fee returns the fee charged on amount minor units of the asset of the schedule
+func (s FeeSchedule) fee(asset Asset, amount *big.Int) (*big.Int, error) {
+	flat, err := asset.parseAmount(s.Flat)
+	if err != nil {
+		return nil, &CorruptedStateError{Object: "fee schedule of " + asset.Symbol}
+	}
+	min, err := asset.parseAmount(s.Min)
+	if err != nil {
+		return nil, &CorruptedStateError{Object: "fee schedule of " + asset.Symbol}
+	}
+	fee := new(big.Int).Mul(amount, big.NewInt(int64(s.BasisPoints)))
+	fee.Quo(fee, big.NewInt(maxBasisPoints))
+	fee.Add(fee, flat)
+	if fee.Cmp(min) < 0 {
+		fee.Set(min)
+	}
+	if s.Max != "" {
+		max, err := asset.parseAmount(s.Max)
+		if err != nil {
+			return nil, &CorruptedStateError{Object: "fee schedule of " + asset.Symbol}
+		}
+		if fee.Cmp(max) > 0 {
+			fee.Set(max)
+		}
+	}
+	return fee, nil
+}
+
+// isTreasury returns whether an account receives the fees of an asset
+func isTreasury(stub shim.ChaincodeStubInterface, account string) (bool, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(feeScheduleObjectType, []string{})
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return false, err
+		}
+		schedule := FeeSchedule{}
+		if err := json.Unmarshal(queryResponse.Value, &schedule); err != nil {
+			return false, &CorruptedStateError{Object: fmt.Sprintf("fee schedule %q", queryResponse.Key)}
+		}
+		if schedule.Treasury == account {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func querySchedule(t *testing.T, stub *shimtest.MockStub, asset string) FeeSchedule {
+	result := invoke(stub, "GetFeeSchedule", asset)
+	assert.EqualValues(t, shim.OK, result.Status, "GetFeeSchedule failed: "+result.Message)
+	var schedule FeeSchedule
+	json.Unmarshal(result.Payload, &schedule)
+	return schedule
+}
+
+func TestFee(t *testing.T) {
+	for _, test := range []struct {
+		schedule FeeSchedule
+		amount   int64
+		fee      int64
+	}{
+		{FeeSchedule{Flat: "0.00", BasisPoints: 0, Min: "0.00"}, 10000, 0},
+		{FeeSchedule{Flat: "0.50", BasisPoints: 0, Min: "0.00"}, 10000, 50},
+		{FeeSchedule{Flat: "0.00", BasisPoints: 25, Min: "0.00"}, 10000, 25},
+		{FeeSchedule{Flat: "0.00", BasisPoints: 25, Min: "0.00"}, 399, 0}, // rounded down
+		{FeeSchedule{Flat: "0.10", BasisPoints: 100, Min: "0.00"}, 10000, 110},
+		{FeeSchedule{Flat: "0.00", BasisPoints: 100, Min: "0.05"}, 100, 5},
+		{FeeSchedule{Flat: "0.00", BasisPoints: 100, Min: "0.05", Max: "2.00"}, 1000000, 200},
+		{FeeSchedule{Flat: "0.00", BasisPoints: 10000, Min: "0.00"}, 1234, 1234},
+	} {
+		fee, err := test.schedule.fee(defaultAsset, big.NewInt(test.amount))
+		assert.Nil(t, err)
+		assert.EqualValues(t, test.fee, fee.Int64(), "fee of %d with %+v", test.amount, test.schedule)
+	}
+
+	// the amounts of the schedule are in the decimals of its asset
+	gold := Asset{Symbol: "GOLD", Decimals: 3}
+	schedule := FeeSchedule{Asset: "GOLD", Flat: "0.100", BasisPoints: 100, Min: "0.000", Max: "0.150"}
+	fee, _ := schedule.fee(gold, big.NewInt(1000))
+	assert.EqualValues(t, 110, fee.Int64())
+	fee, _ = schedule.fee(gold, big.NewInt(100000))
+	assert.EqualValues(t, 150, fee.Int64())
+	schedule = FeeSchedule{Asset: "BAR", Flat: "1", BasisPoints: 0, Min: "0"}
+	fee, _ = schedule.fee(Asset{Symbol: "BAR", Decimals: 0}, big.NewInt(1000))
+	assert.EqualValues(t, 1, fee.Int64())
+	_, err := FeeSchedule{Asset: "BAR", Flat: "0.5", Min: "0"}.fee(Asset{Symbol: "BAR", Decimals: 0}, big.NewInt(1000))
+	assert.IsType(t, &CorruptedStateError{}, err)
+}
+
+func TestSetFeeSchedule(t *testing.T) {
+	alice, _, admin := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	invokeAs(stub, admin, "CreateAccount", "treasury")
+	assert.Equal(t, FeeSchedule{Asset: "ABS", Flat: "0.00", Min: "0.00"}, querySchedule(t, stub, "ABS"))
+
+	result := invokeAs(stub, alice, "SetFeeSchedule", "ABS", "1", "10", "0", "", "treasury")
+	assert.EqualValues(t, shim.ERROR, result.Status, "only admins should set the fee schedule")
+	assert.Contains(t, result.Message, string(CodeMissingRole))
+
+	result = invokeAs(stub, admin, "SetFeeSchedule", "ABS", "0.1", "25", "0.2", "5", "treasury")
+	assert.EqualValues(t, shim.OK, result.Status, "SetFeeSchedule failed: "+result.Message)
+	assert.Equal(t, FeeSchedule{Asset: "ABS", Flat: "0.10", BasisPoints: 25, Min: "0.20", Max: "5.00", Treasury: "treasury"}, querySchedule(t, stub, "ABS"))
+
+	for _, args := range [][]string{
+		{"ABS", "-1", "0", "0", "", "treasury"},
+		{"ABS", "0", "-1", "0", "", "treasury"},
+		{"ABS", "0", "10001", "0", "", "treasury"},
+		{"ABS", "0", "10", "x", "", "treasury"},
+		{"ABS", "0", "10", "2", "1", "treasury"},
+		{"ABS", "0", "10", "0", "", "c"},
+		{"ABS", "0.001", "0", "0", "", "treasury"},
+		{"GOLD", "0", "10", "0", "", "treasury"},
+	} {
+		result = invokeAs(stub, admin, "SetFeeSchedule", args...)
+		assert.EqualValues(t, shim.ERROR, result.Status, "SetFeeSchedule %v should fail", args)
+	}
+	assert.Equal(t, "treasury", querySchedule(t, stub, "ABS").Treasury)
+
+	// each asset has its own schedule, in the decimals of the asset
+	invokeAs(stub, admin, "RegisterAsset", "GOLD", "3", "Org1MSP:bob")
+	assert.Equal(t, FeeSchedule{Asset: "GOLD", Flat: "0.000", Min: "0.000"}, querySchedule(t, stub, "GOLD"))
+	result = invokeAs(stub, admin, "SetFeeSchedule", "GOLD", "0.001", "10", "0.002", "", "treasury")
+	assert.EqualValues(t, shim.OK, result.Status, "SetFeeSchedule failed: "+result.Message)
+	assert.Equal(t, FeeSchedule{Asset: "GOLD", Flat: "0.001", BasisPoints: 10, Min: "0.002", Treasury: "treasury"}, querySchedule(t, stub, "GOLD"))
+	assert.Equal(t, "0.10", querySchedule(t, stub, "ABS").Flat)
+	result = invokeAs(stub, admin, "SetFeeSchedule", "GOLD", "0.0001", "0", "0", "", "treasury")
+	assert.EqualValues(t, shim.ERROR, result.Status, "GOLD amounts have 3 decimals")
+	assert.Contains(t, result.Message, string(CodeInvalidAmount))
+
+	// a zero fee needs no treasury
+	result = invokeAs(stub, admin, "SetFeeSchedule", "ABS", "0", "0", "0", "", "")
+	assert.EqualValues(t, shim.OK, result.Status, "SetFeeSchedule failed: "+result.Message)
+	assert.Equal(t, FeeSchedule{Asset: "ABS", Flat: "0.00", Min: "0.00"}, querySchedule(t, stub, "ABS"))
+	result = invokeAs(stub, admin, "SetFeeSchedule", "ABS", "0", "10", "0", "", "")
+	assert.EqualValues(t, shim.ERROR, result.Status, "a fee needs a treasury")
+	result = invokeAs(stub, alice, "Invoke", "a", "b", "1")
+	assert.EqualValues(t, shim.OK, result.Status, "Invoke failed: "+result.Message)
+	checkBalance(t, stub, "a", "99.00")
+}
+
+func TestInvokeChargesFee(t *testing.T) {
+	alice, bob, admin := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	invokeAs(stub, admin, "CreateAccount", "treasury")
+	invokeAs(stub, admin, "SetFeeSchedule", "ABS", "0.5", "100", "0", "", "treasury")
+
+	result := invokeAs(stub, alice, "Invoke", "a", "b", "10")
+	assert.EqualValues(t, shim.OK, result.Status, "Invoke failed: "+result.Message)
+	checkBalance(t, stub, "a", "89.40")
+	checkBalance(t, stub, "b", "10.00")
+	checkBalance(t, stub, "treasury", "0.60")
+	event := lastEvent(stub)
+	assert.JSONEq(t, `{"asset":"ABS","from":"a","to":"b","value":"10.00","fee":"0.60"}`, string(event.Payload))
+
+	statement := getTransfers(t, stub, "a", "", "", "10", "")
+	assert.Equal(t, [][3]string{{"", "100.00", "100.00"}, {"b", "-10.60", "89.40"}}, lines(statement))
+	assert.Equal(t, "0.60", statement.Transfers[1].Fee)
+	statement = getTransfers(t, stub, "b", "", "", "10", "")
+	assert.Equal(t, [][3]string{{"a", "10.00", "10.00"}}, lines(statement))
+	assert.Empty(t, statement.Transfers[0].Fee)
+	statement = getTransfers(t, stub, "treasury", "", "", "10", "")
+	assert.Equal(t, [][3]string{{"a", "0.60", "0.60"}}, lines(statement))
+	assert.Equal(t, "0.60", statement.Transfers[0].Fee)
+
+	// the fee is part of the debited amount
+	result = invokeAs(stub, alice, "Invoke", "b", "a", "10")
+	assert.EqualValues(t, shim.ERROR, result.Status, "the sender should afford the fee")
+	assert.Contains(t, result.Message, string(CodeInsufficientFunds))
+	checkBalance(t, stub, "b", "10.00")
+
+	// payments to the treasury include the fee, the treasury pays no fee
+	invokeAs(stub, alice, "Invoke", "a", "treasury", "1")
+	checkBalance(t, stub, "a", "87.89")
+	checkBalance(t, stub, "treasury", "2.11")
+	statement = getTransfers(t, stub, "treasury", "", "", "10", "")
+	assert.Equal(t, [3]string{"a", "1.51", "2.11"}, lines(statement)[1])
+	assert.Equal(t, "0.51", statement.Transfers[1].Fee)
+	invokeAs(stub, admin, "TransferAccountOwnership", "treasury", "Org1MSP", "bob")
+	result = invokeAs(stub, bob, "Invoke", "treasury", "b", "2.11")
+	assert.EqualValues(t, shim.OK, result.Status, "Invoke failed: "+result.Message)
+	checkBalance(t, stub, "treasury", "0.00")
+	assert.JSONEq(t, `{"asset":"ABS","from":"treasury","to":"b","value":"2.11"}`, string(lastEvent(stub).Payload))
+
+	// TransferFrom charges the owner, the fee does not use the allowance
+	invokeAs(stub, alice, "Approve", "a", "Org1MSP:bob", "10")
+	result = invokeAs(stub, bob, "TransferFrom", "a", "b", "10")
+	assert.EqualValues(t, shim.OK, result.Status, "TransferFrom failed: "+result.Message)
+	checkBalance(t, stub, "a", "77.29")
+	checkBalance(t, stub, "treasury", "0.60")
+	assert.JSONEq(t, `{"asset":"ABS","from":"a","to":"b","value":"10.00","fee":"0.60"}`, string(lastEvent(stub).Payload))
+	result = invoke(stub, "Allowance", "a", "Org1MSP:bob")
+	assert.Equal(t, "0.00", string(result.Payload))
+
+	invokeAs(stub, bob, "Invoke", "treasury", "b", "0.60")
+	result = invokeAs(stub, admin, "Delete", "treasury")
+	assert.EqualValues(t, shim.ERROR, result.Status, "the treasury should not be deleted")
+	assert.Contains(t, result.Message, "fee treasury")
+
+	invariants := checkInvariants(t, stub)
+	assert.True(t, invariants.Consistent)
+}
+
+func TestHoldAndAssetPaymentsChargeFee(t *testing.T) {
+	alice, bob, admin := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	invokeAs(stub, admin, "CreateAccount", "treasury")
+	invokeAs(stub, admin, "SetFeeSchedule", "ABS", "0.5", "100", "0", "", "treasury")
+
+	// the hold reserves the fee of its release
+	result := holdAs(stub, alice, "hold1", "a", "b", "60", inAnHour())
+	assert.EqualValues(t, shim.OK, result.Status, "Hold failed: "+result.Message)
+	checkAvailable(t, stub, "a", "61.10", "38.90")
+	result = holdAs(stub, alice, "hold2", "a", "b", "38.50", inAnHour())
+	assert.EqualValues(t, shim.ERROR, result.Status, "the hold should afford its fee")
+	assert.Contains(t, result.Message, string(CodeInsufficientFunds))
+
+	// a later fee schedule does not change the fee of the hold
+	invokeAs(stub, admin, "SetFeeSchedule", "ABS", "5", "0", "0", "", "treasury")
+	result = invokeAs(stub, alice, "Release", "hold1")
+	assert.EqualValues(t, shim.OK, result.Status, "Release failed: "+result.Message)
+	checkBalance(t, stub, "a", "38.90")
+	checkBalance(t, stub, "b", "60.00")
+	checkBalance(t, stub, "treasury", "1.10")
+	assert.JSONEq(t, `{"asset":"ABS","from":"a","to":"b","value":"60.00","fee":"1.10"}`, string(lastEvent(stub).Payload))
+	statement := getTransfers(t, stub, "a", "", "", "10", "")
+	assert.Equal(t, [3]string{"b", "-61.10", "38.90"}, lines(statement)[1])
+	assert.Equal(t, "1.10", statement.Transfers[1].Fee)
+
+	// the other assets pay the fee of their own schedule in the asset, none without schedule
+	invokeAs(stub, admin, "RegisterAsset", "GOLD", "3", "Org1MSP:bob")
+	invokeAs(stub, bob, "MintAsset", "GOLD", "a", "10")
+	result = invokeAs(stub, alice, "InvokeAsset", "GOLD", "a", "b", "1")
+	assert.EqualValues(t, shim.OK, result.Status, "InvokeAsset failed: "+result.Message)
+	checkAssetBalance(t, stub, "GOLD", "a", "9.000")
+	assert.JSONEq(t, `{"asset":"GOLD","from":"a","to":"b","value":"1.000"}`, string(lastEvent(stub).Payload))
+	invokeAs(stub, admin, "SetFeeSchedule", "GOLD", "0.005", "100", "0", "", "treasury")
+	result = invokeAs(stub, alice, "InvokeAsset", "GOLD", "a", "b", "1")
+	assert.EqualValues(t, shim.OK, result.Status, "InvokeAsset failed: "+result.Message)
+	checkAssetBalance(t, stub, "GOLD", "a", "7.985")
+	checkAssetBalance(t, stub, "GOLD", "b", "2.000")
+	checkAssetBalance(t, stub, "GOLD", "treasury", "0.015")
+	checkBalance(t, stub, "a", "38.90")
+	assert.JSONEq(t, `{"asset":"GOLD","from":"a","to":"b","value":"1.000","fee":"0.015"}`, string(lastEvent(stub).Payload))
+
+	invariants := checkInvariants(t, stub)
+	assert.True(t, invariants.Consistent)
+}
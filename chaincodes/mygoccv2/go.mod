@@ -2,4 +2,19 @@ module mygoccv2
 
 go 1.13
 
-require github.com/hyperledger/fabric-contract-api-go v1.1.0
+require (
+	ccserver v0.0.0
+	github.com/golang/protobuf v1.3.2
+	github.com/hyperledger/fabric-chaincode-go v0.0.0-20200424173110-d7076418f212
+	github.com/hyperledger/fabric-contract-api-go v1.1.0
+	github.com/hyperledger/fabric-protos-go v0.0.0-20200424173316-dd554ba3746e
+	github.com/stretchr/testify v1.5.1
+	mangostub v0.0.0
+	schema v0.0.0
+)
+
+replace (
+	ccserver => ../ccserver
+	mangostub => ../mangostub
+	schema => ../schema
+)
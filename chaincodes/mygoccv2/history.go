@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// transferRecordObjectType indexes the transfer records by account~asset~timestamp~txid
+	transferRecordObjectType = "transfer"
+	// recordTimestampLayout has a fixed width, the timestamps of the records sort as strings
+	recordTimestampLayout = "2006-01-02T15:04:05.000000000Z"
+)
+
+// TransferRecord is a line of the statement of an account, written for both parties of a transfer
+type TransferRecord struct {
+	TxID         string `json:"txId"`
+	Asset        string `json:"asset"`
+	Timestamp    string `json:"timestamp"`                // RFC 3339 transaction timestamp
+	Counterparty string `json:"counterparty"`             // empty for a mint or a burn
+	Amount       string `json:"amount"`                   // negative for a debit
+	Balance      string `json:"balance"`                  // balance of the account after the transfer
+	Fee          string `json:"fee" metadata:",optional"` // fee paid to the treasury, included in the amount
+}
+
+// Statement is a page of the transfer records of an account in an asset, in timestamp order
+type Statement struct {
+	Asset          string           `json:"asset"`
+	Account        string           `json:"account"`
+	Transfers      []TransferRecord `json:"transfers"`
+	OpeningBalance string           `json:"openingBalance" metadata:",optional"` // balance before the first transfer of the page
+	ClosingBalance string           `json:"closingBalance" metadata:",optional"` // balance after the last transfer of the page
+	Bookmark       string           `json:"bookmark" metadata:",optional"`       // bookmark of the next page, empty on the last page
+}
+
+// GetTransfers returns at most pageSize transfers of the default asset of an account from the
+// RFC 3339 timestamp from, included, to the timestamp to, excluded, starting from the bookmark of
+// the previous page. Empty timestamps leave the period open.
+func (t *ABstore) GetTransfers(ctx contractapi.TransactionContextInterface, account string, from string, to string, pageSize int, bookmark string) (Statement, error) {
+	return getStatement(ctx.GetStub(), defaultAsset, account, from, to, pageSize, bookmark)
+}
+
+// GetAssetTransfers returns the statement of an account in an asset, like GetTransfers
+func (t *ABstore) GetAssetTransfers(ctx contractapi.TransactionContextInterface, asset string, account string, from string, to string, pageSize int, bookmark string) (Statement, error) {
+	a, err := getAsset(ctx.GetStub(), asset)
+	if err != nil {
+		return Statement{Transfers: make([]TransferRecord, 0)}, err
+	}
+	return getStatement(ctx.GetStub(), a, account, from, to, pageSize, bookmark)
+}
+
+func getStatement(stub shim.ChaincodeStubInterface, asset Asset, account string, from string, to string, pageSize int, bookmark string) (Statement, error) {
+	statement := Statement{Asset: asset.Symbol, Account: account, Transfers: make([]TransferRecord, 0)}
+	if pageSize <= 0 {
+		return statement, &InvalidArgumentError{Argument: "pageSize", Reason: "must be a positive integer"}
+	}
+	if err := checkLatestSchema(stub); err != nil {
+		return statement, err
+	}
+	prefix, err := transferRecordKey(stub, account, asset.Symbol)
+	if err != nil {
+		return statement, err
+	}
+	startKey, endKey := "", ""
+	if from != "" {
+		if startKey, err = periodKey(stub, account, asset, "from", from); err != nil {
+			return statement, err
+		}
+	}
+	if to != "" {
+		if endKey, err = periodKey(stub, account, asset, "to", to); err != nil {
+			return statement, err
+		}
+	}
+	if bookmark != "" {
+		if !strings.HasPrefix(bookmark, prefix) {
+			return statement, &InvalidArgumentError{Argument: "bookmark", Reason: "must be the bookmark of a previous page"}
+		}
+		if bookmark > startKey {
+			startKey = bookmark
+		}
+	}
+
+	// the bookmarks of the composite key queries are the keys the pages start at, which lets the
+	// period start at from
+	resultsIterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(transferRecordObjectType, []string{account, asset.Symbol}, int32(pageSize), startKey)
+	if err != nil {
+		return statement, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return statement, err
+		}
+		if endKey != "" && queryResponse.Key >= endKey {
+			break
+		}
+		record := TransferRecord{}
+		if err := json.Unmarshal(queryResponse.Value, &record); err != nil {
+			return statement, &CorruptedStateError{Object: fmt.Sprintf("transfer record %q", queryResponse.Key)}
+		}
+		statement.Transfers = append(statement.Transfers, record)
+	}
+	if endKey == "" || metadata.Bookmark < endKey {
+		statement.Bookmark = metadata.Bookmark
+	}
+
+	if len(statement.Transfers) > 0 {
+		first, last := statement.Transfers[0], statement.Transfers[len(statement.Transfers)-1]
+		balance, err := asset.parseAmount(first.Balance)
+		if err != nil {
+			return statement, err
+		}
+		amount, err := asset.parseAmount(first.Amount)
+		if err != nil {
+			return statement, err
+		}
+		statement.OpeningBalance = asset.formatAmount(balance.Sub(balance, amount))
+		statement.ClosingBalance = last.Balance
+	}
+	return statement, nil
+}
+
+// recordTransfer writes the transfer records of both parties, and of the treasury receiving the
+// fee, with their balances after the transfer. A mint has no from account and a burn no to account.
+// An account has a single record per transaction, its amount is the change of the balance.
+func recordTransfer(stub shim.ChaincodeStubInterface, asset Asset, from string, fromBalance *big.Int, to string, toBalance *big.Int, amount *big.Int, fee *transferFee) error {
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	timestamp := now.Format(recordTimestampLayout)
+	debit, credit := new(big.Int).Neg(amount), new(big.Int).Set(amount)
+	feeAmount, creditFee := "", ""
+	if fee != nil {
+		feeAmount = asset.formatAmount(fee.Amount)
+		debit.Sub(debit, fee.Amount)
+		if fee.Treasury == to {
+			credit.Add(credit, fee.Amount)
+			creditFee = feeAmount
+		}
+	}
+	if from != "" {
+		record := TransferRecord{Asset: asset.Symbol, Counterparty: to, Amount: asset.formatAmount(debit), Balance: asset.formatAmount(fromBalance), Fee: feeAmount}
+		if err := putTransferRecord(stub, from, timestamp, record); err != nil {
+			return err
+		}
+	}
+	if to != "" {
+		record := TransferRecord{Asset: asset.Symbol, Counterparty: from, Amount: asset.formatAmount(credit), Balance: asset.formatAmount(toBalance), Fee: creditFee}
+		if err := putTransferRecord(stub, to, timestamp, record); err != nil {
+			return err
+		}
+	}
+	if fee != nil && fee.Treasury != to {
+		record := TransferRecord{Asset: asset.Symbol, Counterparty: from, Amount: feeAmount, Balance: asset.formatAmount(fee.TreasuryBalance), Fee: feeAmount}
+		if err := putTransferRecord(stub, fee.Treasury, timestamp, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putTransferRecord(stub shim.ChaincodeStubInterface, account string, timestamp string, record TransferRecord) error {
+	record.TxID = stub.GetTxID()
+	record.Timestamp = timestamp
+	key, err := transferRecordKey(stub, account, record.Asset, timestamp, record.TxID)
+	if err != nil {
+		return err
+	}
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, recordBytes)
+}
+
+func transferRecordKey(stub shim.ChaincodeStubInterface, account string, attributes ...string) (string, error) {
+	return stub.CreateCompositeKey(transferRecordObjectType, append([]string{account}, attributes...))
+}
+
+// periodKey returns the key of the records of an account in an asset starting at an RFC 3339 timestamp
+func periodKey(stub shim.ChaincodeStubInterface, account string, asset Asset, argument string, timestamp string) (string, error) {
+	bound, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return "", &InvalidArgumentError{Argument: argument, Reason: "expecting an RFC 3339 timestamp"}
+	}
+	return transferRecordKey(stub, account, asset.Symbol, bound.UTC().Format(recordTimestampLayout))
+}
+
+// transferRecordsMigration moves the transfer records from the account~timestamp~txid keys to the
+// account~asset~timestamp~txid keys. Write transactions cannot page the composite keys, the records
+// are scanned from the start up to the resume key.
+func transferRecordsMigration(stub shim.ChaincodeStubInterface, resumeKey string, limit int) (string, int, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(transferRecordObjectType, []string{})
+	if err != nil {
+		return "", 0, err
+	}
+	defer resultsIterator.Close()
+
+	type legacyRecord struct {
+		key        string
+		attributes []string
+		value      []byte
+	}
+	// collect the page before writing, the new keys are under the same account prefix
+	records := make([]legacyRecord, 0)
+	next := ""
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return "", 0, err
+		}
+		if queryResponse.Key < resumeKey {
+			continue
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return "", 0, err
+		}
+		if len(attributes) != 3 {
+			// already keyed by asset
+			continue
+		}
+		if len(records) == limit {
+			next = queryResponse.Key
+			break
+		}
+		records = append(records, legacyRecord{key: queryResponse.Key, attributes: attributes, value: queryResponse.Value})
+	}
+
+	for _, legacy := range records {
+		record := TransferRecord{}
+		if err := json.Unmarshal(legacy.value, &record); err != nil {
+			return "", 0, &CorruptedStateError{Object: fmt.Sprintf("transfer record %q", legacy.key)}
+		}
+		if record.Asset == "" {
+			record.Asset = defaultAssetSymbol
+		}
+		recordBytes, err := json.Marshal(record)
+		if err != nil {
+			return "", 0, err
+		}
+		key, err := transferRecordKey(stub, legacy.attributes[0], record.Asset, legacy.attributes[1], legacy.attributes[2])
+		if err != nil {
+			return "", 0, err
+		}
+		if err := stub.PutState(key, recordBytes); err != nil {
+			return "", 0, err
+		}
+		if err := stub.DelState(legacy.key); err != nil {
+			return "", 0, err
+		}
+	}
+	return next, len(records), nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func getTransfers(t *testing.T, stub *shimtest.MockStub, args ...string) Statement {
+	result := invoke(stub, "GetTransfers", args...)
+	assert.EqualValues(t, shim.OK, result.Status, "GetTransfers failed: "+result.Message)
+	var statement Statement
+	json.Unmarshal(result.Payload, &statement)
+	return statement
+}
+
+// lines returns the counterparty, amount and balance of the transfers of a statement
+func lines(statement Statement) [][3]string {
+	lines := make([][3]string, 0)
+	for _, record := range statement.Transfers {
+		lines = append(lines, [3]string{record.Counterparty, record.Amount, record.Balance})
+	}
+	return lines
+}
+
+func TestGetTransfers(t *testing.T) {
+	alice, _, _ := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	invokeAs(stub, alice, "Invoke", "a", "b", "10")
+	invokeAs(stub, alice, "Invoke", "a", "b", "20.5")
+	middle := time.Now().UTC().Format(time.RFC3339Nano)
+	invokeAs(stub, alice, "Invoke", "b", "a", "5")
+
+	statement := getTransfers(t, stub, "a", "", "", "10", "")
+	assert.Equal(t, "a", statement.Account)
+	assert.Equal(t, [][3]string{
+		{"", "100.00", "100.00"},
+		{"b", "-10.00", "90.00"},
+		{"b", "-20.50", "69.50"},
+		{"b", "5.00", "74.50"},
+	}, lines(statement))
+	assert.Equal(t, "0.00", statement.OpeningBalance)
+	assert.Equal(t, "74.50", statement.ClosingBalance)
+	assert.Empty(t, statement.Bookmark)
+	assert.Equal(t, "1", statement.Transfers[1].TxID)
+	_, err := time.Parse(time.RFC3339, statement.Transfers[1].Timestamp)
+	assert.Nil(t, err, "the timestamps should be RFC 3339")
+
+	statement = getTransfers(t, stub, "b", "", "", "10", "")
+	assert.Equal(t, [][3]string{{"a", "10.00", "10.00"}, {"a", "20.50", "30.50"}, {"a", "-5.00", "25.50"}}, lines(statement))
+
+	// page through the statement
+	statement = getTransfers(t, stub, "a", "", "", "2", "")
+	assert.Len(t, statement.Transfers, 2)
+	assert.NotEmpty(t, statement.Bookmark)
+	statement = getTransfers(t, stub, "a", "", "", "2", statement.Bookmark)
+	assert.Equal(t, [][3]string{{"b", "-20.50", "69.50"}, {"b", "5.00", "74.50"}}, lines(statement))
+	assert.Equal(t, "90.00", statement.OpeningBalance)
+	assert.Empty(t, statement.Bookmark)
+
+	// the period includes from and excludes to
+	statement = getTransfers(t, stub, "a", middle, "", "10", "")
+	assert.Equal(t, [][3]string{{"b", "5.00", "74.50"}}, lines(statement))
+	statement = getTransfers(t, stub, "a", "", middle, "10", "")
+	assert.Len(t, statement.Transfers, 3)
+	statement = getTransfers(t, stub, "a", "2000-01-01T00:00:00Z", "2001-01-01T00:00:00Z", "10", "")
+	assert.Empty(t, statement.Transfers)
+	assert.Empty(t, statement.OpeningBalance)
+
+	statement = getTransfers(t, stub, "c", "", "", "10", "")
+	assert.Empty(t, statement.Transfers)
+}
+
+func TestGetTransfersMintAndBurn(t *testing.T) {
+	alice, _, _ := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	invokeAs(stub, minter(t), "CreateAccount", "treasury")
+	invokeAs(stub, minter(t), "Mint", "treasury", "50")
+	invokeAs(stub, minter(t), "Burn", "treasury", "20")
+
+	statement := getTransfers(t, stub, "treasury", "", "", "10", "")
+	assert.Equal(t, [][3]string{{"", "50.00", "50.00"}, {"", "-20.00", "30.00"}}, lines(statement))
+	statement = getTransfers(t, stub, "b", "", "", "10", "")
+	assert.Empty(t, statement.Transfers, "zero initial balances are not recorded")
+}
+
+func TestGetTransfersErrors(t *testing.T) {
+	stub := newABstoreStub(t)
+	invoke(stub, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+
+	for _, args := range [][]string{
+		{"a", "", "", "0", ""},
+		{"a", "yesterday", "", "10", ""},
+		{"a", "", "tomorrow", "10", ""},
+		{"a", "", "", "10", "no bookmark"},
+	} {
+		result := invoke(stub, "GetTransfers", args...)
+		assert.EqualValues(t, shim.ERROR, result.Status, "GetTransfers %v should fail", args)
+		assert.Contains(t, result.Message, string(CodeInvalidArgument), "GetTransfers %v", args)
+	}
+}
+
+func TestTransferRecordsMigration(t *testing.T) {
+	alice, _, admin := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+
+	// records of a ledger at schema version 5, keyed by account~timestamp~txid
+	stub.MockTransactionStart("1")
+	for _, legacy := range []struct{ txID, asset, amount string }{{"old1", "", "-1.00"}, {"old2", "GOLD", "-0.500"}, {"old3", "", "-2.00"}} {
+		record := TransferRecord{TxID: legacy.txID, Asset: legacy.asset, Timestamp: "2000-01-01T00:00:00Z", Counterparty: "b", Amount: legacy.amount, Balance: "0.00"}
+		recordBytes, _ := json.Marshal(record)
+		key, _ := transferRecordKey(stub, "a", "2000-01-01T00:00:00.000000000Z", legacy.txID)
+		stub.PutState(key, recordBytes)
+	}
+	saveSchemaState(stub, SchemaVersion{Version: 5})
+	stub.MockTransactionEnd("1")
+
+	result := invoke(stub, "GetTransfers", "a", "", "", "10", "")
+	assert.EqualValues(t, shim.ERROR, result.Status, "the legacy records would be missing from the statement")
+	assert.Contains(t, result.Message, string(CodeMigrationRequired))
+
+	var migrationResult MigrationResult
+	for i := 0; i < 10 && !migrationResult.Done; i++ {
+		result = invokeAs(stub, admin, "Migrate", "1", org1MSPs, org1MSPs)
+		assert.EqualValues(t, shim.OK, result.Status, "Migrate failed: "+result.Message)
+		json.Unmarshal(result.Payload, &migrationResult)
+	}
+	assert.True(t, migrationResult.Done)
+	assert.Equal(t, 6, migrationResult.Version)
+
+	statement := getTransfers(t, stub, "a", "", "", "10", "")
+	assert.Equal(t, [][3]string{{"b", "-1.00", "0.00"}, {"b", "-2.00", "0.00"}, {"", "100.00", "100.00"}}, lines(statement))
+	assert.Equal(t, "ABS", statement.Transfers[0].Asset)
+
+	stub.MockTransactionStart("2")
+	key, _ := transferRecordKey(stub, "a", "GOLD", "2000-01-01T00:00:00.000000000Z", "old2")
+	gold, _ := stub.GetState(key)
+	legacyKey, _ := transferRecordKey(stub, "a", "2000-01-01T00:00:00.000000000Z", "old2")
+	legacy, _ := stub.GetState(legacyKey)
+	stub.MockTransactionEnd("2")
+	assert.NotNil(t, gold, "the records of the other assets should be keyed by their asset")
+	assert.Nil(t, legacy, "the legacy keys should be deleted")
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	holdObjectType = "hold"
+	// holdAccountObjectType indexes the holds by payer and by payee
+	holdAccountObjectType = "holdAccount"
+	// holdExpiryObjectType indexes the holds by expiry, the expiry is zero padded to sort as a string
+	holdExpiryObjectType = "holdExpiry"
+)
+
+// Hold reserves an amount of the payer account for the payee until it expires. The funds stay
+// on the payer account, which can only spend its available balance, until the hold is released.
+// The fee of the release is reserved with the amount, at the fee schedule of the hold creation.
+type Hold struct {
+	ID        string `json:"id"` // ID of the transaction that created the hold
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Amount    string `json:"amount"`
+	ExpiresAt string `json:"expiresAt"`                     // RFC 3339 timestamp
+	Hashlock  string `json:"hashlock" metadata:",optional"` // hex SHA-256 of the preimage claiming a hashed time-locked transfer
+	Fee       string `json:"fee" metadata:",optional"`      // fee paid to the treasury on release, empty without fee
+}
+
+// ExpiredHolds is the result of ExpireHolds
+type ExpiredHolds struct {
+	Expired []string `json:"expired"` // IDs of the deleted holds
+	Done    bool     `json:"done"`    // no expired hold is left
+}
+
+// Hold reserves amount of the from account for the to account until expiresAt, an RFC 3339
+// timestamp. Only the owner of from can hold its funds. Returns the ID of the hold.
+func (t *ABstore) Hold(ctx contractapi.TransactionContextInterface, from string, to string, amount string, expiresAt string) (string, error) {
+	hold, err := createHold(ctx.GetStub(), from, to, amount, expiresAt, "")
+	if err != nil {
+		return "", err
+	}
+	return hold.ID, nil
+}
+
+// GetHold returns a hold that is neither released, cancelled nor expired by ExpireHolds
+func (t *ABstore) GetHold(ctx contractapi.TransactionContextInterface, holdID string) (Hold, error) {
+	hold, err := getHold(ctx.GetStub(), holdID)
+	if err != nil {
+		return Hold{}, err
+	}
+	return *hold, nil
+}
+
+// Release completes a hold before it expires, transferring the amount to the payee.
+// The owner of the payer account or an admin can release it.
+func (t *ABstore) Release(ctx contractapi.TransactionContextInterface, holdID string) error {
+	stub := ctx.GetStub()
+	hold, err := getHold(stub, holdID)
+	if err != nil {
+		return err
+	}
+	if err := checkOwnerOrAdmin(stub, hold.From); err != nil {
+		return err
+	}
+	if hold.Hashlock != "" {
+		return &InvalidArgumentError{Argument: "holdID", Reason: holdID + " is a hashed time-locked transfer, use ClaimHTLC"}
+	}
+	return releaseHold(stub, hold)
+}
+
+// Cancel deletes a hold, returning the amount to the available balance of the payer. The owner
+// of the payee account or an admin can cancel it at any time, the owner of the payer account
+// once it expired.
+func (t *ABstore) Cancel(ctx contractapi.TransactionContextInterface, holdID string) error {
+	stub := ctx.GetStub()
+	hold, err := getHold(stub, holdID)
+	if err != nil {
+		return err
+	}
+	if hold.Hashlock != "" {
+		return &InvalidArgumentError{Argument: "holdID", Reason: holdID + " is a hashed time-locked transfer, use RefundHTLC"}
+	}
+	if err := checkOwnerOrAdmin(stub, hold.To); err != nil {
+		expired, expiryErr := isExpired(stub, hold)
+		if expiryErr != nil {
+			return expiryErr
+		}
+		if !expired || checkOwner(stub, hold.From) != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Cancelling hold %s\n", holdID)
+	return delHold(stub, *hold)
+}
+
+// ExpireHolds deletes at most limit holds expired at the transaction timestamp, in expiry
+// order. Expired holds no longer reserve funds, deleting them only frees their records.
+// Hashed time-locked transfers are left to RefundHTLC, which notifies the relayers.
+func (t *ABstore) ExpireHolds(ctx contractapi.TransactionContextInterface, limit int) (ExpiredHolds, error) {
+	result := ExpiredHolds{Expired: make([]string, 0)}
+	if limit <= 0 {
+		return result, &InvalidArgumentError{Argument: "limit", Reason: "must be a positive integer"}
+	}
+	stub := ctx.GetStub()
+	now, err := txTime(stub)
+	if err != nil {
+		return result, err
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(holdExpiryObjectType, []string{})
+	if err != nil {
+		return result, err
+	}
+	defer resultsIterator.Close()
+
+	expired := make([]Hold, 0)
+	result.Done = true
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return result, err
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return result, err
+		}
+		if attributes[0] > expiryKey(now) {
+			break
+		}
+		hold, err := getHold(stub, attributes[1])
+		if err != nil {
+			return result, err
+		}
+		if hold.Hashlock != "" {
+			continue
+		}
+		if len(result.Expired) == limit {
+			result.Done = false
+			break
+		}
+		expired = append(expired, *hold)
+		result.Expired = append(result.Expired, hold.ID)
+	}
+
+	for _, hold := range expired {
+		if err := delHold(stub, hold); err != nil {
+			return result, err
+		}
+	}
+	fmt.Printf("Expired %d holds\n", len(result.Expired))
+	return result, nil
+}
+
+// createHold checks and saves a hold of the client, with a hashlock for a hashed time-locked transfer
+func createHold(stub shim.ChaincodeStubInterface, from string, to string, amount string, expiresAt string, hashlock string) (Hold, error) {
+	value, err := parsePositiveAmount(amount)
+	if err != nil {
+		return Hold{}, err
+	}
+	expiry, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return Hold{}, &InvalidArgumentError{Argument: "expiresAt", Reason: "expecting an RFC 3339 timestamp"}
+	}
+	// holds expire on a second boundary, the precision of the expiry index
+	expiry = expiry.UTC().Truncate(time.Second)
+	now, err := txTime(stub)
+	if err != nil {
+		return Hold{}, err
+	}
+	if !now.Before(expiry) {
+		return Hold{}, &InvalidArgumentError{Argument: "expiresAt", Reason: "must be after the transaction timestamp"}
+	}
+	if from == to {
+		return Hold{}, &SelfTransferError{Account: from}
+	}
+	if err := checkLatestSchema(stub); err != nil {
+		return Hold{}, err
+	}
+	balance, err := getBalance(stub, from)
+	if err != nil {
+		return Hold{}, err
+	}
+	if _, err := getBalance(stub, to); err != nil {
+		return Hold{}, err
+	}
+	if err := checkOwner(stub, from); err != nil {
+		return Hold{}, err
+	}
+	held, err := heldAmount(stub, from, "")
+	if err != nil {
+		return Hold{}, err
+	}
+	fee, err := paymentFee(stub, defaultAsset, from, value)
+	if err != nil {
+		return Hold{}, err
+	}
+	total := new(big.Int).Set(value)
+	if fee != nil {
+		total.Add(total, fee.Amount)
+	}
+	available := balance.Sub(balance, held)
+	if available.Cmp(total) < 0 {
+		return Hold{}, &InsufficientFundsError{Account: from, Balance: formatAmount(available), Amount: formatAmount(total)}
+	}
+
+	hold := Hold{
+		ID:        stub.GetTxID(),
+		From:      from,
+		To:        to,
+		Amount:    formatAmount(value),
+		ExpiresAt: expiry.Format(time.RFC3339),
+		Hashlock:  hashlock,
+	}
+	if fee != nil {
+		hold.Fee = formatAmount(fee.Amount)
+	}
+	fmt.Printf("Holding %s of %s for %s until %s\n", hold.Amount, from, to, hold.ExpiresAt)
+	return hold, putHold(stub, hold)
+}
+
+// releaseHold transfers the amount of a hold that has not expired to the payee
+func releaseHold(stub shim.ChaincodeStubInterface, hold *Hold) error {
+	expired, err := isExpired(stub, hold)
+	if err != nil {
+		return err
+	}
+	if expired {
+		return &HoldExpiredError{HoldID: hold.ID, ExpiresAt: hold.ExpiresAt}
+	}
+	amount, err := parseAmount(hold.Amount)
+	if err != nil {
+		return err
+	}
+
+	var fee *transferFee
+	if hold.Fee != "" {
+		if fee, err = reservedFee(stub, defaultAsset, hold.From, hold.Fee); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Releasing hold %s\n", hold.ID)
+	if err := delHold(stub, *hold); err != nil {
+		return err
+	}
+	return transferFunds(stub, defaultAsset, hold.From, hold.To, amount, fee, hold.ID)
+}
+
+// heldAmount returns the sum of the holds of an account that have not expired, except the
+// hold being released
+func heldAmount(stub shim.ChaincodeStubInterface, account string, releasedHold string) (*big.Int, error) {
+	held := new(big.Int)
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(holdAccountObjectType, []string{account})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if attributes[1] == releasedHold {
+			continue
+		}
+		hold, err := getHold(stub, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		if hold.From != account {
+			continue
+		}
+		expired, err := isExpired(stub, hold)
+		if err != nil {
+			return nil, err
+		}
+		if expired {
+			continue
+		}
+		amount, err := parseAmount(hold.Amount)
+		if err != nil {
+			return nil, err
+		}
+		held.Add(held, amount)
+		if hold.Fee != "" {
+			fee, err := parseAmount(hold.Fee)
+			if err != nil {
+				return nil, &CorruptedStateError{Object: fmt.Sprintf("fee %q of hold %s", hold.Fee, hold.ID)}
+			}
+			held.Add(held, fee)
+		}
+	}
+	return held, nil
+}
+
+// hasHolds returns whether an account is the payer or the payee of a hold, expired or not
+func hasHolds(stub shim.ChaincodeStubInterface, account string) (bool, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(holdAccountObjectType, []string{account})
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+	return resultsIterator.HasNext(), nil
+}
+
+// txTime returns the timestamp of the transaction, the same on all the endorsers
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(timestamp.GetSeconds(), int64(timestamp.GetNanos())).UTC(), nil
+}
+
+func isExpired(stub shim.ChaincodeStubInterface, hold *Hold) (bool, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return false, err
+	}
+	expiry, err := time.Parse(time.RFC3339, hold.ExpiresAt)
+	if err != nil {
+		return false, &CorruptedStateError{Object: fmt.Sprintf("expiry %q of hold %s", hold.ExpiresAt, hold.ID)}
+	}
+	return !now.Before(expiry), nil
+}
+
+func expiryKey(expiry time.Time) string {
+	return fmt.Sprintf("%020d", expiry.Unix())
+}
+
+func holdKey(stub shim.ChaincodeStubInterface, holdID string) (string, error) {
+	return stub.CreateCompositeKey(holdObjectType, []string{holdID})
+}
+
+// getHold returns a hold, HoldNotFoundError if there is none with that ID
+func getHold(stub shim.ChaincodeStubInterface, holdID string) (*Hold, error) {
+	key, err := holdKey(stub, holdID)
+	if err != nil {
+		return nil, err
+	}
+	holdBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, &InternalError{Reason: "Failed to get state"}
+	}
+	if holdBytes == nil {
+		return nil, &HoldNotFoundError{HoldID: holdID}
+	}
+	hold := &Hold{}
+	if err := json.Unmarshal(holdBytes, hold); err != nil {
+		return nil, &CorruptedStateError{Object: "hold " + holdID}
+	}
+	return hold, nil
+}
+
+// holdIndexKeys returns the keys indexing a hold by payer, payee and expiry
+func holdIndexKeys(stub shim.ChaincodeStubInterface, hold Hold) ([]string, error) {
+	expiry, err := time.Parse(time.RFC3339, hold.ExpiresAt)
+	if err != nil {
+		return nil, &CorruptedStateError{Object: fmt.Sprintf("expiry %q of hold %s", hold.ExpiresAt, hold.ID)}
+	}
+	keys := make([]string, 0, 3)
+	for _, index := range []struct {
+		objectType string
+		attributes []string
+	}{
+		{holdAccountObjectType, []string{hold.From, hold.ID}},
+		{holdAccountObjectType, []string{hold.To, hold.ID}},
+		{holdExpiryObjectType, []string{expiryKey(expiry), hold.ID}},
+	} {
+		key, err := stub.CreateCompositeKey(index.objectType, index.attributes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func putHold(stub shim.ChaincodeStubInterface, hold Hold) error {
+	key, err := holdKey(stub, hold.ID)
+	if err != nil {
+		return err
+	}
+	holdBytes, err := json.Marshal(hold)
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(key, holdBytes); err != nil {
+		return err
+	}
+	indexKeys, err := holdIndexKeys(stub, hold)
+	if err != nil {
+		return err
+	}
+	// an empty value would delete the key, save a null byte as the marbles index does
+	for _, indexKey := range indexKeys {
+		if err := stub.PutState(indexKey, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func delHold(stub shim.ChaincodeStubInterface, hold Hold) error {
+	key, err := holdKey(stub, hold.ID)
+	if err != nil {
+		return err
+	}
+	indexKeys, err := holdIndexKeys(stub, hold)
+	if err != nil {
+		return err
+	}
+	for _, k := range append(indexKeys, key) {
+		if err := stub.DelState(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
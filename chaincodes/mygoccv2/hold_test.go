@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// holdAs creates a hold in the transaction txID, the ID of the hold
+func holdAs(stub *shimtest.MockStub, identity []byte, txID string, args ...string) pb.Response {
+	stub.Creator = identity
+	invokeArgs := [][]byte{[]byte("Hold")}
+	for _, arg := range args {
+		invokeArgs = append(invokeArgs, []byte(arg))
+	}
+	return stub.MockInvoke(txID, invokeArgs)
+}
+
+// putExpiredHold saves a hold that expired an hour ago, MockStub transactions run at the current time
+func putExpiredHold(t *testing.T, stub *shimtest.MockStub, holdID string, from string, to string, amount string) {
+	stub.MockTransactionStart(holdID)
+	err := putHold(stub, Hold{ID: holdID, From: from, To: to, Amount: amount, ExpiresAt: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)})
+	stub.MockTransactionEnd(holdID)
+	assert.Nil(t, err)
+}
+
+func checkAvailable(t *testing.T, stub *shimtest.MockStub, account string, held string, available string) {
+	result := invoke(stub, "Query", account)
+	assert.EqualValues(t, shim.OK, result.Status, "Query failed: "+result.Message)
+	var a Account
+	json.Unmarshal(result.Payload, &a)
+	assert.Equal(t, held, a.Held, "held amount of %s", account)
+	assert.Equal(t, available, a.Available, "available balance of %s", account)
+}
+
+func inAnHour() string {
+	return time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+}
+
+func TestHoldAndRelease(t *testing.T) {
+	alice, bob, _ := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+
+	result := holdAs(stub, alice, "hold1", "a", "b", "60", inAnHour())
+	assert.EqualValues(t, shim.OK, result.Status, "Hold failed: "+result.Message)
+	assert.Equal(t, "hold1", string(result.Payload))
+	checkBalance(t, stub, "a", "100.00")
+	checkAvailable(t, stub, "a", "60.00", "40.00")
+	checkAvailable(t, stub, "b", "0.00", "0.00")
+
+	result = invoke(stub, "GetHold", "hold1")
+	assert.EqualValues(t, shim.OK, result.Status, "GetHold failed: "+result.Message)
+	var hold Hold
+	json.Unmarshal(result.Payload, &hold)
+	assert.Equal(t, "a", hold.From)
+	assert.Equal(t, "60.00", hold.Amount)
+
+	// the held funds cannot be spent
+	result = invokeAs(stub, alice, "Invoke", "a", "b", "40.01")
+	assert.EqualValues(t, shim.ERROR, result.Status, "Invoke should respect the available balance")
+	assert.Equal(t, "INSUFFICIENT_FUNDS: Insufficient funds in a: balance 40.00, amount 40.01", result.Message)
+	result = holdAs(stub, alice, "hold2", "a", "b", "50", inAnHour())
+	assert.EqualValues(t, shim.ERROR, result.Status, "holds should not exceed the available balance")
+	assert.Contains(t, result.Message, string(CodeInsufficientFunds))
+
+	result = invokeAs(stub, bob, "Release", "hold1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "only the payer should release")
+	assert.Contains(t, result.Message, string(CodeNotAccountOwner))
+
+	result = invokeAs(stub, alice, "Release", "hold1")
+	assert.EqualValues(t, shim.OK, result.Status, "Release failed: "+result.Message)
+	checkBalance(t, stub, "a", "40.00")
+	checkBalance(t, stub, "b", "60.00")
+	checkAvailable(t, stub, "a", "0.00", "40.00")
+	event := lastEvent(stub)
+	assert.Equal(t, "Transfer", event.EventName)
+	assert.JSONEq(t, `{"asset":"ABS","from":"a","to":"b","value":"60.00"}`, string(event.Payload))
+
+	result = invokeAs(stub, alice, "Release", "hold1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "a hold should only be released once")
+	assert.Contains(t, result.Message, string(CodeHoldNotFound))
+}
+
+func TestHoldErrors(t *testing.T) {
+	alice, bob, _ := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+
+	for _, test := range []struct {
+		identity []byte
+		args     []string
+		code     ErrorCode
+	}{
+		{alice, []string{"a", "b", "0", inAnHour()}, CodeInvalidAmount},
+		{alice, []string{"a", "b", "10", "tomorrow"}, CodeInvalidArgument},
+		{alice, []string{"a", "b", "10", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)}, CodeInvalidArgument},
+		{alice, []string{"a", "a", "10", inAnHour()}, CodeSelfTransfer},
+		{alice, []string{"a", "c", "10", inAnHour()}, CodeAccountNotFound},
+		{alice, []string{"a", "b", "100.01", inAnHour()}, CodeInsufficientFunds},
+		{bob, []string{"a", "b", "10", inAnHour()}, CodeNotAccountOwner},
+	} {
+		result := holdAs(stub, test.identity, "hold", test.args...)
+		assert.EqualValues(t, shim.ERROR, result.Status, "Hold %v should fail", test.args)
+		assert.Contains(t, result.Message, string(test.code), "Hold %v", test.args)
+	}
+	checkAvailable(t, stub, "a", "0.00", "100.00")
+}
+
+func TestCancel(t *testing.T) {
+	alice, bob, admin := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	invokeAs(stub, bob, "CreateAccount", "c")
+	holdAs(stub, alice, "hold1", "a", "c", "30", inAnHour())
+	holdAs(stub, alice, "hold2", "a", "c", "20", inAnHour())
+	checkAvailable(t, stub, "a", "50.00", "50.00")
+
+	result := invokeAs(stub, alice, "Cancel", "hold1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "the payer should not cancel an active hold")
+	assert.Contains(t, result.Message, string(CodeNotAccountOwner))
+
+	result = invokeAs(stub, bob, "Cancel", "hold1")
+	assert.EqualValues(t, shim.OK, result.Status, "Cancel failed: "+result.Message)
+	result = invokeAs(stub, admin, "Cancel", "hold2")
+	assert.EqualValues(t, shim.OK, result.Status, "Cancel failed: "+result.Message)
+	checkBalance(t, stub, "a", "100.00")
+	checkAvailable(t, stub, "a", "0.00", "100.00")
+
+	result = invoke(stub, "GetHold", "hold1")
+	assert.EqualValues(t, shim.ERROR, result.Status)
+	assert.Equal(t, "HOLD_NOT_FOUND: Hold not found: hold1", result.Message)
+}
+
+func TestExpiredHolds(t *testing.T) {
+	alice, bob, _ := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	invokeAs(stub, bob, "CreateAccount", "c")
+	putExpiredHold(t, stub, "expired1", "a", "c", "50.00")
+	putExpiredHold(t, stub, "expired2", "a", "b", "10.00")
+	holdAs(stub, alice, "active", "a", "b", "20", inAnHour())
+
+	// expired holds no longer reserve funds
+	checkAvailable(t, stub, "a", "20.00", "80.00")
+	result := invokeAs(stub, alice, "Release", "expired1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "expired holds should not be released")
+	assert.Contains(t, result.Message, string(CodeHoldExpired))
+
+	// the payer can cancel its expired holds
+	result = invokeAs(stub, alice, "Cancel", "expired2")
+	assert.EqualValues(t, shim.OK, result.Status, "Cancel failed: "+result.Message)
+
+	result = invokeAs(stub, bob, "Delete", "c")
+	assert.EqualValues(t, shim.ERROR, result.Status, "accounts with holds should not be deleted")
+	assert.Contains(t, result.Message, string(CodeHoldsPending))
+
+	putExpiredHold(t, stub, "expired3", "a", "c", "5.00")
+	var expired ExpiredHolds
+	result = invoke(stub, "ExpireHolds", "1")
+	assert.EqualValues(t, shim.OK, result.Status, "ExpireHolds failed: "+result.Message)
+	json.Unmarshal(result.Payload, &expired)
+	assert.Len(t, expired.Expired, 1)
+	assert.False(t, expired.Done)
+
+	result = invoke(stub, "ExpireHolds", "10")
+	json.Unmarshal(result.Payload, &expired)
+	assert.Len(t, expired.Expired, 1)
+	assert.True(t, expired.Done)
+
+	for _, holdID := range []string{"expired1", "expired3"} {
+		result = invoke(stub, "GetHold", holdID)
+		assert.EqualValues(t, shim.ERROR, result.Status, "%s should be deleted", holdID)
+	}
+	result = invoke(stub, "GetHold", "active")
+	assert.EqualValues(t, shim.OK, result.Status, "active holds should not expire")
+
+	result = invokeAs(stub, bob, "Delete", "c")
+	assert.EqualValues(t, shim.OK, result.Status, "Delete failed: "+result.Message)
+}
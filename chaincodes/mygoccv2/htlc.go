@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	htlcLockedEvent   = "HTLCLocked"
+	htlcClaimedEvent  = "HTLCClaimed"
+	htlcRefundedEvent = "HTLCRefunded"
+)
+
+// HTLCEvent is the payload of the HTLCLocked, HTLCClaimed and HTLCRefunded events. A relayer
+// swapping across channels reads the preimage of the HTLCClaimed event and claims the
+// counterpart transfer, with the same hashlock, on the other channel.
+type HTLCEvent struct {
+	ID       string `json:"id"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Amount   string `json:"amount"`
+	Hashlock string `json:"hashlock"`
+	Timeout  string `json:"timeout"`
+	Preimage string `json:"preimage,omitempty"` // hex preimage, set once claimed
+}
+
+// LockHTLC locks amount of the from account in a hashed time-locked transfer to the to account.
+// Anyone presenting the preimage of the hex SHA-256 hashlock claims it before the RFC 3339 timeout,
+// otherwise it is refunded. The locked funds are held like a Hold, only the owner of from can lock
+// them. Returns the ID of the transfer.
+func (t *ABstore) LockHTLC(ctx contractapi.TransactionContextInterface, from string, to string, amount string, hashlock string, timeout string) (string, error) {
+	hash, err := hex.DecodeString(hashlock)
+	if err != nil || len(hash) != sha256.Size {
+		return "", &InvalidArgumentError{Argument: "hashlock", Reason: "expecting a hex SHA-256 hash"}
+	}
+	stub := ctx.GetStub()
+	hold, err := createHold(stub, from, to, amount, timeout, hex.EncodeToString(hash))
+	if err != nil {
+		return "", err
+	}
+	return hold.ID, setEvent(stub, htlcLockedEvent, htlcEvent(hold, ""))
+}
+
+// ClaimHTLC transfers the funds of a hashed time-locked transfer to its recipient, given the hex
+// preimage of the hashlock before the timeout. Anyone knowing the preimage can claim it.
+func (t *ABstore) ClaimHTLC(ctx contractapi.TransactionContextInterface, id string, preimage string) error {
+	stub := ctx.GetStub()
+	hold, err := getHTLC(stub, id)
+	if err != nil {
+		return err
+	}
+	secret, err := hex.DecodeString(preimage)
+	if err != nil {
+		return &InvalidArgumentError{Argument: "preimage", Reason: "expecting a hex string"}
+	}
+	hash := sha256.Sum256(secret)
+	expected, _ := hex.DecodeString(hold.Hashlock)
+	if !bytes.Equal(hash[:], expected) {
+		return &InvalidPreimageError{ID: id}
+	}
+
+	if err := releaseHold(stub, hold); err != nil {
+		return err
+	}
+	// the transaction has a single event, HTLCClaimed replaces the Transfer event
+	return setEvent(stub, htlcClaimedEvent, htlcEvent(*hold, hex.EncodeToString(secret)))
+}
+
+// RefundHTLC ends a hashed time-locked transfer that was not claimed before the timeout, the funds
+// are available again on the sender account. Anyone can refund it, e.g. the relayer.
+func (t *ABstore) RefundHTLC(ctx contractapi.TransactionContextInterface, id string) error {
+	stub := ctx.GetStub()
+	hold, err := getHTLC(stub, id)
+	if err != nil {
+		return err
+	}
+	expired, err := isExpired(stub, hold)
+	if err != nil {
+		return err
+	}
+	if !expired {
+		return &HoldNotExpiredError{HoldID: id, ExpiresAt: hold.ExpiresAt}
+	}
+
+	fmt.Printf("Refunding hashed time-locked transfer %s\n", id)
+	if err := delHold(stub, *hold); err != nil {
+		return err
+	}
+	return setEvent(stub, htlcRefundedEvent, htlcEvent(*hold, ""))
+}
+
+// getHTLC returns the hold of a hashed time-locked transfer
+func getHTLC(stub shim.ChaincodeStubInterface, id string) (*Hold, error) {
+	hold, err := getHold(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	if hold.Hashlock == "" {
+		return nil, &InvalidArgumentError{Argument: "id", Reason: id + " is a hold without hashlock, use Release or Cancel"}
+	}
+	return hold, nil
+}
+
+func htlcEvent(hold Hold, preimage string) HTLCEvent {
+	return HTLCEvent{
+		ID:       hold.ID,
+		From:     hold.From,
+		To:       hold.To,
+		Amount:   hold.Amount,
+		Hashlock: hold.Hashlock,
+		Timeout:  hold.ExpiresAt,
+		Preimage: preimage,
+	}
+}
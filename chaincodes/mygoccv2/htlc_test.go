@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPreimage = "736563726574" // "secret"
+
+func testHashlock() string {
+	hash := sha256.Sum256([]byte("secret"))
+	return hex.EncodeToString(hash[:])
+}
+
+// lockAs locks a hashed time-locked transfer in the transaction txID, the ID of the transfer
+func lockAs(stub *shimtest.MockStub, identity []byte, txID string, args ...string) pb.Response {
+	stub.Creator = identity
+	invokeArgs := [][]byte{[]byte("LockHTLC")}
+	for _, arg := range args {
+		invokeArgs = append(invokeArgs, []byte(arg))
+	}
+	return stub.MockInvoke(txID, invokeArgs)
+}
+
+func checkHTLCEvent(t *testing.T, stub *shimtest.MockStub, name string) HTLCEvent {
+	event := lastEvent(stub)
+	var payload HTLCEvent
+	if assert.NotNil(t, event, "%s event expected", name) {
+		assert.Equal(t, name, event.EventName)
+		json.Unmarshal(event.Payload, &payload)
+	}
+	return payload
+}
+
+func TestLockAndClaimHTLC(t *testing.T) {
+	alice, bob, _ := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+
+	result := lockAs(stub, alice, "htlc1", "a", "b", "30", testHashlock(), inAnHour())
+	assert.EqualValues(t, shim.OK, result.Status, "LockHTLC failed: "+result.Message)
+	assert.Equal(t, "htlc1", string(result.Payload))
+	locked := checkHTLCEvent(t, stub, "HTLCLocked")
+	assert.Equal(t, HTLCEvent{ID: "htlc1", From: "a", To: "b", Amount: "30.00", Hashlock: testHashlock(), Timeout: locked.Timeout}, locked)
+	checkAvailable(t, stub, "a", "30.00", "70.00")
+
+	// the hashlock is enforced by ClaimHTLC only
+	result = invokeAs(stub, alice, "Release", "htlc1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "HTLCs should not be released")
+	result = invokeAs(stub, alice, "Cancel", "htlc1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "HTLCs should not be cancelled")
+
+	result = invokeAs(stub, bob, "ClaimHTLC", "htlc1", hex.EncodeToString([]byte("guess")))
+	assert.EqualValues(t, shim.ERROR, result.Status, "a wrong preimage should be rejected")
+	assert.Contains(t, result.Message, string(CodeInvalidPreimage))
+	result = invokeAs(stub, bob, "ClaimHTLC", "htlc1", "not hex")
+	assert.EqualValues(t, shim.ERROR, result.Status, "a malformed preimage should be rejected")
+	result = invokeAs(stub, bob, "RefundHTLC", "htlc1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "HTLCs should not be refunded before the timeout")
+	assert.Contains(t, result.Message, string(CodeHoldNotExpired))
+
+	// anyone knowing the preimage claims the transfer for the recipient
+	result = invokeAs(stub, bob, "ClaimHTLC", "htlc1", testPreimage)
+	assert.EqualValues(t, shim.OK, result.Status, "ClaimHTLC failed: "+result.Message)
+	checkBalance(t, stub, "a", "70.00")
+	checkBalance(t, stub, "b", "30.00")
+	claimed := checkHTLCEvent(t, stub, "HTLCClaimed")
+	assert.Equal(t, testPreimage, claimed.Preimage)
+	assert.Equal(t, "b", claimed.To)
+
+	result = invokeAs(stub, bob, "ClaimHTLC", "htlc1", testPreimage)
+	assert.EqualValues(t, shim.ERROR, result.Status, "HTLCs should be claimed once")
+	assert.Contains(t, result.Message, string(CodeHoldNotFound))
+}
+
+func TestLockHTLCErrors(t *testing.T) {
+	alice, bob, _ := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+
+	for _, test := range []struct {
+		identity []byte
+		args     []string
+		code     ErrorCode
+	}{
+		{alice, []string{"a", "b", "10", "abcd", inAnHour()}, CodeInvalidArgument},
+		{alice, []string{"a", "b", "10", testHashlock(), "2000-01-01T00:00:00Z"}, CodeInvalidArgument},
+		{alice, []string{"a", "b", "100.01", testHashlock(), inAnHour()}, CodeInsufficientFunds},
+		{bob, []string{"a", "b", "10", testHashlock(), inAnHour()}, CodeNotAccountOwner},
+	} {
+		result := lockAs(stub, test.identity, "htlc", test.args...)
+		assert.EqualValues(t, shim.ERROR, result.Status, "LockHTLC %v should fail", test.args)
+		assert.Contains(t, result.Message, string(test.code), "LockHTLC %v", test.args)
+	}
+
+	holdAs(stub, alice, "hold1", "a", "b", "10", inAnHour())
+	result := invokeAs(stub, bob, "ClaimHTLC", "hold1", testPreimage)
+	assert.EqualValues(t, shim.ERROR, result.Status, "holds without hashlock should not be claimed")
+}
+
+func TestRefundHTLC(t *testing.T) {
+	alice, bob, _ := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+
+	stub.MockTransactionStart("htlc1")
+	err := putHold(stub, Hold{ID: "htlc1", From: "a", To: "b", Amount: "30.00", Hashlock: testHashlock(),
+		ExpiresAt: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)})
+	stub.MockTransactionEnd("htlc1")
+	assert.Nil(t, err)
+	checkAvailable(t, stub, "a", "0.00", "100.00")
+
+	result := invokeAs(stub, bob, "ClaimHTLC", "htlc1", testPreimage)
+	assert.EqualValues(t, shim.ERROR, result.Status, "HTLCs should not be claimed after the timeout")
+	assert.Contains(t, result.Message, string(CodeHoldExpired))
+
+	// expired HTLCs are left to RefundHTLC
+	result = invoke(stub, "ExpireHolds", "10")
+	assert.EqualValues(t, shim.OK, result.Status, "ExpireHolds failed: "+result.Message)
+	assert.JSONEq(t, `{"expired":[],"done":true}`, string(result.Payload))
+
+	result = invokeAs(stub, bob, "RefundHTLC", "htlc1")
+	assert.EqualValues(t, shim.OK, result.Status, "RefundHTLC failed: "+result.Message)
+	refunded := checkHTLCEvent(t, stub, "HTLCRefunded")
+	assert.Equal(t, "htlc1", refunded.ID)
+	assert.Empty(t, refunded.Preimage)
+	checkBalance(t, stub, "a", "100.00")
+
+	result = invoke(stub, "GetHold", "htlc1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "refunded HTLCs should be deleted")
+}
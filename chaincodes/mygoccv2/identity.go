@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"schema"
+)
+
+const (
+	accountOwnerObjectType = "accountOwner"
+	roleMSPsObjectType     = "roleMSPs"
+	// enrollmentIDAttribute is set by the Fabric CA in the enrollment certificates
+	enrollmentIDAttribute = "hf.EnrollmentID"
+	// roleAttribute is the certificate attribute listing the ABstore roles of a client, separated
+	// by commas, e.g. abstore.role=admin:ecert or abstore.role=admin,minter:ecert
+	roleAttribute = "abstore.role"
+	adminRole     = "admin"
+)
+
+// roles are the ABstore roles, each one held by the clients of its MSPs carrying it in roleAttribute
+var roles = []string{adminRole, minterRole}
+
+// RoleMSPs lists the MSPs whose clients may hold a role
+type RoleMSPs struct {
+	Role   string   `json:"role"`
+	MSPIDs []string `json:"mspIds"`
+}
+
+// AccountOwner is the client identity an account is bound to, only the owner can debit the account
+type AccountOwner struct {
+	MSPID string `json:"mspId"`
+	ID    string `json:"id"` // enrollment ID, or certificate subject without enrollment ID attribute
+}
+
+// CreateAccount creates an empty account bound to the client identity
+func (t *ABstore) CreateAccount(ctx contractapi.TransactionContextInterface, account string) error {
+	if account == "" {
+		return &InvalidArgumentError{Argument: "account", Reason: "must be a non-empty string"}
+	}
+	stub := ctx.GetStub()
+	owner, err := clientOwner(stub)
+	if err != nil {
+		return err
+	}
+	if _, err := getBalance(stub, account); err == nil {
+		return &AccountExistsError{Account: account}
+	} else if _, notFound := err.(*AccountNotFoundError); !notFound {
+		return err
+	}
+
+	fmt.Printf("Creating account %s for %s %s\n", account, owner.MSPID, owner.ID)
+	if err := putBalance(stub, account, new(big.Int)); err != nil {
+		return err
+	}
+	return putAccountOwner(stub, account, owner)
+}
+
+// GetAccountOwner returns the identity an account is bound to, empty for an unbound account
+func (t *ABstore) GetAccountOwner(ctx contractapi.TransactionContextInterface, account string) (AccountOwner, error) {
+	if _, err := getBalance(ctx.GetStub(), account); err != nil {
+		return AccountOwner{}, err
+	}
+	owner, err := getAccountOwner(ctx.GetStub(), account)
+	if err != nil || owner == nil {
+		return AccountOwner{}, err
+	}
+	return *owner, nil
+}
+
+// TransferAccountOwnership binds an account to another identity, the owner or an admin can
+// transfer it. Accounts without owner, created before the binding, are bound by an admin.
+func (t *ABstore) TransferAccountOwnership(ctx contractapi.TransactionContextInterface, account string, mspID string, id string) error {
+	if mspID == "" || id == "" {
+		return &InvalidArgumentError{Argument: "owner", Reason: "mspID and id must be non-empty strings"}
+	}
+	return rebindAccount(ctx.GetStub(), account, AccountOwner{MSPID: mspID, ID: id})
+}
+
+// RotateAccountBinding changes the identity of the owner within its MSP, e.g. after a
+// re-enrollment under another enrollment ID. The owner or an admin can rotate it.
+func (t *ABstore) RotateAccountBinding(ctx contractapi.TransactionContextInterface, account string, id string) error {
+	if id == "" {
+		return &InvalidArgumentError{Argument: "id", Reason: "must be a non-empty string"}
+	}
+	owner, err := getAccountOwner(ctx.GetStub(), account)
+	if err != nil {
+		return err
+	}
+	if owner == nil {
+		return &InvalidArgumentError{Argument: "account", Reason: account + " is not bound, use TransferAccountOwnership"}
+	}
+	return rebindAccount(ctx.GetStub(), account, AccountOwner{MSPID: owner.MSPID, ID: id})
+}
+
+func rebindAccount(stub shim.ChaincodeStubInterface, account string, newOwner AccountOwner) error {
+	if _, err := getBalance(stub, account); err != nil {
+		return err
+	}
+	if err := checkOwnerOrAdmin(stub, account); err != nil {
+		return err
+	}
+	fmt.Printf("Binding account %s to %s %s\n", account, newOwner.MSPID, newOwner.ID)
+	return putAccountOwner(stub, account, newOwner)
+}
+
+// clientOwner returns the identity of the client
+func clientOwner(stub shim.ChaincodeStubInterface) (AccountOwner, error) {
+	owner := AccountOwner{}
+	client, err := cid.New(stub)
+	if err != nil {
+		return owner, fmt.Errorf("Unable to identify the client: %s", err.Error())
+	}
+	owner.MSPID, err = client.GetMSPID()
+	if err != nil {
+		return owner, err
+	}
+	enrollmentID, found, err := client.GetAttributeValue(enrollmentIDAttribute)
+	if err != nil {
+		return owner, err
+	}
+	if found && enrollmentID != "" {
+		owner.ID = enrollmentID
+		return owner, nil
+	}
+	cert, err := client.GetX509Certificate()
+	if err != nil {
+		return owner, err
+	}
+	if cert == nil {
+		return owner, fmt.Errorf("Unable to identify the client: no enrollment ID nor certificate")
+	}
+	owner.ID = cert.Subject.String()
+	return owner, nil
+}
+
+// SetRoleMSPs replaces the MSPs whose clients may hold a role, only admins can set them.
+// Admins cannot remove their own MSP from the admin MSPs.
+func (t *ABstore) SetRoleMSPs(ctx contractapi.TransactionContextInterface, role string, mspIDs []string) error {
+	if !isRole(role) {
+		return &InvalidArgumentError{Argument: "role", Reason: fmt.Sprintf("must be one of %s", strings.Join(roles, ", "))}
+	}
+	if err := checkMSPIDs("mspIDs", mspIDs); err != nil {
+		return err
+	}
+	stub := ctx.GetStub()
+	if err := checkRole(stub, adminRole); err != nil {
+		return err
+	}
+	if role == adminRole {
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return err
+		}
+		if !containsString(mspIDs, mspID) {
+			return &InvalidArgumentError{Argument: "mspIDs", Reason: "must keep the MSP of the client, " + mspID}
+		}
+	}
+	fmt.Printf("Granting the %s role to the clients of %v\n", role, mspIDs)
+	return putRoleMSPs(stub, role, mspIDs)
+}
+
+// GetRoleMSPs returns the MSPs whose clients may hold a role
+func (t *ABstore) GetRoleMSPs(ctx contractapi.TransactionContextInterface, role string) (RoleMSPs, error) {
+	if !isRole(role) {
+		return RoleMSPs{}, &InvalidArgumentError{Argument: "role", Reason: fmt.Sprintf("must be one of %s", strings.Join(roles, ", "))}
+	}
+	mspIDs, err := getRoleMSPs(ctx.GetStub(), role)
+	if err != nil {
+		return RoleMSPs{}, err
+	}
+	return RoleMSPs{Role: role, MSPIDs: mspIDs}, nil
+}
+
+// checkRole returns a MissingRoleError unless the client carries the role in its certificate
+// and belongs to one of the MSPs of the role
+func checkRole(stub shim.ChaincodeStubInterface, role string) error {
+	client, err := cid.New(stub)
+	if err != nil {
+		return fmt.Errorf("Unable to identify the client: %s", err.Error())
+	}
+	value, found, err := client.GetAttributeValue(roleAttribute)
+	if err != nil {
+		return err
+	}
+	if !found || !containsString(strings.Split(value, ","), role) {
+		return &MissingRoleError{Role: role}
+	}
+	mspID, err := client.GetMSPID()
+	if err != nil {
+		return err
+	}
+	mspIDs, err := getRoleMSPs(stub, role)
+	if err != nil {
+		return err
+	}
+	if !containsString(mspIDs, mspID) {
+		return &MissingRoleError{Role: role}
+	}
+	return nil
+}
+
+func isAdmin(stub shim.ChaincodeStubInterface) (bool, error) {
+	err := checkRole(stub, adminRole)
+	if _, missing := err.(*MissingRoleError); missing {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func isRole(role string) bool {
+	return containsString(roles, role)
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if strings.TrimSpace(item) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMSPIDs validates the MSPs of a role given as argument
+func checkMSPIDs(argument string, mspIDs []string) error {
+	if len(mspIDs) == 0 {
+		return &InvalidArgumentError{Argument: argument, Reason: "must list at least one MSP ID"}
+	}
+	for _, mspID := range mspIDs {
+		if mspID == "" {
+			return &InvalidArgumentError{Argument: argument, Reason: "must be non-empty strings"}
+		}
+	}
+	return nil
+}
+
+// initRoleMSPs grants the roles to the clients of the MSPs given to Init or Migrate, the roles
+// are never granted to the MSP of the client running them
+func initRoleMSPs(stub shim.ChaincodeStubInterface, adminMSPs []string, minterMSPs []string) error {
+	if err := checkMSPIDs("adminMSPs", adminMSPs); err != nil {
+		return err
+	}
+	if err := checkMSPIDs("minterMSPs", minterMSPs); err != nil {
+		return err
+	}
+	roleMSPs := map[string][]string{adminRole: adminMSPs, minterRole: minterMSPs}
+	for _, role := range roles {
+		mspIDs := roleMSPs[role]
+		fmt.Printf("Granting the %s role to the clients of %v\n", role, mspIDs)
+		if err := putRoleMSPs(stub, role, mspIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roleMSPsMigration returns the step granting the roles to the MSPs given to Migrate, on ledgers
+// initialised before the roles were bound to MSPs
+func roleMSPsMigration(adminMSPs []string, minterMSPs []string) schema.Step {
+	return func(stub shim.ChaincodeStubInterface, resumeKey string, limit int) (string, int, error) {
+		if err := initRoleMSPs(stub, adminMSPs, minterMSPs); err != nil {
+			return "", 0, err
+		}
+		return "", len(roles), nil
+	}
+}
+
+// checkMigrator lets the admins run the migrations once the admin MSPs are recorded, before that
+// the ledger has no admin and the migrations need no role
+func checkMigrator(stub shim.ChaincodeStubInterface) error {
+	adminMSPs, err := getRoleMSPs(stub, adminRole)
+	if err != nil || len(adminMSPs) == 0 {
+		return err
+	}
+	return checkRole(stub, adminRole)
+}
+
+func roleMSPsKey(stub shim.ChaincodeStubInterface, role string) (string, error) {
+	return stub.CreateCompositeKey(roleMSPsObjectType, []string{role})
+}
+
+// getRoleMSPs returns the MSPs of a role, none until Init or the role MSPs migration
+func getRoleMSPs(stub shim.ChaincodeStubInterface, role string) ([]string, error) {
+	key, err := roleMSPsKey(stub, role)
+	if err != nil {
+		return nil, err
+	}
+	mspIDsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, &InternalError{Reason: "Failed to get state"}
+	}
+	mspIDs := make([]string, 0)
+	if mspIDsBytes == nil {
+		return mspIDs, nil
+	}
+	if err := json.Unmarshal(mspIDsBytes, &mspIDs); err != nil {
+		return nil, &CorruptedStateError{Object: fmt.Sprintf("MSPs of the %s role", role)}
+	}
+	return mspIDs, nil
+}
+
+func putRoleMSPs(stub shim.ChaincodeStubInterface, role string, mspIDs []string) error {
+	key, err := roleMSPsKey(stub, role)
+	if err != nil {
+		return err
+	}
+	mspIDsBytes, err := json.Marshal(mspIDs)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, mspIDsBytes)
+}
+
+// checkOwner returns an error unless the client is the owner of the account
+func checkOwner(stub shim.ChaincodeStubInterface, account string) error {
+	owner, err := getAccountOwner(stub, account)
+	if err != nil {
+		return err
+	}
+	client, err := clientOwner(stub)
+	if err != nil {
+		return err
+	}
+	if owner == nil || *owner != client {
+		return &NotAccountOwnerError{Account: account}
+	}
+	return nil
+}
+
+func checkOwnerOrAdmin(stub shim.ChaincodeStubInterface, account string) error {
+	admin, err := isAdmin(stub)
+	if err != nil || admin {
+		return err
+	}
+	return checkOwner(stub, account)
+}
+
+func accountOwnerKey(stub shim.ChaincodeStubInterface, account string) (string, error) {
+	return stub.CreateCompositeKey(accountOwnerObjectType, []string{account})
+}
+
+func getAccountOwner(stub shim.ChaincodeStubInterface, account string) (*AccountOwner, error) {
+	ownerKey, err := accountOwnerKey(stub, account)
+	if err != nil {
+		return nil, err
+	}
+	ownerBytes, err := stub.GetState(ownerKey)
+	if err != nil {
+		return nil, &InternalError{Reason: "Failed to get state"}
+	}
+	if ownerBytes == nil {
+		return nil, nil
+	}
+	owner := &AccountOwner{}
+	if err := json.Unmarshal(ownerBytes, owner); err != nil {
+		return nil, &CorruptedStateError{Object: "owner of " + account}
+	}
+	return owner, nil
+}
+
+func putAccountOwner(stub shim.ChaincodeStubInterface, account string, owner AccountOwner) error {
+	ownerKey, err := accountOwnerKey(stub, account)
+	if err != nil {
+		return err
+	}
+	ownerBytes, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(ownerKey, ownerBytes)
+}
+
+func delAccountOwner(stub shim.ChaincodeStubInterface, account string) error {
+	ownerKey, err := accountOwnerKey(stub, account)
+	if err != nil {
+		return err
+	}
+	return stub.DelState(ownerKey)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// attributesOID is the certificate extension holding the Fabric CA attributes
+var attributesOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// newIdentity returns a serialized client identity with a self signed certificate carrying attrs
+func newIdentity(t *testing.T, mspID string, commonName string, attrs map[string]string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{mspID}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if attrs != nil {
+		attrsJSON, _ := json.Marshal(map[string]interface{}{"attrs": attrs})
+		template.ExtraExtensions = []pkix.Extension{{Id: attributesOID, Value: attrsJSON}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	identity, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	assert.Nil(t, err)
+	return identity
+}
+
+var (
+	aliceIdentity []byte
+	bobIdentity   []byte
+	adminIdentity []byte
+)
+
+// org1MSPs grants a role to the clients of Org1MSP in Init and Migrate
+const org1MSPs = `["Org1MSP"]`
+
+// identities returns the test identities: alice and bob enrolled in Org1MSP, and an Org1MSP admin.
+// The test ledgers grant the roles to Org1MSP.
+func identities(t *testing.T) ([]byte, []byte, []byte) {
+	if aliceIdentity == nil {
+		aliceIdentity = newIdentity(t, "Org1MSP", "alice", map[string]string{enrollmentIDAttribute: "alice"})
+		bobIdentity = newIdentity(t, "Org1MSP", "bob", map[string]string{enrollmentIDAttribute: "bob"})
+		adminIdentity = newIdentity(t, "Org1MSP", "admin", map[string]string{enrollmentIDAttribute: "admin", roleAttribute: adminRole})
+	}
+	return aliceIdentity, bobIdentity, adminIdentity
+}
+
+func invokeAs(stub *shimtest.MockStub, identity []byte, function string, args ...string) pb.Response {
+	stub.Creator = identity
+	return invoke(stub, function, args...)
+}
+
+func getOwner(t *testing.T, stub *shimtest.MockStub, account string) AccountOwner {
+	result := invoke(stub, "GetAccountOwner", account)
+	assert.EqualValues(t, shim.OK, result.Status, "GetAccountOwner failed: "+result.Message)
+	var owner AccountOwner
+	json.Unmarshal(result.Payload, &owner)
+	return owner
+}
+
+func TestCreateAccount(t *testing.T) {
+	alice, bob, _ := identities(t)
+	stub := newABstoreStub(t)
+
+	result := invokeAs(stub, alice, "CreateAccount", "alice-account")
+	assert.EqualValues(t, shim.OK, result.Status, "CreateAccount failed: "+result.Message)
+	checkBalance(t, stub, "alice-account", "0.00")
+	assert.Equal(t, AccountOwner{MSPID: "Org1MSP", ID: "alice"}, getOwner(t, stub, "alice-account"))
+
+	result = invokeAs(stub, bob, "CreateAccount", "alice-account")
+	assert.EqualValues(t, shim.ERROR, result.Status, "existing accounts should not be rebound")
+	assert.Contains(t, result.Message, "already exists")
+
+	// without enrollment ID the certificate subject identifies the client
+	result = invokeAs(stub, newIdentity(t, "Org3MSP", "carol", nil), "CreateAccount", "carol-account")
+	assert.EqualValues(t, shim.OK, result.Status, "CreateAccount failed: "+result.Message)
+	assert.Equal(t, AccountOwner{MSPID: "Org3MSP", ID: "CN=carol,O=Org3MSP"}, getOwner(t, stub, "carol-account"))
+}
+
+func TestOnlyOwnerDebits(t *testing.T) {
+	alice, bob, admin := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	invokeAs(stub, bob, "CreateAccount", "c")
+
+	for _, identity := range [][]byte{bob, admin} {
+		result := invokeAs(stub, identity, "Invoke", "a", "c", "10")
+		assert.EqualValues(t, shim.ERROR, result.Status, "only the owner should debit a")
+		assert.Contains(t, result.Message, "not the owner of a")
+	}
+
+	result := invokeAs(stub, alice, "Invoke", "a", "c", "10")
+	assert.EqualValues(t, shim.OK, result.Status, "Invoke failed: "+result.Message)
+	result = invokeAs(stub, bob, "Invoke", "c", "a", "4")
+	assert.EqualValues(t, shim.OK, result.Status, "Invoke failed: "+result.Message)
+	checkBalance(t, stub, "a", "94.00")
+	checkBalance(t, stub, "c", "6.00")
+
+	result = invokeAs(stub, bob, "Delete", "a")
+	assert.EqualValues(t, shim.ERROR, result.Status, "only the owner or an admin should delete a")
+	result = invokeAs(stub, admin, "Delete", "a")
+	assert.EqualValues(t, shim.ERROR, result.Status, "accounts with a balance should not be deleted")
+	assert.Contains(t, result.Message, string(CodeAccountNotEmpty))
+	invokeAs(stub, alice, "Invoke", "a", "b", "94")
+	result = invokeAs(stub, admin, "Delete", "a")
+	assert.EqualValues(t, shim.OK, result.Status, "Delete failed: "+result.Message)
+}
+
+func TestRoleMSPs(t *testing.T) {
+	alice, _, admin := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	otherAdmin := newIdentity(t, "Org2MSP", "admin2", map[string]string{enrollmentIDAttribute: "admin2", roleAttribute: adminRole})
+	adminMinter := newIdentity(t, "Org1MSP", "ops", map[string]string{enrollmentIDAttribute: "ops", roleAttribute: "admin, minter"})
+
+	result := invokeAs(stub, alice, "GetRoleMSPs", "minter")
+	assert.JSONEq(t, `{"role":"minter","mspIds":["Org1MSP"]}`, string(result.Payload), "Init should grant the roles to the MSPs given")
+
+	// the role attribute is not enough outside the MSPs of the role
+	result = invokeAs(stub, otherAdmin, "CreateAccount", "treasury")
+	assert.EqualValues(t, shim.OK, result.Status, "CreateAccount failed: "+result.Message)
+	result = invokeAs(stub, otherAdmin, "SetFeeSchedule", "ABS", "0", "0", "0", "", "")
+	assert.EqualValues(t, shim.ERROR, result.Status, "admins of other MSPs should be refused")
+	assert.Contains(t, result.Message, string(CodeMissingRole))
+	result = invokeAs(stub, otherAdmin, "SetRoleMSPs", "admin", `["Org2MSP"]`)
+	assert.EqualValues(t, shim.ERROR, result.Status, "admins of other MSPs should not grant themselves the role")
+
+	result = invokeAs(stub, admin, "SetRoleMSPs", "admin", `["Org2MSP"]`)
+	assert.EqualValues(t, shim.ERROR, result.Status, "admins should keep their own MSP")
+	result = invokeAs(stub, admin, "SetRoleMSPs", "auditor", `["Org2MSP"]`)
+	assert.EqualValues(t, shim.ERROR, result.Status, "unknown roles should be refused")
+	result = invokeAs(stub, admin, "SetRoleMSPs", "admin", `["Org1MSP","Org2MSP"]`)
+	assert.EqualValues(t, shim.OK, result.Status, "SetRoleMSPs failed: "+result.Message)
+	result = invokeAs(stub, otherAdmin, "Delete", "b")
+	assert.EqualValues(t, shim.OK, result.Status, "Delete failed: "+result.Message)
+
+	// one identity holds both roles with a list-valued attribute
+	result = invokeAs(stub, adminMinter, "Mint", "a", "1")
+	assert.EqualValues(t, shim.OK, result.Status, "Mint failed: "+result.Message)
+	result = invokeAs(stub, adminMinter, "SetRoleMSPs", "minter", `["Org2MSP"]`)
+	assert.EqualValues(t, shim.OK, result.Status, "SetRoleMSPs failed: "+result.Message)
+	result = invokeAs(stub, adminMinter, "Mint", "a", "1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "minters outside the minter MSPs should be refused")
+	assert.Contains(t, result.Message, string(CodeMissingRole))
+}
+
+func TestTransferAccountOwnership(t *testing.T) {
+	alice, bob, admin := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+
+	result := invokeAs(stub, bob, "TransferAccountOwnership", "a", "Org1MSP", "bob")
+	assert.EqualValues(t, shim.ERROR, result.Status, "bob should not take a over")
+
+	result = invokeAs(stub, alice, "TransferAccountOwnership", "a", "Org1MSP", "bob")
+	assert.EqualValues(t, shim.OK, result.Status, "TransferAccountOwnership failed: "+result.Message)
+	assert.Equal(t, AccountOwner{MSPID: "Org1MSP", ID: "bob"}, getOwner(t, stub, "a"))
+	result = invokeAs(stub, alice, "Invoke", "a", "b", "1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "alice is no longer the owner of a")
+	result = invokeAs(stub, bob, "Invoke", "a", "b", "1")
+	assert.EqualValues(t, shim.OK, result.Status, "Invoke failed: "+result.Message)
+
+	// the admin rotates the binding after bob re-enrolled as bob2
+	result = invokeAs(stub, admin, "RotateAccountBinding", "a", "bob2")
+	assert.EqualValues(t, shim.OK, result.Status, "RotateAccountBinding failed: "+result.Message)
+	assert.Equal(t, AccountOwner{MSPID: "Org1MSP", ID: "bob2"}, getOwner(t, stub, "a"))
+	result = invokeAs(stub, bob, "RotateAccountBinding", "a", "bob")
+	assert.EqualValues(t, shim.ERROR, result.Status, "bob is no longer the owner of a")
+}
+
+func TestUnboundAccounts(t *testing.T) {
+	alice, _, admin := identities(t)
+	stub := newABstoreStub(t)
+	stub.MockTransactionStart("1")
+	stub.PutState("legacy", []byte("50"))
+	stub.MockTransactionEnd("1")
+	result := migrateAll(t, stub, "10")
+	assert.EqualValues(t, shim.OK, result.Status, "Migrate failed: "+result.Message)
+	invokeAs(stub, alice, "CreateAccount", "b")
+
+	assert.Equal(t, AccountOwner{}, getOwner(t, stub, "legacy"))
+	result = invokeAs(stub, alice, "Invoke", "legacy", "b", "1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "unbound accounts should not be debited")
+	result = invokeAs(stub, alice, "TransferAccountOwnership", "legacy", "Org1MSP", "alice")
+	assert.EqualValues(t, shim.ERROR, result.Status, "only an admin should bind an unbound account")
+
+	result = invokeAs(stub, admin, "TransferAccountOwnership", "legacy", "Org1MSP", "alice")
+	assert.EqualValues(t, shim.OK, result.Status, "TransferAccountOwnership failed: "+result.Message)
+	result = invokeAs(stub, alice, "Invoke", "legacy", "b", "1")
+	assert.EqualValues(t, shim.OK, result.Status, "Invoke failed: "+result.Message)
+}
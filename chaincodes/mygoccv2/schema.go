@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"schema"
+)
+
+// roleMSPsVersion is the schema version recording the role MSPs, its step takes them from Migrate
+const roleMSPsVersion = 5
+
+// migrations is the ordered registry of the schema migrations, migrations[i] upgrades to version i+1.
+// The Version of the last one is the schema version of this chaincode.
+var migrations = []schema.Migration{
+	{Version: 1, Description: "Versioned schema", Step: schema.Noop},
+	{Version: 2, Description: "Fixed scale decimal balances", Step: schema.Range(decimalBalancesMigration)},
+	{Version: 3, Description: "Total supply", Step: totalSupplyMigration},
+	{Version: 4, Description: "Asset balances", Step: assetBalancesMigration},
+	{Version: roleMSPsVersion, Description: "Role MSPs", Step: roleMSPsMigration(nil, nil)},
+	{Version: 6, Description: "Transfer records by asset", Step: transferRecordsMigration},
+}
+
+type SchemaVersion struct {
+	Version   int    `json:"version"`                        // schema version of the ledger, 0 before versioning
+	Latest    int    `json:"latest"`                         // schema version of the chaincode
+	ResumeKey string `json:"resumeKey" metadata:",optional"` // key the running migration resumes from
+}
+
+type MigrationResult struct {
+	SchemaVersion
+	Processed int  `json:"processed"` // records processed by this call
+	Done      bool `json:"done"`      // the ledger is at the latest schema version
+}
+
+// GetSchemaVersion returns the schema version of the ledger and of the chaincode
+func (t *ABstore) GetSchemaVersion(ctx contractapi.TransactionContextInterface) (SchemaVersion, error) {
+	return loadSchemaState(ctx.GetStub())
+}
+
+// Migrate runs the pending migration over at most limit records and saves where it stopped. A
+// transaction migrates to one version at most, call it again until done. Once the role MSPs are
+// recorded only the admins may migrate. The admin and minter MSPs are recorded by the role MSPs
+// migration and ignored by the others.
+func (t *ABstore) Migrate(ctx contractapi.TransactionContextInterface, limit int, adminMSPs []string, minterMSPs []string) (MigrationResult, error) {
+	result := MigrationResult{}
+	if limit <= 0 {
+		return result, &InvalidArgumentError{Argument: "limit", Reason: "must be a positive integer"}
+	}
+	if err := checkMigrator(ctx.GetStub()); err != nil {
+		return result, err
+	}
+
+	registry := make([]schema.Migration, len(migrations))
+	copy(registry, migrations)
+	if len(registry) >= roleMSPsVersion {
+		registry[roleMSPsVersion-1].Step = roleMSPsMigration(adminMSPs, minterMSPs)
+	}
+	state, processed, err := schema.Migrate(ctx.GetStub(), registry, limit)
+	if err != nil {
+		return result, schemaError(err)
+	}
+	result.SchemaVersion = SchemaVersion{Version: state.Version, Latest: latestSchemaVersion(), ResumeKey: state.ResumeKey}
+	result.Processed = processed
+	result.Done = state.Version == result.Latest
+	return result, nil
+}
+
+func latestSchemaVersion() int {
+	return schema.Latest(migrations)
+}
+
+// checkSchemaVersion refuses to run a chaincode older than the ledger it finds
+func checkSchemaVersion(stub shim.ChaincodeStubInterface) error {
+	return schema.Check(stub, migrations)
+}
+
+func loadSchemaVersion(stub shim.ChaincodeStubInterface) (int, error) {
+	version, err := schema.LoadVersion(stub)
+	return version, schemaError(err)
+}
+
+func loadSchemaState(stub shim.ChaincodeStubInterface) (SchemaVersion, error) {
+	state, err := schema.LoadState(stub)
+	return SchemaVersion{Version: state.Version, Latest: latestSchemaVersion(), ResumeKey: state.ResumeKey}, schemaError(err)
+}
+
+func saveSchemaState(stub shim.ChaincodeStubInterface, state SchemaVersion) error {
+	return schema.SaveState(stub, schema.State{Version: state.Version, ResumeKey: state.ResumeKey})
+}
+
+// schemaError gives the errors of the schema package their code; a failed step keeps the code
+// of the error of its record
+func schemaError(err error) error {
+	var malformed *schema.MalformedVersionError
+	var newer *schema.NewerVersionError
+	var step *schema.StepError
+	var coded CodedError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &step):
+		if errors.As(step.Err, &coded) {
+			return &MigrationFailedError{Version: step.Version, Err: coded}
+		}
+		return &MigrationFailedError{Version: step.Version, Err: step.Err}
+	case errors.As(err, &malformed):
+		return &CorruptedStateError{Object: "schema version " + malformed.Value}
+	case errors.As(err, &newer):
+		return &InvalidArgumentError{Argument: "schema version", Reason: fmt.Sprintf("the ledger schema version %d is newer than the chaincode schema version %d", newer.Version, newer.Latest)}
+	case errors.As(err, &coded):
+		return err
+	}
+	return &InternalError{Reason: "Failed to access the schema state"}
+}
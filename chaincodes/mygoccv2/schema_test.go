@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+
+	"mangostub"
+	"schema"
+)
+
+func newABstoreStub(t *testing.T) *shimtest.MockStub {
+	cc, err := contractapi.NewChaincode(new(ABstore))
+	assert.Nil(t, err, "ABstore chaincode creation failed")
+	// the mangostub MockStub serves the paginated queries the shimtest one leaves unimplemented
+	stub := mangostub.NewMockStub("mockABstore", cc).MockStub
+	stub.Creator, _, _ = identities(t)
+	return stub
+}
+
+func invoke(stub *shimtest.MockStub, function string, args ...string) pb.Response {
+	invokeArgs := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		invokeArgs = append(invokeArgs, []byte(arg))
+	}
+	return stub.MockInvoke("1", invokeArgs)
+}
+
+// useMigrations replaces the migration registry and returns the function restoring it
+func useMigrations(registry []schema.Migration) func() {
+	saved := migrations
+	migrations = registry
+	return func() { migrations = saved }
+}
+
+// migrateAll calls Migrate as the admin until the ledger is migrated or a call fails, each in a
+// transaction of its own as a client would, and returns the last response. The client is restored.
+func migrateAll(t *testing.T, stub *shimtest.MockStub, limit string) pb.Response {
+	_, _, admin := identities(t)
+	client := stub.Creator
+	defer func() { stub.Creator = client }()
+	stub.Creator = admin
+	for {
+		result := invoke(stub, "Migrate", limit, org1MSPs, org1MSPs)
+		var migrationResult MigrationResult
+		if result.Status != shim.OK || json.Unmarshal(result.Payload, &migrationResult) != nil || migrationResult.Done {
+			return result
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	defer useMigrations([]schema.Migration{
+		{Version: 1, Description: "Versioned schema", Step: schema.Noop},
+		{Version: 2, Description: "Prefix balances", Step: schema.Range(func(stub shim.ChaincodeStubInterface, key string, value []byte) error {
+			if strings.HasPrefix(string(value), "v2:") {
+				return nil
+			}
+			return stub.PutState(key, []byte("v2:"+string(value)))
+		})},
+	})()
+	stub := newABstoreStub(t)
+	stub.MockTransactionStart("1")
+	stub.PutState("a", []byte("100.00"))
+	stub.PutState("b", []byte("200.00"))
+	stub.MockTransactionEnd("1")
+
+	result := invoke(stub, "GetSchemaVersion")
+	assert.EqualValues(t, shim.OK, result.Status, "GetSchemaVersion failed: "+result.Message)
+	assert.JSONEq(t, `{"version":0,"latest":2,"resumeKey":""}`, string(result.Payload))
+
+	// a transaction migrates to one version at most
+	result = invoke(stub, "Migrate", "1", org1MSPs, org1MSPs)
+	assert.EqualValues(t, shim.OK, result.Status, "Migrate failed: "+result.Message)
+	var migrationResult MigrationResult
+	json.Unmarshal(result.Payload, &migrationResult)
+	assert.Equal(t, 1, migrationResult.Version)
+	assert.Empty(t, migrationResult.ResumeKey)
+	assert.False(t, migrationResult.Done)
+
+	result = invoke(stub, "Migrate", "1", org1MSPs, org1MSPs)
+	assert.EqualValues(t, shim.OK, result.Status, "Migrate failed: "+result.Message)
+	json.Unmarshal(result.Payload, &migrationResult)
+	assert.Equal(t, 1, migrationResult.Version)
+	assert.Equal(t, "b", migrationResult.ResumeKey)
+	assert.False(t, migrationResult.Done)
+
+	result = invoke(stub, "Migrate", "10", org1MSPs, org1MSPs)
+	json.Unmarshal(result.Payload, &migrationResult)
+	assert.Equal(t, 2, migrationResult.Version)
+	assert.Equal(t, 1, migrationResult.Processed)
+	assert.True(t, migrationResult.Done)
+
+	a, _ := stub.GetState("a")
+	b, _ := stub.GetState("b")
+	assert.Equal(t, "v2:100.00", string(a))
+	assert.Equal(t, "v2:200.00", string(b))
+}
+
+func TestMigrateInvalidLimit(t *testing.T) {
+	stub := newABstoreStub(t)
+	result := invoke(stub, "Migrate", "0", org1MSPs, org1MSPs)
+	assert.EqualValues(t, shim.ERROR, result.Status, "Migrate should refuse a zero limit")
+	assert.Contains(t, result.Message, string(CodeInvalidArgument))
+}
+
+func TestInitRefusesDowngrade(t *testing.T) {
+	defer useMigrations([]schema.Migration{
+		{Version: 1, Description: "Versioned schema", Step: schema.Noop},
+		{Version: 2, Description: "Noop", Step: schema.Noop},
+	})()
+	stub := newABstoreStub(t)
+
+	result := migrateAll(t, stub, "1")
+	assert.EqualValues(t, shim.OK, result.Status, "Migrate failed: "+result.Message)
+
+	// deploy a chaincode that only knows the first version
+	migrations = migrations[:1]
+	result = invoke(stub, "Init", "a", "100", "b", "200", org1MSPs, org1MSPs)
+	assert.EqualValues(t, shim.ERROR, result.Status, "Init should refuse an older chaincode")
+	assert.Contains(t, result.Message, "refusing to downgrade")
+	a, _ := stub.GetState("a")
+	assert.Nil(t, a)
+}
+
+func TestMigrateRestrictedToAdmins(t *testing.T) {
+	alice, _, admin := identities(t)
+	org2Admin := newIdentity(t, "Org2MSP", "admin2", map[string]string{enrollmentIDAttribute: "admin2", roleAttribute: adminRole})
+	stub := newABstoreStub(t)
+
+	// the role MSPs are Init arguments, never the MSP of the client
+	result := invokeAs(stub, org2Admin, "Init", "a", "100", "b", "0", `[]`, org1MSPs)
+	assert.EqualValues(t, shim.ERROR, result.Status, "Init should refuse a role without MSPs")
+	assert.Contains(t, result.Message, string(CodeInvalidArgument))
+	result = invokeAs(stub, org2Admin, "Init", "a", "100", "b", "0", org1MSPs, `[""]`)
+	assert.EqualValues(t, shim.ERROR, result.Status, "Init should refuse empty MSP IDs")
+	assert.Contains(t, result.Message, string(CodeInvalidArgument))
+	result = invokeAs(stub, org2Admin, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	assert.EqualValues(t, shim.OK, result.Status, "Init failed: "+result.Message)
+	result = invoke(stub, "GetRoleMSPs", adminRole)
+	assert.JSONEq(t, `{"role":"admin","mspIds":["Org1MSP"]}`, string(result.Payload))
+
+	for _, client := range [][]byte{org2Admin, alice} {
+		result = invokeAs(stub, client, "Migrate", "10", `["Org2MSP"]`, `["Org2MSP"]`)
+		assert.EqualValues(t, shim.ERROR, result.Status, "only the admins should migrate")
+		assert.Contains(t, result.Message, string(CodeMissingRole))
+	}
+	result = invokeAs(stub, admin, "Migrate", "10", `["Org2MSP"]`, `["Org2MSP"]`)
+	assert.EqualValues(t, shim.OK, result.Status, "Migrate failed: "+result.Message)
+	result = invoke(stub, "GetRoleMSPs", adminRole)
+	assert.JSONEq(t, `{"role":"admin","mspIds":["Org1MSP"]}`, string(result.Payload), "the recorded MSPs should be kept")
+}
+
+func TestRoleMSPsMigration(t *testing.T) {
+	_, _, admin := identities(t)
+	stub := newABstoreStub(t)
+	stub.MockTransactionStart("1")
+	saveSchemaState(stub, SchemaVersion{Version: roleMSPsVersion - 1})
+	stub.MockTransactionEnd("1")
+
+	// the ledger has no admin yet, the role MSPs are taken from the arguments
+	result := invokeAs(stub, admin, "Migrate", "10", `[]`, `[]`)
+	assert.EqualValues(t, shim.ERROR, result.Status, "the role MSPs migration needs the MSPs")
+	assert.Contains(t, result.Message, string(CodeInvalidArgument))
+	result = invokeAs(stub, admin, "Migrate", "10", `["Org2MSP"]`, `["Org3MSP"]`)
+	assert.EqualValues(t, shim.OK, result.Status, "Migrate failed: "+result.Message)
+	result = invoke(stub, "GetRoleMSPs", adminRole)
+	assert.JSONEq(t, `{"role":"admin","mspIds":["Org2MSP"]}`, string(result.Payload))
+	result = invoke(stub, "GetRoleMSPs", minterRole)
+	assert.JSONEq(t, `{"role":"minter","mspIds":["Org3MSP"]}`, string(result.Payload))
+
+	// the Org1MSP admin is no longer an admin of this ledger
+	result = invokeAs(stub, admin, "Migrate", "10", org1MSPs, org1MSPs)
+	assert.EqualValues(t, shim.ERROR, result.Status, "a non-admin org should not migrate")
+	assert.Contains(t, result.Message, string(CodeMissingRole))
+}
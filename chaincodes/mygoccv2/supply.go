@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"schema"
+)
+
+const (
+	totalSupplyObjectType = "totalSupply"
+	// minterRole is the role allowed to mint and burn the default asset
+	minterRole = "minter"
+)
+
+// Invariants is the result of CheckInvariants
+type Invariants struct {
+	TotalSupply      string   `json:"totalSupply"`
+	BalancesSum      string   `json:"balancesSum"`
+	Accounts         int      `json:"accounts"`
+	NegativeBalances []string `json:"negativeBalances" metadata:",optional"` // accounts with a balance below zero
+	Consistent       bool     `json:"consistent"`                            // the balances sum up to the total supply and none is negative
+}
+
+// Mint creates amount units on an account, only clients with the minter role can mint
+func (t *ABstore) Mint(ctx contractapi.TransactionContextInterface, account string, amount string) error {
+	return mint(ctx.GetStub(), defaultAsset, account, amount)
+}
+
+// Burn destroys amount units of an account, only clients with the minter role can burn and
+// only from the accounts they own, e.g. an account the funds to redeem are transferred to
+func (t *ABstore) Burn(ctx contractapi.TransactionContextInterface, account string, amount string) error {
+	return burn(ctx.GetStub(), defaultAsset, account, amount)
+}
+
+func mint(stub shim.ChaincodeStubInterface, asset Asset, account string, amount string) error {
+	value, err := asset.parsePositiveAmount(amount)
+	if err != nil {
+		return err
+	}
+	// the minter role is bound to its MSPs from the latest schema version on
+	if err := checkLatestSchema(stub); err != nil {
+		return err
+	}
+	if err := checkIssuer(stub, asset); err != nil {
+		return err
+	}
+	balance, err := getAssetBalance(stub, asset, account)
+	if err != nil {
+		return err
+	}
+	if err := addTotalSupply(stub, asset, value); err != nil {
+		return err
+	}
+
+	fmt.Printf("Minting %s %s on %s\n", asset.formatAmount(value), asset.Symbol, account)
+	if err := putAssetBalance(stub, asset, account, balance.Add(balance, value)); err != nil {
+		return err
+	}
+	if err := recordTransfer(stub, asset, "", nil, account, balance, value, nil); err != nil {
+		return err
+	}
+	return setEvent(stub, transferEvent, TransferEvent{Asset: asset.Symbol, From: "", To: account, Value: asset.formatAmount(value)})
+}
+
+func burn(stub shim.ChaincodeStubInterface, asset Asset, account string, amount string) error {
+	value, err := asset.parsePositiveAmount(amount)
+	if err != nil {
+		return err
+	}
+	// the minter role is bound to its MSPs from the latest schema version on
+	if err := checkLatestSchema(stub); err != nil {
+		return err
+	}
+	if err := checkIssuer(stub, asset); err != nil {
+		return err
+	}
+	balance, err := getAssetBalance(stub, asset, account)
+	if err != nil {
+		return err
+	}
+	if err := checkOwner(stub, account); err != nil {
+		return err
+	}
+	available := new(big.Int).Set(balance)
+	if asset.Symbol == defaultAssetSymbol {
+		held, err := heldAmount(stub, account, "")
+		if err != nil {
+			return err
+		}
+		available.Sub(available, held)
+	}
+	if available.Cmp(value) < 0 {
+		return &InsufficientFundsError{Account: account, Balance: asset.formatAmount(available), Amount: asset.formatAmount(value)}
+	}
+	if err := addTotalSupply(stub, asset, new(big.Int).Neg(value)); err != nil {
+		return err
+	}
+
+	fmt.Printf("Burning %s %s from %s\n", asset.formatAmount(value), asset.Symbol, account)
+	if err := putAssetBalance(stub, asset, account, balance.Sub(balance, value)); err != nil {
+		return err
+	}
+	if err := recordTransfer(stub, asset, account, balance, "", nil, value, nil); err != nil {
+		return err
+	}
+	return setEvent(stub, transferEvent, TransferEvent{Asset: asset.Symbol, From: account, To: "", Value: asset.formatAmount(value)})
+}
+
+// CorruptedBalance is a legacy balance the migration could not parse, its account was left with a
+// zero balance until an admin repairs it
+type CorruptedBalance struct {
+	Account string `json:"account"`
+	Value   string `json:"value"`
+}
+
+// GetCorruptedBalances lists the corrupted balances waiting for RepairBalance
+func (t *ABstore) GetCorruptedBalances(ctx contractapi.TransactionContextInterface) ([]CorruptedBalance, error) {
+	stub := ctx.GetStub()
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(corruptedBalanceObjectType, []string{})
+	if err != nil {
+		return nil, &InternalError{Reason: "Failed to get state"}
+	}
+	defer resultsIterator.Close()
+
+	balances := make([]CorruptedBalance, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, &InternalError{Reason: "Failed to get state"}
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, &CorruptedStateError{Object: "corrupted balance " + queryResponse.Key}
+		}
+		balances = append(balances, CorruptedBalance{Account: attributes[0], Value: string(queryResponse.Value)})
+	}
+	return balances, nil
+}
+
+// RepairBalance credits a quarantined account with the balance an admin determined for it, as a
+// mint, and clears its corrupted balance
+func (t *ABstore) RepairBalance(ctx contractapi.TransactionContextInterface, account string, balance string) error {
+	stub := ctx.GetStub()
+	value, err := parseAmount(balance)
+	if err != nil {
+		return err
+	}
+	if value.Sign() < 0 {
+		return &InvalidAmountError{Amount: balance, Reason: "must not be negative"}
+	}
+	if err := checkLatestSchema(stub); err != nil {
+		return err
+	}
+	if err := checkRole(stub, adminRole); err != nil {
+		return err
+	}
+	key, err := corruptedBalanceKey(stub, account)
+	if err != nil {
+		return err
+	}
+	corrupted, err := stub.GetState(key)
+	if err != nil {
+		return &InternalError{Reason: "Failed to get state"}
+	}
+	if corrupted == nil {
+		return &InvalidArgumentError{Argument: "account", Reason: "has no corrupted balance"}
+	}
+	if err := stub.DelState(key); err != nil {
+		return &InternalError{Reason: "Failed to delete state"}
+	}
+	if value.Sign() == 0 {
+		return nil
+	}
+
+	current, err := getBalance(stub, account)
+	if err != nil {
+		return err
+	}
+	if err := addTotalSupply(stub, defaultAsset, value); err != nil {
+		return err
+	}
+	fmt.Printf("Repairing the balance of %s, corrupted %q, with %s\n", account, string(corrupted), formatAmount(value))
+	repaired := new(big.Int).Add(current, value)
+	if err := putBalance(stub, account, repaired); err != nil {
+		return err
+	}
+	if err := recordTransfer(stub, defaultAsset, "", nil, account, repaired, value, nil); err != nil {
+		return err
+	}
+	return setEvent(stub, transferEvent, TransferEvent{Asset: defaultAssetSymbol, From: "", To: account, Value: formatAmount(value)})
+}
+
+// TotalSupply returns the sum of all the balances
+func (t *ABstore) TotalSupply(ctx contractapi.TransactionContextInterface) (string, error) {
+	stub := ctx.GetStub()
+	// the total supply holds a partial sum while the total supply migration runs
+	if err := checkLatestSchema(stub); err != nil {
+		return "", err
+	}
+	supply, err := getTotalSupply(stub, defaultAsset)
+	if err != nil {
+		return "", err
+	}
+	if supply == nil {
+		return "", &MigrationRequiredError{Reason: "the total supply is not tracked yet"}
+	}
+	return formatAmount(supply), nil
+}
+
+// CheckInvariants sums all the balances of the default asset and compares the sum with the total supply
+func (t *ABstore) CheckInvariants(ctx contractapi.TransactionContextInterface) (Invariants, error) {
+	invariants := Invariants{NegativeBalances: make([]string, 0)}
+	stub := ctx.GetStub()
+	if err := checkLatestSchema(stub); err != nil {
+		return invariants, err
+	}
+	supply, err := getTotalSupply(stub, defaultAsset)
+	if err != nil {
+		return invariants, err
+	}
+	if supply == nil {
+		return invariants, &MigrationRequiredError{Reason: "the total supply is not tracked yet"}
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(balanceObjectType, []string{defaultAssetSymbol})
+	if err != nil {
+		return invariants, err
+	}
+	defer resultsIterator.Close()
+
+	sum := new(big.Int)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return invariants, err
+		}
+		_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return invariants, err
+		}
+		account := attributes[1]
+		balance, err := parseAmount(string(queryResponse.Value))
+		if err != nil {
+			return invariants, &CorruptedBalanceError{Account: account, Value: string(queryResponse.Value)}
+		}
+		if balance.Sign() < 0 {
+			invariants.NegativeBalances = append(invariants.NegativeBalances, account)
+		}
+		sum.Add(sum, balance)
+		invariants.Accounts++
+	}
+
+	invariants.TotalSupply = formatAmount(supply)
+	invariants.BalancesSum = formatAmount(sum)
+	invariants.Consistent = sum.Cmp(supply) == 0 && len(invariants.NegativeBalances) == 0
+	return invariants, nil
+}
+
+// checkNotInitialized returns an error once the ledger has a total supply or accounts
+func checkNotInitialized(stub shim.ChaincodeStubInterface) error {
+	supply, err := getTotalSupply(stub, defaultAsset)
+	if err != nil {
+		return err
+	}
+	if supply != nil {
+		return &AlreadyInitializedError{}
+	}
+	// skip the composite keys, which start with 0x00
+	legacyIterator, err := stub.GetStateByRange("\x01", string(utf8.MaxRune))
+	if err != nil {
+		return err
+	}
+	defer legacyIterator.Close()
+	if legacyIterator.HasNext() {
+		return &AlreadyInitializedError{}
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(balanceObjectType, []string{defaultAssetSymbol})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+	if resultsIterator.HasNext() {
+		return &AlreadyInitializedError{}
+	}
+	return nil
+}
+
+func checkMinter(stub shim.ChaincodeStubInterface) error {
+	return checkRole(stub, minterRole)
+}
+
+// totalSupplyKey returns the key of the total supply of an asset, the default asset has no symbol
+// in the key as its total supply was tracked before multiple assets
+func totalSupplyKey(stub shim.ChaincodeStubInterface, asset Asset) (string, error) {
+	if asset.Symbol == defaultAssetSymbol {
+		return stub.CreateCompositeKey(totalSupplyObjectType, []string{})
+	}
+	return stub.CreateCompositeKey(totalSupplyObjectType, []string{asset.Symbol})
+}
+
+// getTotalSupply returns the total supply of an asset, nil until Init or the total supply migration
+func getTotalSupply(stub shim.ChaincodeStubInterface, asset Asset) (*big.Int, error) {
+	key, err := totalSupplyKey(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+	supplyBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, &InternalError{Reason: "Failed to get state"}
+	}
+	if supplyBytes == nil {
+		return nil, nil
+	}
+	supply, err := asset.parseAmount(string(supplyBytes))
+	if err != nil {
+		return nil, &CorruptedStateError{Object: fmt.Sprintf("total supply %q of %s", string(supplyBytes), asset.Symbol)}
+	}
+	return supply, nil
+}
+
+func putTotalSupply(stub shim.ChaincodeStubInterface, asset Asset, supply *big.Int) error {
+	key, err := totalSupplyKey(stub, asset)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte(asset.formatAmount(supply)))
+}
+
+// addTotalSupply adds delta to the total supply of an asset, the ledger must be migrated to track it
+func addTotalSupply(stub shim.ChaincodeStubInterface, asset Asset, delta *big.Int) error {
+	if err := checkLatestSchema(stub); err != nil {
+		return err
+	}
+	supply, err := getTotalSupply(stub, asset)
+	if err != nil {
+		return err
+	}
+	if supply == nil {
+		supply = new(big.Int)
+	}
+	return putTotalSupply(stub, asset, supply.Add(supply, delta))
+}
+
+// totalSupplyMigration sums the balances into the total supply. The partial sum is saved in the
+// total supply between the steps, the balances cannot change until the ledger is migrated.
+func totalSupplyMigration(stub shim.ChaincodeStubInterface, resumeKey string, limit int) (string, int, error) {
+	supply := new(big.Int)
+	if resumeKey != "" {
+		partial, err := getTotalSupply(stub, defaultAsset)
+		if err != nil {
+			return "", 0, err
+		}
+		if partial != nil {
+			supply = partial
+		}
+	}
+
+	next, processed, err := schema.Range(func(stub shim.ChaincodeStubInterface, key string, value []byte) error {
+		balance, err := parseAmount(string(value))
+		if err != nil {
+			return &CorruptedBalanceError{Account: key, Value: string(value)}
+		}
+		supply.Add(supply, balance)
+		return nil
+	})(stub, resumeKey, limit)
+	if err != nil {
+		return "", processed, err
+	}
+	return next, processed, putTotalSupply(stub, defaultAsset, supply)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/stretchr/testify/assert"
+)
+
+var minterIdentity []byte
+
+// minter returns the identity of an Org1MSP client with the minter role
+func minter(t *testing.T) []byte {
+	if minterIdentity == nil {
+		minterIdentity = newIdentity(t, "Org1MSP", "minter", map[string]string{enrollmentIDAttribute: "minter", roleAttribute: minterRole})
+	}
+	return minterIdentity
+}
+
+func checkTotalSupply(t *testing.T, stub *shimtest.MockStub, supply string) {
+	result := invoke(stub, "TotalSupply")
+	assert.EqualValues(t, shim.OK, result.Status, "TotalSupply failed: "+result.Message)
+	assert.Equal(t, supply, string(result.Payload))
+}
+
+func checkInvariants(t *testing.T, stub *shimtest.MockStub) Invariants {
+	result := invoke(stub, "CheckInvariants")
+	assert.EqualValues(t, shim.OK, result.Status, "CheckInvariants failed: "+result.Message)
+	var invariants Invariants
+	json.Unmarshal(result.Payload, &invariants)
+	return invariants
+}
+
+func TestInitOnce(t *testing.T) {
+	stub := newABstoreStub(t)
+	result := invoke(stub, "Init", "a", "100", "b", "200", org1MSPs, org1MSPs)
+	assert.EqualValues(t, shim.OK, result.Status, "Init failed: "+result.Message)
+	checkTotalSupply(t, stub, "300.00")
+
+	result = invoke(stub, "GetSchemaVersion")
+	assert.JSONEq(t, `{"version":6,"latest":6,"resumeKey":""}`, string(result.Payload), "a new ledger has nothing to migrate")
+
+	result = invoke(stub, "Init", "a", "1000", "b", "0", org1MSPs, org1MSPs)
+	assert.EqualValues(t, shim.ERROR, result.Status, "Init should run once")
+	assert.Contains(t, result.Message, string(CodeAlreadyInitialized))
+	checkBalance(t, stub, "a", "100.00")
+
+	// a ledger with accounts from an older chaincode is not initialized again either
+	legacy := newABstoreStub(t)
+	legacy.MockTransactionStart("1")
+	legacy.PutState("a", []byte("100"))
+	legacy.MockTransactionEnd("1")
+	result = invoke(legacy, "Init", "a", "1000", "b", "0", org1MSPs, org1MSPs)
+	assert.EqualValues(t, shim.ERROR, result.Status, "Init should not reset older ledgers")
+}
+
+func TestMintAndBurn(t *testing.T) {
+	alice, _, admin := identities(t)
+	stub := newABstoreStub(t)
+	invokeAs(stub, alice, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+	invokeAs(stub, minter(t), "CreateAccount", "treasury")
+
+	result := invokeAs(stub, minter(t), "Mint", "b", "50.25")
+	assert.EqualValues(t, shim.OK, result.Status, "Mint failed: "+result.Message)
+	checkBalance(t, stub, "b", "50.25")
+	checkTotalSupply(t, stub, "150.25")
+	event := lastEvent(stub)
+	assert.Equal(t, "Transfer", event.EventName)
+	assert.JSONEq(t, `{"asset":"ABS","from":"","to":"b","value":"50.25"}`, string(event.Payload))
+
+	for _, identity := range [][]byte{alice, admin} {
+		result = invokeAs(stub, identity, "Mint", "a", "1")
+		assert.EqualValues(t, shim.ERROR, result.Status, "only minters should mint")
+		assert.Contains(t, result.Message, string(CodeMissingRole))
+	}
+
+	// redeemed funds are transferred to an account of the minter, then burnt
+	result = invokeAs(stub, minter(t), "Burn", "a", "10")
+	assert.EqualValues(t, shim.ERROR, result.Status, "minters should only burn their own accounts")
+	invokeAs(stub, alice, "Invoke", "a", "treasury", "40")
+	result = invokeAs(stub, minter(t), "Burn", "treasury", "40.01")
+	assert.EqualValues(t, shim.ERROR, result.Status, "burning more than the balance should fail")
+	assert.Contains(t, result.Message, string(CodeInsufficientFunds))
+	result = invokeAs(stub, minter(t), "Burn", "treasury", "40")
+	assert.EqualValues(t, shim.OK, result.Status, "Burn failed: "+result.Message)
+	checkBalance(t, stub, "treasury", "0.00")
+	checkTotalSupply(t, stub, "110.25")
+
+	invariants := checkInvariants(t, stub)
+	assert.Equal(t, Invariants{TotalSupply: "110.25", BalancesSum: "110.25", Accounts: 3, NegativeBalances: []string{}, Consistent: true}, invariants)
+}
+
+func TestCheckInvariantsReportsInconsistencies(t *testing.T) {
+	stub := newABstoreStub(t)
+	invoke(stub, "Init", "a", "100", "b", "0", org1MSPs, org1MSPs)
+
+	stub.MockTransactionStart("1")
+	putBalance(stub, "b", big.NewInt(-500))
+	stub.MockTransactionEnd("1")
+
+	invariants := checkInvariants(t, stub)
+	assert.False(t, invariants.Consistent)
+	assert.Equal(t, "95.00", invariants.BalancesSum)
+	assert.Equal(t, []string{"b"}, invariants.NegativeBalances)
+}
+
+func TestTotalSupplyMigration(t *testing.T) {
+	_, _, admin := identities(t)
+	stub := newABstoreStub(t)
+	stub.MockTransactionStart("1")
+	stub.PutState("a", []byte("100"))
+	stub.PutState("b", []byte("20.50"))
+	stub.PutState("c", []byte("7"))
+	stub.MockTransactionEnd("1")
+
+	result := invoke(stub, "TotalSupply")
+	assert.EqualValues(t, shim.ERROR, result.Status, "the supply is not tracked before the migration")
+	assert.Contains(t, result.Message, string(CodeMigrationRequired))
+	result = invokeAs(stub, minter(t), "Mint", "a", "1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "minting before the migration would break the supply")
+	assert.Contains(t, result.Message, string(CodeMigrationRequired))
+
+	// migrate one record at a time, the partial sum is neither returned nor changed by transfers
+	owner, _ := clientOwner(stub)
+	stub.MockTransactionStart("2")
+	putAccountOwner(stub, "c", owner)
+	stub.MockTransactionEnd("2")
+	var migrationResult MigrationResult
+	for i := 0; i < 20 && !migrationResult.Done; i++ {
+		result = invokeAs(stub, admin, "Migrate", "1", org1MSPs, org1MSPs)
+		assert.EqualValues(t, shim.OK, result.Status, "Migrate failed: "+result.Message)
+		json.Unmarshal(result.Payload, &migrationResult)
+		if migrationResult.Version == 2 && migrationResult.ResumeKey != "" {
+			result = invoke(stub, "TotalSupply")
+			assert.EqualValues(t, shim.ERROR, result.Status, "the partial sum should not be returned")
+			assert.Contains(t, result.Message, string(CodeMigrationRequired))
+			result = invokeAs(stub, minter(t), "Invoke", "c", "a", "1")
+			assert.EqualValues(t, shim.ERROR, result.Status, "transfers would corrupt the partial sum")
+			assert.Contains(t, result.Message, string(CodeMigrationRequired))
+		}
+	}
+	assert.True(t, migrationResult.Done)
+	checkTotalSupply(t, stub, "127.50")
+	assert.True(t, checkInvariants(t, stub).Consistent)
+}
@@ -1,21 +1,16 @@
 module pdc
 
-go 1.12
+go 1.13
 
 require (
-	github.com/Knetic/govaluate v3.0.0+incompatible // indirect
-	github.com/Shopify/sarama v1.38.1 // indirect
-	github.com/fsouza/go-dockerclient v1.7.0 // indirect
-	github.com/grpc-ecosystem/go-grpc-middleware v1.2.2 // indirect
-	github.com/hashicorp/go-version v1.6.0 // indirect
-	github.com/hyperledger/fabric v1.4.9
-	github.com/hyperledger/fabric-amcl v0.0.0-20200424173818-327c9e2cf77a // indirect
-	github.com/miekg/pkcs11 v1.1.1 // indirect
-	github.com/onsi/ginkgo v1.16.5 // indirect
-	github.com/onsi/gomega v1.27.1 // indirect
-	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7 // indirect
-	github.com/spf13/viper v1.7.1 // indirect
-	github.com/sykesm/zap-logfmt v0.0.4 // indirect
-	go.uber.org/zap v1.16.0 // indirect
-	google.golang.org/grpc v1.35.0 // indirect
+	ccserver v0.0.0
+	github.com/hyperledger/fabric-chaincode-go v0.0.0-20200424173110-d7076418f212
+	github.com/hyperledger/fabric-protos-go v0.0.0-20200424173316-dd554ba3746e
+	github.com/stretchr/testify v1.5.1
+	mangostub v0.0.0
+)
+
+replace (
+	ccserver => ../ccserver
+	mangostub => ../mangostub
 )
@@ -22,11 +22,17 @@ SPDX-License-Identifier: Apache-2.0
 //
 // export MARBLE_DELETE=$(echo -n "{\"name\":\"marble1\"}" | base64 | tr -d \\n)
 // peer chaincode invoke -C mychannel -n marblesp -c '{"Args":["delete"]}' --transient "{\"marble_delete\":\"$MARBLE_DELETE\"}"
+//
+// ==== Require endorsements from specific orgs to update a private key ====
+// peer chaincode invoke -C mychannel -n marblesp -c '{"Args":["setPrivateKeyEndorsers","collectionMarbles","marble1","[\"Org1MSP\"]"]}'
+// peer chaincode invoke -C mychannel -n marblesp -c '{"Args":["addPrivateKeyEndorsers","collectionMarbles","marble1","[\"Org2MSP\"]","PEER"]}'
+// peer chaincode invoke -C mychannel -n marblesp -c '{"Args":["removePrivateKeyEndorsers","collectionMarbles","marble1","[\"Org2MSP\"]"]}'
 
 // ==== Query marbles, since queries are not recorded on chain we don't need to hide private data in transient map ====
 // peer chaincode query -C mychannel -n marblesp -c '{"Args":["readMarble","marble1"]}'
 // peer chaincode query -C mychannel -n marblesp -c '{"Args":["readMarblePrivateDetails","marble1"]}'
 // peer chaincode query -C mychannel -n marblesp -c '{"Args":["getMarblesByRange","marble1","marble4"]}'
+// peer chaincode query -C mychannel -n marblesp -c '{"Args":["getPrivateKeyEndorsementPolicy","collectionMarbles","marble1"]}'
 //
 // Rich Query (Only supported if CouchDB is used as state database):
 //   peer chaincode query -C mychannel -n marblesp -c '{"Args":["queryMarblesByOwner","tom"]}'
@@ -92,12 +98,17 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+
+	"ccserver"
 )
 
 // SimpleChaincode example simple Chaincode implementation
@@ -122,7 +133,7 @@ type marblePrivateDetails struct {
 // Main
 // ===================================================================================
 func main() {
-	err := shim.Start(new(SimpleChaincode))
+	err := ccserver.Run(new(SimpleChaincode))
 	if err != nil {
 		fmt.Printf("Error starting Simple chaincode: %s", err)
 	}
@@ -151,6 +162,21 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	case "readMarblePrivateDetails":
 		//read a marble private details
 		return t.readMarblePrivateDetails(stub, args)
+	case "verifyMarblePrivateDetails":
+		//verify claimed private details of a marble against their hash
+		return t.verifyMarblePrivateDetails(stub, args)
+	case "setPrivateKeyEndorsers":
+		//require endorsements from the given orgs to update a private key
+		return t.setPrivateKeyEndorsers(stub, args)
+	case "addPrivateKeyEndorsers":
+		//add orgs to the endorsement policy of a private key
+		return t.addPrivateKeyEndorsers(stub, args)
+	case "removePrivateKeyEndorsers":
+		//remove orgs from the endorsement policy of a private key
+		return t.removePrivateKeyEndorsers(stub, args)
+	case "getPrivateKeyEndorsementPolicy":
+		//list the orgs required to endorse the updates of a private key
+		return t.getPrivateKeyEndorsementPolicy(stub, args)
 	case "transferMarble":
 		//change owner of a specific marble
 		return t.transferMarble(stub, args)
@@ -340,6 +366,180 @@ func (t *SimpleChaincode) readMarblePrivateDetails(stub shim.ChaincodeStubInterf
 	return shim.Success(valAsbytes)
 }
 
+// ===============================================
+// verifyMarblePrivateDetails - check claimed private details against the private data hash
+// ===============================================
+// The hash of the private details is readable by every peer of the channel, so an org that is not
+// a member of collectionMarblePrivateDetails can verify a price it was told off-chain. The claimed
+// JSON is re-encoded the way initMarble stores it, its docType and name can be omitted.
+func (t *SimpleChaincode) verifyMarblePrivateDetails(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the marble and the claimed private details JSON")
+	}
+	name := args[0]
+
+	var claimed marblePrivateDetails
+	decoder := json.NewDecoder(strings.NewReader(args[1]))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&claimed)
+	if err != nil {
+		return shim.Error("Failed to decode JSON of: " + args[1])
+	}
+	if claimed.ObjectType == "" {
+		claimed.ObjectType = "marblePrivateDetails"
+	}
+	if claimed.Name == "" {
+		claimed.Name = name
+	}
+	if claimed.ObjectType != "marblePrivateDetails" || claimed.Name != name {
+		return shim.Error("The claimed private details are not the ones of marble " + name)
+	}
+	claimedAsBytes, err := json.Marshal(claimed)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	hashAsBytes, err := stub.GetPrivateDataHash("collectionMarblePrivateDetails", name)
+	if err != nil {
+		return shim.Error("Failed to get private details hash for " + name + ": " + err.Error())
+	} else if hashAsBytes == nil {
+		return shim.Error("Marble private details does not exist: " + name)
+	}
+
+	claimedHash := sha256.Sum256(claimedAsBytes)
+	if !bytes.Equal(claimedHash[:], hashAsBytes) {
+		return shim.Error("The claimed private details do not match the ones of marble " + name)
+	}
+
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// setPrivateKeyEndorsers - require every org of a JSON list to endorse the updates of a private key
+// ===========================================================================================
+// Arguments are collection, key, the mspIDs JSON list and an optional role, MEMBER (default) or
+// PEER. The policy replaces the chaincode and collection endorsement policies for this key.
+func (t *SimpleChaincode) setPrivateKeyEndorsers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 3 || len(args) > 4 {
+		return shim.Error("Incorrect number of arguments. Expecting collection, key, mspIDs JSON list and an optional role")
+	}
+	return updatePrivateKeyEndorsers(stub, args[0], args[1], args[2], args[3:], func(ep statebased.KeyEndorsementPolicy, role statebased.RoleType, mspIDs []string) error {
+		ep.DelOrgs(ep.ListOrgs()...)
+		return ep.AddOrgs(role, mspIDs...)
+	})
+}
+
+// ===========================================================================================
+// addPrivateKeyEndorsers - add orgs to the endorsement policy of a private key
+// ===========================================================================================
+func (t *SimpleChaincode) addPrivateKeyEndorsers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 3 || len(args) > 4 {
+		return shim.Error("Incorrect number of arguments. Expecting collection, key, mspIDs JSON list and an optional role")
+	}
+	return updatePrivateKeyEndorsers(stub, args[0], args[1], args[2], args[3:], func(ep statebased.KeyEndorsementPolicy, role statebased.RoleType, mspIDs []string) error {
+		return ep.AddOrgs(role, mspIDs...)
+	})
+}
+
+// ===========================================================================================
+// removePrivateKeyEndorsers - remove orgs from the endorsement policy of a private key
+// ===========================================================================================
+// Removing the last org restores the chaincode and collection endorsement policies.
+func (t *SimpleChaincode) removePrivateKeyEndorsers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting collection, key and mspIDs JSON list")
+	}
+	return updatePrivateKeyEndorsers(stub, args[0], args[1], args[2], nil, func(ep statebased.KeyEndorsementPolicy, role statebased.RoleType, mspIDs []string) error {
+		ep.DelOrgs(mspIDs...)
+		return nil
+	})
+}
+
+// ===========================================================================================
+// getPrivateKeyEndorsementPolicy - list the orgs required to endorse the updates of a private key
+// ===========================================================================================
+func (t *SimpleChaincode) getPrivateKeyEndorsementPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting collection and key")
+	}
+
+	policy, err := stub.GetPrivateDataValidationParameter(args[0], args[1])
+	if err != nil {
+		return shim.Error("Failed to get the endorsement policy of " + args[1] + ": " + err.Error())
+	}
+	ep, err := statebased.NewStateEP(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return encodeKeyEndorsers(ep)
+}
+
+func updatePrivateKeyEndorsers(stub shim.ChaincodeStubInterface, collection, key, mspIDsJSON string, roleArgs []string,
+	update func(statebased.KeyEndorsementPolicy, statebased.RoleType, []string) error) pb.Response {
+	var mspIDs []string
+	err := json.Unmarshal([]byte(mspIDsJSON), &mspIDs)
+	if err != nil {
+		return shim.Error("Failed to decode JSON of: " + mspIDsJSON)
+	}
+
+	role := statebased.RoleTypeMember
+	if len(roleArgs) > 0 && roleArgs[0] != "" {
+		role = statebased.RoleType(strings.ToUpper(roleArgs[0]))
+		if role != statebased.RoleTypeMember && role != statebased.RoleTypePeer {
+			return shim.Error("role must be MEMBER or PEER")
+		}
+	}
+
+	// the hash is readable by the orgs that are not members of the collection
+	hashAsBytes, err := stub.GetPrivateDataHash(collection, key)
+	if err != nil {
+		return shim.Error("Failed to get private data hash for " + key + ": " + err.Error())
+	} else if hashAsBytes == nil {
+		return shim.Error("Private key does not exist: " + key)
+	}
+
+	policy, err := stub.GetPrivateDataValidationParameter(collection, key)
+	if err != nil {
+		return shim.Error("Failed to get the endorsement policy of " + key + ": " + err.Error())
+	}
+	ep, err := statebased.NewStateEP(policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = update(ep, role, mspIDs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// a policy without orgs would be satisfied by any endorsement, remove it instead
+	policy = nil
+	if len(ep.ListOrgs()) > 0 {
+		policy, err = ep.Policy()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = stub.SetPrivateDataValidationParameter(collection, key, policy)
+	if err != nil {
+		return shim.Error("Failed to set the endorsement policy of " + key + ": " + err.Error())
+	}
+
+	return encodeKeyEndorsers(ep)
+}
+
+func encodeKeyEndorsers(ep statebased.KeyEndorsementPolicy) pb.Response {
+	orgs := ep.ListOrgs()
+	sort.Strings(orgs)
+
+	endorsersJSONasBytes, err := json.Marshal(map[string][]string{"orgs": orgs})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(endorsersJSONasBytes)
+}
+
 // ==================================================
 // delete - remove a marble key/value pair from state
 // ==================================================
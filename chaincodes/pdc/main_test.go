@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+
+	"mangostub"
+)
+
+type queryRecord struct {
+	Key    string
+	Record marble
+}
+
+// newMarblesStub returns a stub enforcing collections_config.json, with marble1 and marble2 owned
+// by tom and marble3 owned by jerry
+func newMarblesStub(t *testing.T) *mangostub.MockStub {
+	stub := mangostub.NewMockStub("marblesp", new(SimpleChaincode))
+	collections, err := mangostub.LoadCollections("collections_config.json")
+	assert.Nil(t, err, "Unable to load the collections")
+	stub.SetCollections(collections)
+
+	result := stub.MockInit("1", nil)
+	assert.EqualValues(t, shim.OK, result.Status, "Init failed: "+result.Message)
+
+	for _, marbleJSON := range []string{
+		`{"name":"marble1","color":"blue","size":35,"owner":"tom","price":99}`,
+		`{"name":"marble2","color":"red","size":50,"owner":"tom","price":102}`,
+		`{"name":"marble3","color":"blue","size":70,"owner":"jerry","price":103}`,
+	} {
+		result = invoke(stub, "Org1MSP", map[string][]byte{"marble": []byte(marbleJSON)}, "initMarble")
+		assert.EqualValues(t, shim.OK, result.Status, "initMarble failed: "+result.Message)
+	}
+	return stub
+}
+
+// invoke calls the chaincode as a client of mspID with a transient map
+func invoke(stub *mangostub.MockStub, mspID string, transient map[string][]byte, args ...string) pb.Response {
+	invokeArgs := make([][]byte, 0)
+	for _, arg := range args {
+		invokeArgs = append(invokeArgs, []byte(arg))
+	}
+	stub.MSPID = mspID
+	stub.Transient = transient
+	defer func() { stub.Transient = nil }()
+	return stub.MockInvoke("1", invokeArgs)
+}
+
+func queryMarbles(t *testing.T, stub *mangostub.MockStub, args ...string) []string {
+	result := invoke(stub, "Org1MSP", nil, args...)
+	assert.EqualValues(t, shim.OK, result.Status, args[0]+" failed: "+result.Message)
+
+	var records []queryRecord
+	err := json.Unmarshal(result.Payload, &records)
+	assert.Nil(t, err, "Unable to unmarshal the query results")
+
+	names := make([]string, 0)
+	for _, record := range records {
+		assert.Equal(t, record.Key, record.Record.Name)
+		names = append(names, record.Key)
+	}
+	return names
+}
+
+func readMarble(t *testing.T, stub *mangostub.MockStub, name string) marble {
+	result := invoke(stub, "Org1MSP", nil, "readMarble", name)
+	assert.EqualValues(t, shim.OK, result.Status, "readMarble failed: "+result.Message)
+
+	var m marble
+	err := json.Unmarshal(result.Payload, &m)
+	assert.Nil(t, err, "Unable to unmarshal the marble")
+	return m
+}
+
+func colorIndex(t *testing.T, stub *mangostub.MockStub, color string) []string {
+	it, err := stub.GetPrivateDataByPartialCompositeKey("collectionMarbles", "color~name", []string{color})
+	assert.Nil(t, err)
+	defer it.Close()
+
+	names := make([]string, 0)
+	for it.HasNext() {
+		kv, _ := it.Next()
+		_, attributes, _ := stub.SplitCompositeKey(kv.Key)
+		names = append(names, attributes[1])
+	}
+	return names
+}
+
+func TestInitMarble(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	assert.Equal(t, marble{ObjectType: "marble", Name: "marble1", Color: "blue", Size: 35, Owner: "tom"}, readMarble(t, stub, "marble1"))
+
+	result := invoke(stub, "Org1MSP", nil, "readMarblePrivateDetails", "marble1")
+	assert.EqualValues(t, shim.OK, result.Status, "readMarblePrivateDetails failed: "+result.Message)
+	assert.JSONEq(t, `{"docType":"marblePrivateDetails","name":"marble1","price":99}`, string(result.Payload))
+
+	assert.Equal(t, []string{"marble1", "marble3"}, colorIndex(t, stub, "blue"))
+	assert.Equal(t, []string{"marble2"}, colorIndex(t, stub, "red"))
+}
+
+func TestInitMarbleErrors(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	tests := []struct {
+		transient map[string][]byte
+		args      []string
+		message   string
+	}{
+		{nil, nil, "marble must be a key in the transient map"},
+		{map[string][]byte{"marble": []byte(`{"name":"marble4"`)}, nil, "Failed to decode JSON"},
+		{map[string][]byte{"marble": []byte(`{"name":"marble4","color":"blue","size":0,"owner":"tom","price":1}`)}, nil, "size field must be a positive integer"},
+		{map[string][]byte{"marble": []byte(`{"name":"marble1","color":"blue","size":35,"owner":"tom","price":99}`)}, nil, "This marble already exists"},
+		{map[string][]byte{"marble": []byte(`{"name":"marble4","color":"blue","size":35,"owner":"tom","price":99}`)}, []string{"marble4"}, "Incorrect number of arguments"},
+	}
+	for _, test := range tests {
+		result := invoke(stub, "Org1MSP", test.transient, append([]string{"initMarble"}, test.args...)...)
+		assert.EqualValues(t, shim.ERROR, result.Status)
+		assert.Contains(t, result.Message, test.message)
+	}
+}
+
+func TestTransferMarble(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	result := invoke(stub, "Org2MSP", map[string][]byte{"marble_owner": []byte(`{"name":"marble2","owner":"jerry"}`)}, "transferMarble")
+	assert.EqualValues(t, shim.OK, result.Status, "transferMarble failed: "+result.Message)
+	assert.Equal(t, "jerry", readMarble(t, stub, "marble2").Owner)
+	assert.Equal(t, []string{"marble2", "marble3"}, queryMarbles(t, stub, "queryMarblesByOwner", "jerry"))
+
+	result = invoke(stub, "Org1MSP", map[string][]byte{"marble_owner": []byte(`{"name":"marble9","owner":"jerry"}`)}, "transferMarble")
+	assert.EqualValues(t, shim.ERROR, result.Status)
+	assert.Contains(t, result.Message, "Marble does not exist")
+
+	result = invoke(stub, "Org1MSP", map[string][]byte{"marble_owner": []byte(`{"name":"marble1"}`)}, "transferMarble")
+	assert.EqualValues(t, shim.ERROR, result.Status)
+	assert.Contains(t, result.Message, "owner field must be a non-empty string")
+}
+
+func TestDeleteMarble(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	result := invoke(stub, "Org1MSP", map[string][]byte{"marble_delete": []byte(`{"name":"marble1"}`)}, "delete")
+	assert.EqualValues(t, shim.OK, result.Status, "delete failed: "+result.Message)
+
+	result = invoke(stub, "Org1MSP", nil, "readMarble", "marble1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "marble1 should be deleted")
+	result = invoke(stub, "Org1MSP", nil, "readMarblePrivateDetails", "marble1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "marble1 private details should be deleted")
+	assert.Equal(t, []string{"marble3"}, colorIndex(t, stub, "blue"))
+
+	result = invoke(stub, "Org1MSP", map[string][]byte{"marble_delete": []byte(`{"name":"marble1"}`)}, "delete")
+	assert.EqualValues(t, shim.ERROR, result.Status)
+	assert.Contains(t, result.Message, "Marble does not exist")
+}
+
+func TestGetMarblesByRange(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	assert.Equal(t, []string{"marble1", "marble2"}, queryMarbles(t, stub, "getMarblesByRange", "marble1", "marble3"))
+	assert.Equal(t, []string{"marble1", "marble2", "marble3"}, queryMarbles(t, stub, "getMarblesByRange", "", ""))
+}
+
+func TestQueryMarblesByOwner(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	assert.Equal(t, []string{"marble1", "marble2"}, queryMarbles(t, stub, "queryMarblesByOwner", "Tom"))
+	assert.Equal(t, []string{"marble3"}, queryMarbles(t, stub, "queryMarblesByOwner", "jerry"))
+	assert.Empty(t, queryMarbles(t, stub, "queryMarblesByOwner", "alice"))
+}
+
+func TestQueryMarbles(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	names := queryMarbles(t, stub, "queryMarbles", `{"selector":{"docType":"marble","color":"blue"}}`)
+	assert.Equal(t, []string{"marble1", "marble3"}, names)
+
+	names = queryMarbles(t, stub, "queryMarbles",
+		`{"selector":{"$or":[{"owner":"jerry"},{"size":{"$lt":40}}]},"sort":[{"size":"desc"}]}`)
+	assert.Equal(t, []string{"marble3", "marble1"}, names)
+
+	names = queryMarbles(t, stub, "queryMarbles", `{"selector":{"name":{"$regex":"[23]$"}},"limit":1}`)
+	assert.Equal(t, []string{"marble2"}, names)
+
+	result := invoke(stub, "Org1MSP", nil, "queryMarbles", `{"selector":{"size":{"$near":1}}}`)
+	assert.EqualValues(t, shim.ERROR, result.Status, "unknown operators should be rejected")
+}
+
+func TestCollectionMembership(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	// Org2MSP is a member of collectionMarbles only
+	result := invoke(stub, "Org2MSP", nil, "readMarble", "marble1")
+	assert.EqualValues(t, shim.OK, result.Status, "Org2MSP should read collectionMarbles: "+result.Message)
+
+	result = invoke(stub, "Org2MSP", nil, "readMarblePrivateDetails", "marble1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "Org2MSP should not read collectionMarblePrivateDetails")
+	assert.Contains(t, result.Message, "does not have read access permission")
+
+	// the private data hash stays readable by non members
+	result = invoke(stub, "Org2MSP", nil, "verifyMarblePrivateDetails", "marble1", `{"price":99}`)
+	assert.EqualValues(t, shim.OK, result.Status, "verifyMarblePrivateDetails failed: "+result.Message)
+
+	// Org3MSP is a member of no collection
+	result = invoke(stub, "Org3MSP", nil, "readMarble", "marble1")
+	assert.EqualValues(t, shim.ERROR, result.Status, "Org3MSP should not read collectionMarbles")
+	for _, args := range [][]string{
+		{"getMarblesByRange", "marble1", "marble3"},
+		{"queryMarblesByOwner", "tom"},
+	} {
+		result = invoke(stub, "Org3MSP", nil, args...)
+		assert.EqualValues(t, shim.ERROR, result.Status, "Org3MSP should not run "+args[0])
+		assert.Contains(t, result.Message, "does not have read access permission")
+	}
+
+	result = invoke(stub, "Org3MSP", map[string][]byte{"marble": []byte(`{"name":"marble4","color":"blue","size":35,"owner":"tom","price":99}`)}, "initMarble")
+	assert.EqualValues(t, shim.ERROR, result.Status, "Org3MSP cannot check that the marble does not exist yet")
+}
+
+func TestPrivateKeyEndorsers(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	endorsers := func(args ...string) string {
+		result := invoke(stub, "Org1MSP", nil, args...)
+		assert.EqualValues(t, shim.OK, result.Status, args[0]+" failed: "+result.Message)
+		return string(result.Payload)
+	}
+
+	// the same encoding as the key endorsers of mygocc
+	assert.JSONEq(t, `{"orgs":[]}`, endorsers("getPrivateKeyEndorsementPolicy", "collectionMarbles", "marble1"))
+	assert.JSONEq(t, `{"orgs":["Org1MSP","Org2MSP"]}`, endorsers("setPrivateKeyEndorsers", "collectionMarbles", "marble1", `["Org2MSP","Org1MSP"]`))
+	assert.JSONEq(t, `{"orgs":["Org1MSP","Org2MSP","Org3MSP"]}`, endorsers("addPrivateKeyEndorsers", "collectionMarbles", "marble1", `["Org3MSP"]`, "PEER"))
+	assert.JSONEq(t, `{"orgs":["Org3MSP"]}`, endorsers("removePrivateKeyEndorsers", "collectionMarbles", "marble1", `["Org1MSP","Org2MSP"]`))
+	assert.JSONEq(t, `{"orgs":["Org3MSP"]}`, endorsers("getPrivateKeyEndorsementPolicy", "collectionMarbles", "marble1"))
+
+	// removing the last org restores the collection endorsement policy
+	assert.JSONEq(t, `{"orgs":[]}`, endorsers("removePrivateKeyEndorsers", "collectionMarbles", "marble1", `["Org3MSP"]`))
+	policy, _ := stub.GetPrivateDataValidationParameter("collectionMarbles", "marble1")
+	assert.Empty(t, policy)
+}
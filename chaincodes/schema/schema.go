@@ -0,0 +1,244 @@
+// Package schema versions the ledger layout of the Go chaincodes and runs the migrations between
+// the versions, in steps bounded by the number of records so that large ledgers migrate over
+// several transactions.
+//
+// It is a standalone module, the chaincodes require it with a replace directive pointing at this
+// directory, e.g. replace schema => ../schema.
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+const (
+	versionObjectType  = "schemaVersion"
+	progressObjectType = "migrationProgress"
+)
+
+// Step processes at most limit records starting from resumeKey and returns the key to resume
+// from, "" once done
+type Step func(stub shim.ChaincodeStubInterface, resumeKey string, limit int) (next string, processed int, err error)
+
+// Migration upgrades the ledger from the previous schema version to Version. The ledger may hold
+// records in both layouts while a migration is running, so steps must leave the records already
+// in the new layout untouched.
+type Migration struct {
+	Version     int
+	Description string
+	Step        Step
+}
+
+// State is the schema version of the ledger and the key the running migration resumes from
+type State struct {
+	Version   int // 0 for a ledger written before versioning
+	ResumeKey string
+}
+
+// MalformedVersionError is returned when the recorded schema version is not a number
+type MalformedVersionError struct {
+	Value string
+}
+
+func (e *MalformedVersionError) Error() string {
+	return fmt.Sprintf("Malformed schema version %s", e.Value)
+}
+
+// NewerVersionError is returned when the ledger was migrated by a newer chaincode
+type NewerVersionError struct {
+	Version int
+	Latest  int
+}
+
+func (e *NewerVersionError) Error() string {
+	return fmt.Sprintf("The ledger schema version %d is newer than the chaincode schema version %d", e.Version, e.Latest)
+}
+
+// StepError is returned when a migration step fails
+type StepError struct {
+	Version int
+	Err     error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("Migration to schema version %d failed. %s", e.Version, e.Err.Error())
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// KeyError is returned by the Range steps when fn fails on a record
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("key '%s': %s", e.Key, e.Err.Error())
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// Latest returns the schema version the ordered registry of migrations upgrades to,
+// registry[i] upgrading to version i+1
+func Latest(registry []Migration) int {
+	if len(registry) == 0 {
+		return 0
+	}
+	return registry[len(registry)-1].Version
+}
+
+// LoadVersion returns the schema version of the ledger
+func LoadVersion(stub shim.ChaincodeStubInterface) (int, error) {
+	versionKey, err := stub.CreateCompositeKey(versionObjectType, []string{})
+	if err != nil {
+		return 0, err
+	}
+	versionBytes, err := stub.GetState(versionKey)
+	if err != nil {
+		return 0, err
+	}
+	if versionBytes == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(string(versionBytes))
+	if err != nil {
+		return 0, &MalformedVersionError{Value: string(versionBytes)}
+	}
+	return version, nil
+}
+
+// LoadState returns the schema version of the ledger and where its running migration stopped
+func LoadState(stub shim.ChaincodeStubInterface) (State, error) {
+	state := State{}
+	version, err := LoadVersion(stub)
+	if err != nil {
+		return state, err
+	}
+	state.Version = version
+
+	progressKey, err := stub.CreateCompositeKey(progressObjectType, []string{})
+	if err != nil {
+		return state, err
+	}
+	resumeKey, err := stub.GetState(progressKey)
+	if err != nil {
+		return state, err
+	}
+	state.ResumeKey = string(resumeKey)
+	return state, nil
+}
+
+// SaveState records the schema version of the ledger and where its running migration stopped
+func SaveState(stub shim.ChaincodeStubInterface, state State) error {
+	versionKey, err := stub.CreateCompositeKey(versionObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(versionKey, []byte(strconv.Itoa(state.Version))); err != nil {
+		return err
+	}
+
+	progressKey, err := stub.CreateCompositeKey(progressObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	if state.ResumeKey == "" {
+		return stub.DelState(progressKey)
+	}
+	return stub.PutState(progressKey, []byte(state.ResumeKey))
+}
+
+// Check refuses to run a chaincode older than the ledger it finds
+func Check(stub shim.ChaincodeStubInterface, registry []Migration) error {
+	version, err := LoadVersion(stub)
+	if err != nil {
+		return err
+	}
+	latest := Latest(registry)
+	if version > latest {
+		return fmt.Errorf("The ledger schema version %d is newer than the chaincode schema version %d, refusing to downgrade", version, latest)
+	}
+	if version < latest {
+		fmt.Printf("Ledger schema version %d, migrate it to version %d\n", version, latest)
+	}
+	return nil
+}
+
+// Migrate runs one step of the pending migration over at most limit records and saves where it
+// stopped. A transaction does not read its own writes on a peer, so a step never follows another
+// one in the same transaction, even once the previous version is done: callers call Migrate
+// again, each time in a new transaction, until the returned version is the Latest.
+func Migrate(stub shim.ChaincodeStubInterface, registry []Migration, limit int) (State, int, error) {
+	state, err := LoadState(stub)
+	if err != nil {
+		return state, 0, err
+	}
+	latest := Latest(registry)
+	if state.Version > latest {
+		return state, 0, &NewerVersionError{Version: state.Version, Latest: latest}
+	}
+	if state.Version == latest {
+		return state, 0, nil
+	}
+
+	m := registry[state.Version]
+	fmt.Printf("Migrating to schema version %d (%s) resumeKey='%s'\n", m.Version, m.Description, state.ResumeKey)
+	next, processed, err := m.Step(stub, state.ResumeKey, limit)
+	if err != nil {
+		return state, processed, &StepError{Version: m.Version, Err: err}
+	}
+	state.ResumeKey = next
+	if next == "" {
+		state.Version = m.Version
+	}
+
+	if err := SaveState(stub, state); err != nil {
+		return state, processed, err
+	}
+	return state, processed, nil
+}
+
+// Noop is the step of a migration with nothing to rewrite
+func Noop(stub shim.ChaincodeStubInterface, resumeKey string, limit int) (string, int, error) {
+	return "", 0, nil
+}
+
+// Range returns a migration step passing the simple keys to fn, which rewrites or moves the
+// records it migrates and leaves the others as they are
+func Range(fn func(stub shim.ChaincodeStubInterface, key string, value []byte) error) Step {
+	return func(stub shim.ChaincodeStubInterface, resumeKey string, limit int) (string, int, error) {
+		startKey := resumeKey
+		if startKey == "" {
+			// skip the composite keys, which start with 0x00
+			startKey = "\x01"
+		}
+		resultsIterator, err := stub.GetStateByRange(startKey, string(utf8.MaxRune))
+		if err != nil {
+			return "", 0, err
+		}
+		defer resultsIterator.Close()
+
+		processed := 0
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				return "", processed, err
+			}
+			if processed == limit {
+				return queryResponse.Key, processed, nil
+			}
+			if err := fn(stub, queryResponse.Key, queryResponse.Value); err != nil {
+				return "", processed, &KeyError{Key: queryResponse.Key, Err: err}
+			}
+			processed++
+		}
+		return "", processed, nil
+	}
+}
@@ -0,0 +1,97 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/stretchr/testify/assert"
+)
+
+var upperCase = []Migration{
+	{Version: 1, Description: "Versioned schema", Step: Noop},
+	{Version: 2, Description: "Upper case values", Step: Range(func(stub shim.ChaincodeStubInterface, key string, value []byte) error {
+		if string(value) == "corrupted" {
+			return errors.New("cannot be upper cased")
+		}
+		return stub.PutState(key, []byte(strings.ToUpper(string(value))))
+	})},
+}
+
+func newLegacyStub(values ...string) *shimtest.MockStub {
+	stub := shimtest.NewMockStub("schema", nil)
+	stub.MockTransactionStart("legacy")
+	for i, value := range values {
+		stub.PutState(fmt.Sprintf("key%02d", i+1), []byte(value))
+	}
+	stub.MockTransactionEnd("legacy")
+	return stub
+}
+
+func migrate(t *testing.T, stub *shimtest.MockStub, registry []Migration, limit int) (State, int) {
+	stub.MockTransactionStart("migrate")
+	defer stub.MockTransactionEnd("migrate")
+	state, processed, err := Migrate(stub, registry, limit)
+	assert.Nil(t, err, "Migrate failed")
+	return state, processed
+}
+
+func TestMigrateOneVersionPerCall(t *testing.T) {
+	stub := newLegacyStub("value01", "value02", "value03")
+
+	state, processed := migrate(t, stub, upperCase, 10)
+	assert.Equal(t, State{Version: 1}, state, "the next version should wait for the next call")
+	assert.Equal(t, 0, processed)
+
+	state, processed = migrate(t, stub, upperCase, 2)
+	assert.Equal(t, State{Version: 1, ResumeKey: "key03"}, state)
+	assert.Equal(t, 2, processed)
+	value, _ := stub.GetState("key02")
+	assert.Equal(t, "VALUE02", string(value))
+
+	state, processed = migrate(t, stub, upperCase, 2)
+	assert.Equal(t, State{Version: 2}, state)
+	assert.Equal(t, 1, processed)
+
+	state, processed = migrate(t, stub, upperCase, 2)
+	assert.Equal(t, State{Version: 2}, state, "a migrated ledger has nothing to do")
+	assert.Equal(t, 0, processed)
+
+	loaded, err := LoadState(stub)
+	assert.Nil(t, err)
+	assert.Equal(t, state, loaded)
+}
+
+func TestMigrateErrors(t *testing.T) {
+	stub := newLegacyStub("value01", "corrupted")
+	migrate(t, stub, upperCase, 10)
+
+	stub.MockTransactionStart("migrate")
+	_, _, err := Migrate(stub, upperCase, 10)
+	stub.MockTransactionEnd("migrate")
+	stepErr := &StepError{}
+	assert.True(t, errors.As(err, &stepErr), "a failed step should be a StepError")
+	assert.Equal(t, 2, stepErr.Version)
+	keyErr := &KeyError{}
+	assert.True(t, errors.As(err, &keyErr), "a failed record should be a KeyError")
+	assert.Equal(t, "key02", keyErr.Key)
+
+	// a chaincode knowing only the first version
+	stub.MockTransactionStart("migrate")
+	SaveState(stub, State{Version: 2})
+	stub.MockTransactionEnd("migrate")
+	_, _, err = Migrate(stub, upperCase[:1], 10)
+	assert.IsType(t, &NewerVersionError{}, err)
+	assert.NotNil(t, Check(stub, upperCase[:1]), "Check should refuse an older chaincode")
+	assert.Nil(t, Check(stub, upperCase))
+
+	stub.MockTransactionStart("migrate")
+	versionKey, _ := stub.CreateCompositeKey(versionObjectType, []string{})
+	stub.PutState(versionKey, []byte("two"))
+	stub.MockTransactionEnd("migrate")
+	_, err = LoadVersion(stub)
+	assert.IsType(t, &MalformedVersionError{}, err)
+}